@@ -1,10 +1,12 @@
 package main
 
 import (
-	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"phonebook/internal/api-gateway/http/client"
 	"phonebook/internal/contacts"
 	"strconv"
@@ -18,14 +20,21 @@ func main() {
 
 	baseURL := os.Args[1]
 	c := client.NewClient(baseURL)
+	resumeSession(c, baseURL)
 
-	scanner := bufio.NewScanner(os.Stdin)
+	lines := newLineReader(c)
 	fmt.Println("Phone Book Client. Type 'help' for commands.")
+	if c.Tokens() == nil && os.Getenv("PHONEBOOK_API_KEY") == "" {
+		fmt.Println("Not logged in. Type 'login <username>' to sign in.")
+	}
 
 	for {
-		fmt.Print("> ")
-		scanner.Scan()
-		input := strings.TrimSpace(scanner.Text())
+		input, err := lines.readLine("> ")
+		if err != nil {
+			fmt.Println("Exiting client.")
+			return
+		}
+		input = strings.TrimSpace(input)
 		if input == "" {
 			continue
 		}
@@ -34,12 +43,56 @@ func main() {
 		command := args[0]
 
 		switch command {
+		case "login":
+			login(c, lines, baseURL, args[1:])
+		case "logout":
+			logout(c)
+		case "apikeys":
+			listAPIKeys(c)
+		case "apikey":
+			apiKeyCommand(c, args[1:])
 		case "add":
 			addContact(c, args[1:])
+		case "get":
+			getContact(c, args[1:])
 		case "update":
 			updateContact(c, args[1:])
+		case "delete":
+			deleteContact(c, args[1:])
+		case "trash":
+			listTrash(c)
+		case "restore":
+			restoreContact(c, args[1:])
+		case "purge":
+			purgeContact(c, args[1:])
+		case "history":
+			showHistory(c, args[1:])
+		case "revert":
+			revertContact(c, args[1:])
+		case "list":
+			listContacts(c, args[1:])
+		case "tag":
+			tagCommand(c, args[1:])
+		case "tags":
+			listTags(c)
+		case "group":
+			groupCommand(c, args[1:])
+		case "groups":
+			listGroups(c)
 		case "search":
 			searchContacts(c, args[1:])
+		case "lookup":
+			lookupNumber(c, args[1:])
+		case "duplicates":
+			findDuplicates(c, args[1:])
+		case "merge":
+			mergeContacts(c, args[1:])
+		case "import":
+			importContacts(c, args[1:])
+		case "import-csv":
+			importCSV(c, args[1:])
+		case "export":
+			exportContacts(c, args[1:])
 		case "help":
 			printHelp()
 		case "exit":
@@ -53,31 +106,128 @@ func main() {
 
 func addContact(c *client.Client, args []string) {
 	if len(args) < 3 {
-		fmt.Println("Usage: add <first_name> <last_name> <phone_numbers...>")
+		fmt.Println("Usage: add <first_name> <last_name> <phones...>")
 		return
 	}
 
 	firstName, lastName := args[0], args[1]
-	phoneNumbers := args[2:]
 
 	contact := contacts.Contact{
-		FirstName:    firstName,
-		LastName:     lastName,
-		PhoneNumbers: phoneNumbers,
+		FirstName: firstName,
+		LastName:  lastName,
+		Phones:    parsePhones(args[2:]),
 	}
 
-	id, err := c.AddContact(&contact)
+	id, duplicates, err := c.AddContactCheckingDuplicates(&contact)
 	if err != nil {
 		fmt.Printf("Error adding contact: %v\n", err)
 		return
 	}
 
 	fmt.Printf("Contact created with ID %d\n", id)
+	for _, d := range duplicates {
+		fmt.Printf("Warning: possible duplicate of contact %d (%s %s), score %.2f: %s\n",
+			d.Contact.ID, d.Contact.FirstName, d.Contact.LastName, d.Score, formatReasons(d.Reasons))
+	}
+}
+
+func findDuplicates(c *client.Client, args []string) {
+	minScore := contacts.DefaultDuplicateScore
+	if len(args) > 0 {
+		var err error
+		if minScore, err = strconv.ParseFloat(args[0], 64); err != nil {
+			fmt.Println("Usage: duplicates [min_score]")
+			return
+		}
+	}
+
+	clusters, err := c.FindDuplicates(minScore)
+	if err != nil {
+		fmt.Printf("Error finding duplicates: %v\n", err)
+		return
+	}
+	if len(clusters) == 0 {
+		fmt.Println("No duplicates found")
+		return
+	}
+
+	for i, cluster := range clusters {
+		fmt.Printf("Cluster %d, score %.2f:\n", i+1, cluster.Score)
+		for _, contact := range cluster.Contacts {
+			fmt.Print("  ")
+			printContact(contact)
+		}
+		for _, pair := range cluster.Pairs {
+			fmt.Printf("  %d and %d, score %.2f: %s\n", pair.A, pair.B, pair.Score, formatReasons(pair.Reasons))
+		}
+	}
+}
+
+func mergeContacts(c *client.Client, args []string) {
+	usage := "Usage: merge [--keep-longest] <survivor_id> <id...>"
+	req := contacts.MergeRequest{}
+	if len(args) > 0 && args[0] == "--keep-longest" {
+		req.Rules = map[string]string{
+			"first_name": contacts.MergeKeepLongest,
+			"last_name":  contacts.MergeKeepLongest,
+			"note":       contacts.MergeKeepLongest,
+		}
+		args = args[1:]
+	}
+	if len(args) < 2 {
+		fmt.Println(usage)
+		return
+	}
+
+	ids, err := parseIDs(args)
+	if err != nil {
+		fmt.Println(usage)
+		return
+	}
+	req.SurvivorID, req.ContactIDs = ids[0], ids[1:]
+
+	result, err := c.MergeContacts(req)
+	if err != nil {
+		fmt.Printf("Error merging contacts: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Merged into contact %d:\n", result.Contact.ID)
+	printContact(result.Contact)
+}
+
+func formatReasons(reasons []contacts.MatchReason) string {
+	parts := make([]string, len(reasons))
+	for i, r := range reasons {
+		parts[i] = r.Reason + " (" + r.Detail + ")"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func getContact(c *client.Client, args []string) {
+	if len(args) < 1 {
+		fmt.Println("Usage: get <id>")
+		return
+	}
+
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		fmt.Println("Invalid ID")
+		return
+	}
+
+	contact, err := c.GetContact(id)
+	if err != nil {
+		fmt.Printf("Error getting contact: %v\n", err)
+		return
+	}
+
+	printContact(*contact)
 }
 
 func updateContact(c *client.Client, args []string) {
 	if len(args) < 4 {
-		fmt.Println("Usage: update <id> <first_name> <last_name> <phone_numbers...>")
+		fmt.Println("Usage: update <id> <first_name> <last_name> <phones...>")
 		return
 	}
 
@@ -87,17 +237,23 @@ func updateContact(c *client.Client, args []string) {
 		return
 	}
 
-	firstName, lastName := args[1], args[2]
-	phoneNumbers := args[3:]
-
-	contact := contacts.Contact{
-		ID:           id,
-		FirstName:    firstName,
-		LastName:     lastName,
-		PhoneNumbers: phoneNumbers,
+	// Start from the stored contact so emails, addresses and websites are kept
+	contact, err := c.GetContact(id)
+	if err != nil {
+		fmt.Printf("Error fetching contact: %v\n", err)
+		return
 	}
+	contact.FirstName, contact.LastName = args[1], args[2]
+	contact.Phones = parsePhones(args[3:])
 
-	if err := c.UpdateContact(&contact); err != nil {
+	err = c.UpdateContact(contact)
+	var mismatch *contacts.VersionMismatchError
+	if errors.As(err, &mismatch) {
+		fmt.Println("Contact was changed by someone else, nothing was updated. Latest copy:")
+		printContact(*mismatch.Current)
+		return
+	}
+	if err != nil {
 		fmt.Printf("Error updating contact: %v\n", err)
 		return
 	}
@@ -105,30 +261,688 @@ func updateContact(c *client.Client, args []string) {
 	fmt.Println("Contact updated successfully")
 }
 
-func searchContacts(c *client.Client, args []string) {
+func deleteContact(c *client.Client, args []string) {
 	if len(args) < 1 {
-		fmt.Println("Usage: search <query>")
+		fmt.Println("Usage: delete <id>")
 		return
 	}
 
-	query := args[0]
-	contacts, err := c.SearchContacts(query)
+	id, err := strconv.Atoi(args[0])
 	if err != nil {
-		fmt.Printf("Error searching contacts: %v\n", err)
+		fmt.Println("Invalid ID")
+		return
+	}
+
+	if err := c.DeleteContact(id); err != nil {
+		fmt.Printf("Error deleting contact: %v\n", err)
+		return
+	}
+
+	fmt.Println("Contact moved to trash")
+}
+
+func listTrash(c *client.Client) {
+	opts := contacts.ListOptions{}
+	for {
+		page, err := c.ListTrash(opts)
+		if err != nil {
+			fmt.Printf("Error listing trash: %v\n", err)
+			return
+		}
+		for _, contact := range page.Contacts {
+			printContact(contact)
+		}
+		if page.NextCursor == "" {
+			return
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+func restoreContact(c *client.Client, args []string) {
+	if len(args) < 1 {
+		fmt.Println("Usage: restore <id>")
+		return
+	}
+
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		fmt.Println("Invalid ID")
+		return
+	}
+
+	if err := c.RestoreContact(id); err != nil {
+		fmt.Printf("Error restoring contact: %v\n", err)
+		return
+	}
+
+	fmt.Println("Contact restored successfully")
+}
+
+func purgeContact(c *client.Client, args []string) {
+	if len(args) < 1 {
+		fmt.Println("Usage: purge <id>|--all")
+		return
+	}
+
+	if args[0] == "--all" {
+		purged, err := c.EmptyTrash()
+		if err != nil {
+			fmt.Printf("Error emptying trash: %v\n", err)
+			return
+		}
+		fmt.Printf("Purged %d contacts\n", purged)
+		return
+	}
+
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		fmt.Println("Invalid ID")
+		return
+	}
+
+	if err := c.PurgeContact(id); err != nil {
+		fmt.Printf("Error purging contact: %v\n", err)
+		return
+	}
+
+	fmt.Println("Contact purged successfully")
+}
+
+func showHistory(c *client.Client, args []string) {
+	usage := "Usage: history <id> [<from> <to>]"
+	if len(args) != 1 && len(args) != 3 {
+		fmt.Println(usage)
+		return
+	}
+
+	ids := make([]int, len(args))
+	for i, arg := range args {
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			fmt.Println(usage)
+			return
+		}
+		ids[i] = n
+	}
+
+	if len(ids) == 3 {
+		diff, err := c.DiffRevisions(ids[0], ids[1], ids[2])
+		if err != nil {
+			fmt.Printf("Error comparing revisions: %v\n", err)
+			return
+		}
+		fmt.Printf("Changes from revision %d to %d:\n", diff.From, diff.To)
+		printChanges(diff.Changes)
+		return
+	}
+
+	revisions, err := c.History(ids[0])
+	if err != nil {
+		fmt.Printf("Error getting history: %v\n", err)
+		return
+	}
+	for _, rev := range revisions {
+		fmt.Printf("#%d %s %s by %s\n", rev.Revision, rev.CreatedAt.Format("2006-01-02 15:04:05"), rev.Action, rev.Actor)
+		changes, err := contacts.DiffContacts(rev.Before, rev.After)
+		if err != nil {
+			fmt.Printf("  Error comparing revision: %v\n", err)
+			continue
+		}
+		printChanges(changes)
+	}
+}
+
+func revertContact(c *client.Client, args []string) {
+	if len(args) != 2 {
+		fmt.Println("Usage: revert <id> <revision>")
+		return
+	}
+
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		fmt.Println("Invalid ID")
+		return
+	}
+	revision, err := strconv.Atoi(args[1])
+	if err != nil {
+		fmt.Println("Invalid revision")
+		return
+	}
+
+	contact, err := c.RevertContact(id, revision)
+	var conflict *contacts.PhoneConflictError
+	if errors.As(err, &conflict) {
+		fmt.Println("Could not revert, phone numbers now belong to other contacts:")
+		for _, dup := range conflict.Conflicts {
+			if dup.Trashed {
+				fmt.Printf("  %s: contact %d in the trash, restore or purge it first\n", dup.Number, dup.Owner.ID)
+				continue
+			}
+			fmt.Printf("  %s: contact %d %s %s\n", dup.Number, dup.Owner.ID, dup.Owner.FirstName, dup.Owner.LastName)
+		}
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error reverting contact: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Contact reverted to revision %d\n", revision)
+	printContact(*contact)
+}
+
+func printChanges(changes []contacts.FieldChange) {
+	if len(changes) == 0 {
+		fmt.Println("  No changes")
+	}
+	for _, change := range changes {
+		if change.Added == nil && change.Removed == nil {
+			fmt.Printf("  %s: %s -> %s\n", change.Field, change.From, change.To)
+			continue
+		}
+		for _, item := range change.Removed {
+			fmt.Printf("  %s: - %s\n", change.Field, item)
+		}
+		for _, item := range change.Added {
+			fmt.Printf("  %s: + %s\n", change.Field, item)
+		}
+	}
+}
+
+func listContacts(c *client.Client, args []string) {
+	opts := contacts.ListOptions{}
+	if len(args) > 1 && args[0] == "--tag" {
+		opts.Tag = args[1]
+		args = args[2:]
+	}
+	if len(args) > 0 {
+		opts.Sort = args[0]
+	}
+	if len(args) > 1 {
+		opts.Desc = args[1] == "desc"
+	}
+
+	it := c.IterateContacts(contacts.SearchQuery{}, opts)
+	for it.Next() {
+		printContact(it.Contact())
+	}
+	if err := it.Err(); err != nil {
+		fmt.Printf("Error listing contacts: %v\n", err)
+	}
+}
+
+func listTags(c *client.Client) {
+	tags, err := c.ListTags()
+	if err != nil {
+		fmt.Printf("Error listing tags: %v\n", err)
+		return
+	}
+	if len(tags) == 0 {
+		fmt.Println("No tags")
+		return
+	}
+
+	for _, tag := range tags {
+		fmt.Printf("%s (%d contacts)\n", tag.Name, tag.Contacts)
+	}
+}
+
+func tagCommand(c *client.Client, args []string) {
+	usage := "Usage: tag add|rm <id,...> <tag> | tag rename <tag> <new_tag> | tag delete <tag>"
+	if len(args) < 2 {
+		fmt.Println(usage)
+		return
+	}
+
+	switch args[0] {
+	case "add", "rm":
+		if len(args) < 3 {
+			fmt.Println(usage)
+			return
+		}
+		ids, err := parseIDs(strings.Split(args[1], ","))
+		if err != nil {
+			fmt.Println("Invalid ID")
+			return
+		}
+		name := strings.Join(args[2:], " ")
+
+		if args[0] == "add" {
+			tagged, err := c.TagContacts(name, ids)
+			if err != nil {
+				fmt.Printf("Error tagging contacts: %v\n", err)
+				return
+			}
+			fmt.Printf("Tagged %d contacts with %s\n", tagged, name)
+			return
+		}
+		untagged, err := c.UntagContacts(name, ids)
+		if err != nil {
+			fmt.Printf("Error untagging contacts: %v\n", err)
+			return
+		}
+		fmt.Printf("Removed %s from %d contacts\n", name, untagged)
+	case "rename":
+		if len(args) < 3 {
+			fmt.Println(usage)
+			return
+		}
+		tag, err := c.RenameTag(args[1], strings.Join(args[2:], " "))
+		if err != nil {
+			fmt.Printf("Error renaming tag: %v\n", err)
+			return
+		}
+		fmt.Printf("Tag renamed to %s\n", tag.Name)
+	case "delete":
+		if err := c.DeleteTag(strings.Join(args[1:], " ")); err != nil {
+			fmt.Printf("Error deleting tag: %v\n", err)
+			return
+		}
+		fmt.Println("Tag deleted successfully")
+	default:
+		fmt.Println(usage)
+	}
+}
+
+func listGroups(c *client.Client) {
+	groups, err := c.ListGroups()
+	if err != nil {
+		fmt.Printf("Error listing groups: %v\n", err)
+		return
+	}
+	if len(groups) == 0 {
+		fmt.Println("No groups")
+		return
+	}
+
+	for _, group := range groups {
+		fmt.Printf("%s: %s\n", group.Name, formatGroup(group))
+	}
+}
+
+func formatGroup(group contacts.Group) string {
+	parts := []string{}
+	if group.Query.Text != "" {
+		query := fmt.Sprintf("%q", group.Query.Text)
+		if group.Query.Mode != "" {
+			query += " (" + group.Query.Mode + ")"
+		}
+		parts = append(parts, query)
+	}
+	if group.Tag != "" {
+		parts = append(parts, "tagged "+group.Tag)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func groupCommand(c *client.Client, args []string) {
+	usage := "Usage: group add|update <name> [--tag <tag>] [--fuzzy] [query...] | group show <name> | group delete <name>"
+	if len(args) < 2 {
+		fmt.Println(usage)
+		return
+	}
+	name := args[1]
+
+	switch args[0] {
+	case "add", "update":
+		group := contacts.Group{Name: name}
+		rest := args[2:]
+		for len(rest) > 0 && strings.HasPrefix(rest[0], "--") {
+			switch {
+			case rest[0] == "--fuzzy":
+				group.Query.Mode = contacts.SearchModeFuzzy
+				rest = rest[1:]
+			case rest[0] == "--tag" && len(rest) > 1:
+				group.Tag = rest[1]
+				rest = rest[2:]
+			default:
+				fmt.Println(usage)
+				return
+			}
+		}
+		group.Query.Text = strings.Join(rest, " ")
+
+		var err error
+		if args[0] == "add" {
+			err = c.CreateGroup(&group)
+		} else {
+			err = c.UpdateGroup(name, &group)
+		}
+		if err != nil {
+			fmt.Printf("Error saving group: %v\n", err)
+			return
+		}
+		fmt.Printf("Group %s saved: %s\n", group.Name, formatGroup(group))
+	case "show":
+		it := c.IterateGroup(name, contacts.ListOptions{})
+		for it.Next() {
+			printContact(it.Contact())
+		}
+		if err := it.Err(); err != nil {
+			fmt.Printf("Error listing group: %v\n", err)
+		}
+	case "delete":
+		if err := c.DeleteGroup(name); err != nil {
+			fmt.Printf("Error deleting group: %v\n", err)
+			return
+		}
+		fmt.Println("Group deleted successfully")
+	default:
+		fmt.Println(usage)
+	}
+}
+
+func searchContacts(c *client.Client, args []string) {
+	query := contacts.SearchQuery{}
+	if len(args) > 0 && args[0] == "--fuzzy" {
+		query.Mode = contacts.SearchModeFuzzy
+		args = args[1:]
+	}
+	if len(args) < 1 {
+		fmt.Println("Usage: search [--fuzzy] <query...>")
 		return
 	}
+	query.Text = strings.Join(args, " ")
 
 	fmt.Println("Search Results:")
-	for _, contact := range contacts {
-		fmt.Printf("ID: %d, Name: %s %s, Phones: %v\n", contact.ID, contact.FirstName, contact.LastName, contact.PhoneNumbers)
+	it := c.IterateContacts(query, contacts.ListOptions{})
+	for it.Next() {
+		printContact(it.Contact())
+	}
+	if err := it.Err(); err != nil {
+		fmt.Printf("Error searching contacts: %v\n", err)
+		// Point at the offending token of the query
+		var queryErr *contacts.QueryError
+		if errors.As(err, &queryErr) && queryErr.Position > 0 {
+			fmt.Printf("  %s\n  %s^\n", query.Text, strings.Repeat(" ", queryErr.Position-1))
+		}
+	}
+}
+
+func lookupNumber(c *client.Client, args []string) {
+	if len(args) < 1 {
+		fmt.Println("Usage: lookup <number>")
+		return
+	}
+
+	result, err := c.Lookup(strings.Join(args, " "))
+	if errors.Is(err, contacts.ErrNotFound) {
+		fmt.Println("No contact has this number")
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error looking up number: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Matched %s: %s (%s match)\n", result.Label, result.Number, result.Match)
+	printContact(result.Contact)
+}
+
+func importContacts(c *client.Client, args []string) {
+	if len(args) < 1 {
+		fmt.Println("Usage: import <file>")
+		return
+	}
+
+	file, err := os.Open(args[0])
+	if err != nil {
+		fmt.Printf("Error opening file: %v\n", err)
+		return
+	}
+	defer file.Close()
+
+	report, err := c.ImportVCards(file)
+	if err != nil {
+		fmt.Printf("Error importing contacts: %v\n", err)
+		return
+	}
+
+	for _, r := range report.Results {
+		switch r.Status {
+		case contacts.ImportCreated:
+			fmt.Printf("Card %d (%s): created with ID %d\n", r.Card, r.Name, r.ID)
+		default:
+			fmt.Printf("Card %d (%s): %s, %s\n", r.Card, r.Name, r.Status, r.Reason)
+		}
+	}
+	fmt.Printf("Imported %d contacts, skipped %d, failed %d\n", report.Created, report.Skipped, report.Failed)
+}
+
+func importCSV(c *client.Client, args []string) {
+	usage := "Usage: import-csv [--dry-run] [--format auto|google|outlook|generic] [--mapping <mapping.json>] <file>"
+
+	var opts contacts.CSVImportOptions
+	for len(args) > 1 && strings.HasPrefix(args[0], "--") {
+		switch args[0] {
+		case "--dry-run":
+			opts.DryRun = true
+			args = args[1:]
+		case "--format":
+			opts.Format = args[1]
+			args = args[2:]
+		case "--mapping":
+			data, err := os.ReadFile(args[1])
+			if err != nil {
+				fmt.Printf("Error reading mapping: %v\n", err)
+				return
+			}
+			if err := json.Unmarshal(data, &opts.Mapping); err != nil {
+				fmt.Printf("Invalid mapping: %v\n", err)
+				return
+			}
+			args = args[2:]
+		default:
+			fmt.Println(usage)
+			return
+		}
+	}
+	if len(args) != 1 {
+		fmt.Println(usage)
+		return
+	}
+
+	file, err := os.Open(args[0])
+	if err != nil {
+		fmt.Printf("Error opening file: %v\n", err)
+		return
+	}
+	defer file.Close()
+
+	report, err := c.ImportCSV(file, opts)
+	if err != nil {
+		fmt.Printf("Error importing contacts: %v\n", err)
+		return
+	}
+
+	// Created rows are only counted; problems are listed with their line
+	for _, r := range report.Results {
+		if r.Status != contacts.ImportCreated {
+			fmt.Printf("Line %d (%s): %s, %s\n", r.Line, strings.TrimSpace(r.Name), r.Status, r.Reason)
+		}
+	}
+	total := len(report.Results)
+	if report.DryRun {
+		fmt.Printf("Dry run of %d rows: %d would be created, %d skipped, %d failed\n", total, report.Created, report.Skipped, report.Failed)
+		return
+	}
+	fmt.Printf("Processed %d rows: %d created, %d skipped, %d failed\n", total, report.Created, report.Skipped, report.Failed)
+}
+
+func exportContacts(c *client.Client, args []string) {
+	usage := "Usage: export [--format vcf|csv|json|ndjson] [--fields <field,...>] [--group <name>] <file> [query...]"
+
+	var format, group string
+	var fields []string
+	for len(args) > 2 && strings.HasPrefix(args[0], "--") {
+		switch args[0] {
+		case "--format":
+			format = args[1]
+		case "--fields":
+			fields = strings.Split(args[1], ",")
+		case "--group":
+			group = args[1]
+		default:
+			fmt.Println(usage)
+			return
+		}
+		args = args[2:]
+	}
+	if len(args) < 1 || strings.HasPrefix(args[0], "--") {
+		fmt.Println(usage)
+		return
+	}
+
+	// Without --format the file extension picks the format
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(args[0]), ".")
+	}
+	switch format = strings.ToLower(format); format {
+	case "vcf", "vcard":
+		format = "vcf"
+		if len(fields) > 0 {
+			fmt.Println("--fields only applies to csv, json and ndjson exports")
+			return
+		}
+	case contacts.ExportCSV, contacts.ExportJSON, contacts.ExportNDJSON:
+	default:
+		fmt.Printf("Unknown export format %q; use vcf, csv, json or ndjson\n", format)
+		return
+	}
+
+	file, err := os.Create(args[0])
+	if err != nil {
+		fmt.Printf("Error creating file: %v\n", err)
+		return
+	}
+	defer file.Close()
+
+	query := contacts.SearchQuery{Text: strings.Join(args[1:], " ")}
+	switch {
+	case group != "":
+		err = c.ExportGroup(file, group, format, fields)
+	case format == "vcf":
+		err = c.ExportVCards(file, query)
+	default:
+		err = c.ExportContacts(file, format, fields, query)
+	}
+	if err != nil {
+		// Leave no partial export behind
+		file.Close()
+		os.Remove(args[0])
+		fmt.Printf("Error exporting contacts: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Contacts exported to %s\n", args[0])
+}
+
+// parsePhones reads phone arguments of the form [label:]number[xEXT], e.g.
+// "work:021-8888-1234x12". The first number is the primary one.
+func parsePhones(args []string) []contacts.Phone {
+	phones := make([]contacts.Phone, 0, len(args))
+	for _, arg := range args {
+		var phone contacts.Phone
+		if label, number, ok := strings.Cut(arg, ":"); ok {
+			phone.Label, arg = label, number
+		}
+		phone.Number, phone.Extension, _ = strings.Cut(arg, "x")
+		phones = append(phones, phone)
+	}
+	return phones
+}
+
+// parseIDs parses each argument as a contact ID, failing on the first one
+// that is not a number
+func parseIDs(args []string) ([]int, error) {
+	ids := make([]int, len(args))
+	for i, arg := range args {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+func printContact(contact contacts.Contact) {
+	phones := make([]string, 0, len(contact.Phones))
+	for _, p := range contact.Phones {
+		phone := p.Label + ": " + p.Number
+		if p.Extension != "" {
+			phone += " ext. " + p.Extension
+		}
+		if p.Primary {
+			phone += " (primary)"
+		}
+		phones = append(phones, phone)
+	}
+
+	line := fmt.Sprintf("ID: %d, Name: %s %s, Phones: [%s]", contact.ID, contact.FirstName, contact.LastName, strings.Join(phones, ", "))
+	if len(contact.Emails) > 0 {
+		emails := make([]string, 0, len(contact.Emails))
+		for _, e := range contact.Emails {
+			emails = append(emails, e.Label+": "+e.Address)
+		}
+		line += fmt.Sprintf(", Emails: [%s]", strings.Join(emails, ", "))
+	}
+	for _, a := range contact.Addresses {
+		parts := []string{}
+		for _, part := range []string{a.Street, a.City, a.Region, a.PostalCode, a.Country} {
+			if part != "" {
+				parts = append(parts, part)
+			}
+		}
+		line += fmt.Sprintf(", Address (%s): %s", a.Label, strings.Join(parts, ", "))
+	}
+	for _, w := range contact.Websites {
+		line += fmt.Sprintf(", Website (%s): %s", w.Label, w.URL)
+	}
+	if len(contact.Tags) > 0 {
+		line += fmt.Sprintf(", Tags: [%s]", strings.Join(contact.Tags, ", "))
+	}
+	if contact.Score > 0 {
+		line += fmt.Sprintf(", Score: %.2f", contact.Score)
 	}
+	fmt.Println(line)
 }
 
 func printHelp() {
 	fmt.Println("Commands:")
-	fmt.Println("  add <first_name> <last_name> <phone_numbers...> - Add a new contact")
-	fmt.Println("  update <id> <first_name> <last_name> <phone_numbers...> - Update a contact")
-	fmt.Println("  search <query> - Search contacts by name or phone number")
+	fmt.Println("  login <username> - Sign in; the session is kept for later runs against the same server")
+	fmt.Println("  logout - Sign out and forget the session")
+	fmt.Println("  apikeys - List the API keys, with their scope and when they were last used (admins only)")
+	fmt.Println("  apikey create <name> read-only|read-write|import - Create an API key for a script or service (admins only)")
+	fmt.Println("  apikey revoke <id> - Stop an API key from being accepted (admins only)")
+	fmt.Println("  add <first_name> <last_name> <phones...> - Add a new contact, warning about likely duplicates; phones are [label:]number[xEXT]")
+	fmt.Println("  get <id> - Show a single contact")
+	fmt.Println("  update <id> <first_name> <last_name> <phones...> - Update a contact")
+	fmt.Println("  delete <id> - Move a contact to the trash")
+	fmt.Println("  trash - List the contacts in the trash")
+	fmt.Println("  restore <id> - Move a contact out of the trash")
+	fmt.Println("  purge <id>|--all - Permanently remove a contact, or every contact, from the trash")
+	fmt.Println("  history <id> [<from> <to>] - Show who changed a contact and when, or the changes between two revisions")
+	fmt.Println("  revert <id> <revision> - Restore the names and phone numbers of a contact to a revision")
+	fmt.Println("  list [--tag <tag>] [first_name|last_name|created_at] [asc|desc] - List all contacts, or those with a tag")
+	fmt.Println("  tags - List the tags and how many contacts carry each")
+	fmt.Println("  tag add|rm <id,...> <tag> - Add a tag to contacts, creating it when needed, or remove it from them")
+	fmt.Println("  tag rename <tag> <new_tag> - Rename a tag")
+	fmt.Println("  tag delete <tag> - Delete a tag and remove it from every contact")
+	fmt.Println("  groups - List the saved search groups")
+	fmt.Println("  group add|update <name> [--tag <tag>] [--fuzzy] [query...] - Save a search as a group, limited to a tag when given")
+	fmt.Println("  group show <name> - List the contacts currently in a group")
+	fmt.Println("  group delete <name> - Delete a group, keeping its contacts")
+	fmt.Println("  lookup <number> - Find who a phone number belongs to, by the exact number, else its national number or last digits")
+	fmt.Println("  search [--fuzzy] <query...> - Search contacts by name or phone number, e.g. last:Doe* AND NOT phone:0912*, or ranked by relevance with --fuzzy")
+	fmt.Println("  duplicates [min_score] - List groups of contacts that are probably the same person")
+	fmt.Println("  merge [--keep-longest] <survivor_id> <id...> - Merge contacts into the survivor, combining their phones and details; --keep-longest keeps the longest names and note")
+	fmt.Println("  import <file> - Import contacts from a vCard file")
+	fmt.Println("  import-csv [--dry-run] [--format auto|google|outlook|generic] [--mapping <mapping.json>] <file> - Import contacts from a CSV file")
+	fmt.Println("  export [--format vcf|csv|json|ndjson] [--fields <field,...>] [--group <name>] <file> [query...] - Export all contacts, or those matching query or in a group; the format defaults to the file extension")
 	fmt.Println("  help - Show available commands")
 	fmt.Println("  exit - Exit the client")
+	fmt.Println("Set PHONEBOOK_API_KEY to use an API key instead of logging in.")
+	fmt.Println("Tab completes a contact name after search, or its ID after get, update, delete, restore, history and revert.")
 }
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"phonebook/internal/api-gateway/http/client"
+	"phonebook/internal/contacts"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// idCommands take a contact ID as their first argument, so completing a name
+// there inserts the ID of the contact instead
+var idCommands = map[string]bool{
+	"get":     true,
+	"update":  true,
+	"delete":  true,
+	"restore": true,
+	"history": true,
+	"revert":  true,
+}
+
+// lineReader reads command lines. On a terminal they are edited with
+// x/term, which keeps a history and lets Tab complete the line; otherwise,
+// such as when input is piped, whole lines are read.
+type lineReader struct {
+	in       *bufio.Reader
+	fd       int
+	terminal *term.Terminal
+}
+
+func newLineReader(c *client.Client) *lineReader {
+	r := &lineReader{in: bufio.NewReader(os.Stdin), fd: int(os.Stdin.Fd())}
+	if !term.IsTerminal(r.fd) {
+		return r
+	}
+
+	r.terminal = term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, "")
+	complete := completer(c)
+	r.terminal.AutoCompleteCallback = func(line string, pos int, key rune) (string, int, bool) {
+		if key != '\t' || pos != len(line) {
+			return "", 0, false
+		}
+		completed, candidates := complete(line)
+		if len(candidates) > 0 {
+			r.terminal.Write([]byte(strings.Join(candidates, "\n") + "\n"))
+		}
+		return completed, len(completed), true
+	}
+	return r
+}
+
+// readLine prints prompt and returns the line typed, or io.EOF at the end
+// of input or on Ctrl-D
+func (r *lineReader) readLine(prompt string) (string, error) {
+	if r.terminal == nil {
+		fmt.Print(prompt)
+		return r.readWhole()
+	}
+
+	state, err := term.MakeRaw(r.fd)
+	if err != nil {
+		return "", err
+	}
+	defer term.Restore(r.fd, state)
+
+	r.terminal.SetPrompt(prompt)
+	return r.terminal.ReadLine()
+}
+
+// readPassword prints prompt and returns the line typed without echoing it
+func (r *lineReader) readPassword(prompt string) (string, error) {
+	fmt.Print(prompt)
+	if r.terminal == nil {
+		return r.readWhole()
+	}
+
+	password, err := term.ReadPassword(r.fd)
+	fmt.Print("\n")
+	return string(password), err
+}
+
+// readWhole reads a line from input that is not a terminal
+func (r *lineReader) readWhole() (string, error) {
+	line, err := r.in.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// completer completes the argument of search with a contact name, and the
+// ID argument of idCommands with the ID of the contact named. It returns the
+// completed line, and the suggestions to list when more than one matches.
+func completer(c *client.Client) func(string) (string, []string) {
+	return func(line string) (string, []string) {
+		command, typed, ok := strings.Cut(line, " ")
+		byID := idCommands[command]
+		if !ok || typed == "" || !byID && command != "search" {
+			return line, nil
+		}
+		if byID && typed[0] >= '0' && typed[0] <= '9' {
+			// The ID is already there
+			return line, nil
+		}
+
+		suggestions, err := c.Suggest(typed, 0)
+		if err != nil || len(suggestions) == 0 {
+			return line, nil
+		}
+
+		values := make([]string, len(suggestions))
+		candidates := make([]string, len(suggestions))
+		for i, s := range suggestions {
+			values[i] = s.Name
+			if byID {
+				values[i] = strconv.Itoa(s.ID)
+			}
+			candidates[i] = formatSuggestion(s)
+		}
+
+		if len(values) == 1 {
+			return command + " " + values[0] + " ", nil
+		}
+		if prefix := commonPrefix(values); !byID && len(prefix) > len(typed) {
+			return command + " " + prefix, candidates
+		}
+		return line, candidates
+	}
+}
+
+func formatSuggestion(s contacts.Suggestion) string {
+	if s.Number == "" {
+		return fmt.Sprintf("  %d: %s", s.ID, s.Name)
+	}
+	return fmt.Sprintf("  %d: %s, %s", s.ID, s.Name, s.Number)
+}
+
+// commonPrefix returns the longest prefix shared by all values, never
+// splitting a character
+func commonPrefix(values []string) string {
+	prefix := values[0]
+	for _, v := range values[1:] {
+		n := 0
+		for n < len(prefix) && n < len(v) && prefix[n] == v[n] {
+			n++
+		}
+		prefix = prefix[:n]
+	}
+	return strings.ToValidUTF8(prefix, "")
+}
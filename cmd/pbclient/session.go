@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"phonebook/internal/api-gateway/http/client"
+	"phonebook/internal/auth"
+	"strconv"
+)
+
+// session is the login kept between runs, for the server it was made with
+type session struct {
+	Server string          `json:"server"`
+	Tokens *auth.TokenPair `json:"tokens"`
+}
+
+// sessionPath is where the session is kept, readable by the user only
+func sessionPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "pbclient", "session.json"), nil
+}
+
+// resumeSession logs c in with the session of an earlier run against the
+// same server, and keeps the session current as its tokens are refreshed.
+// An API key in PHONEBOOK_API_KEY is used instead when set.
+func resumeSession(c *client.Client, server string) {
+	if key := os.Getenv("PHONEBOOK_API_KEY"); key != "" {
+		c.SetAPIKey(key)
+		return
+	}
+
+	c.OnRefresh(func(tokens *auth.TokenPair) {
+		if err := saveSession(server, tokens); err != nil {
+			fmt.Printf("Warning: could not save session: %v\n", err)
+		}
+	})
+
+	path, err := sessionPath()
+	if err != nil {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	var s session
+	if json.Unmarshal(data, &s) == nil && s.Server == server && s.Tokens != nil {
+		c.SetTokens(s.Tokens)
+	}
+}
+
+func saveSession(server string, tokens *auth.TokenPair) error {
+	path, err := sessionPath()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(session{Server: server, Tokens: tokens})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+func removeSession() error {
+	path, err := sessionPath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func login(c *client.Client, lines *lineReader, server string, args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: login <username>")
+		return
+	}
+
+	password, err := lines.readPassword("Password: ")
+	if err != nil {
+		return
+	}
+
+	tokens, err := c.Login(args[0], password)
+	if err != nil {
+		fmt.Printf("Error logging in: %v\n", err)
+		return
+	}
+	if err := saveSession(server, tokens); err != nil {
+		fmt.Printf("Warning: could not save session: %v\n", err)
+	}
+	fmt.Printf("Logged in as %s\n", args[0])
+}
+
+func logout(c *client.Client) {
+	c.SetTokens(nil)
+	if err := removeSession(); err != nil {
+		fmt.Printf("Error removing session: %v\n", err)
+		return
+	}
+	fmt.Println("Logged out")
+}
+
+func listAPIKeys(c *client.Client) {
+	keys, err := c.APIKeys()
+	if err != nil {
+		fmt.Printf("Error listing API keys: %v\n", err)
+		return
+	}
+	if len(keys) == 0 {
+		fmt.Println("No API keys")
+		return
+	}
+
+	for _, k := range keys {
+		used := "never used"
+		if k.LastUsedAt != nil {
+			used = "last used " + k.LastUsedAt.Local().Format("2006-01-02 15:04")
+		}
+		state := ""
+		if k.RevokedAt != nil {
+			state = ", revoked"
+		}
+		fmt.Printf("%d: %s (%s...), %s, created by %s, %s%s\n", k.ID, k.Name, k.Prefix, k.Scope, k.CreatedBy, used, state)
+	}
+}
+
+func apiKeyCommand(c *client.Client, args []string) {
+	usage := "Usage: apikey create <name> read-only|read-write|import | apikey revoke <id>"
+	switch {
+	case len(args) == 3 && args[0] == "create":
+		key, created, err := c.CreateAPIKey(args[1], args[2])
+		if err != nil {
+			fmt.Printf("Error creating API key: %v\n", err)
+			return
+		}
+		fmt.Printf("API key %d created for %s; it will not be shown again:\n%s\n", created.ID, created.Name, key)
+	case len(args) == 2 && args[0] == "revoke":
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			fmt.Println(usage)
+			return
+		}
+		if err := c.RevokeAPIKey(id); err != nil {
+			fmt.Printf("Error revoking API key: %v\n", err)
+			return
+		}
+		fmt.Printf("API key %d revoked\n", id)
+	default:
+		fmt.Println(usage)
+	}
+}
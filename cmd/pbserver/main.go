@@ -1,26 +1,114 @@
 package main
 
 import (
+	"bufio"
+	"fmt"
 	"os"
 	"phonebook/internal/api-gateway/http"
+	"phonebook/internal/auth"
+	"phonebook/internal/contacts"
 	"phonebook/utils/configs"
 	"phonebook/utils/postgres"
+	"strings"
+	"time"
 
 	"github.com/rs/zerolog"
 )
 
 func main() {
 	configs.RunConfig(".")
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+
+	// Phone numbers are normalized by migrations too, so set the region first
+	region := configs.C().Phone.DefaultRegion
+	if !contacts.KnownRegion(region) {
+		logger.Fatal().Str("region", region).Msg("Unknown default phone region")
+	}
+	contacts.DefaultRegion = region
+
+	authConfig := configs.C().Auth
+	signer := auth.Signer{
+		Secret:     []byte(authConfig.JWTSecret),
+		AccessTTL:  authConfig.AccessTTL,
+		RefreshTTL: authConfig.RefreshTTL,
+	}
+	if len(signer.Secret) < auth.MinSecretLength {
+		logger.Fatal().Int("min_length", auth.MinSecretLength).Msg("Set auth.jwt_secret or PHONEBOOK_JWT_SECRET to a long random secret")
+	}
+	if signer.AccessTTL <= 0 || signer.RefreshTTL <= 0 {
+		logger.Fatal().Dur("access_ttl", signer.AccessTTL).Dur("refresh_ttl", signer.RefreshTTL).Msg("Token lifetimes must be positive")
+	}
+
 	postgres.RunPostgres()
+	db := postgres.PostgresInstance.DB
+
+	// "pbserver adduser [--admin] <username>" creates a user, such as the
+	// first admin, instead of starting the server
+	if len(os.Args) > 1 && os.Args[1] == "adduser" {
+		if err := addUser(auth.NewService(db, signer), os.Args[2:]); err != nil {
+			logger.Fatal().Err(err).Msg("Could not create user")
+		}
+		return
+	}
 
 	// Initialize router
-	db := postgres.PostgresInstance.DB
-	router := http.NewRouter(db)
+	router := http.NewRouter(db, signer)
+
+	trash := configs.C().Trash
+	if trash.Retention > 0 {
+		if trash.PurgeInterval <= 0 {
+			logger.Fatal().Dur("purge_interval", trash.PurgeInterval).Msg("Trash purge interval must be positive")
+		}
+		go purgeTrash(contacts.NewService(db).As("trash-retention"), trash.Retention, trash.PurgeInterval, logger)
+	}
 
 	// Start the server on port 1234 using zerolog
-	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
 	logger.Info().Msg("Starting server on port 1234...")
 	if err := router.Run(":1234"); err != nil {
 		logger.Fatal().Err(err).Msg("Could not start server")
 	}
 }
+
+// addUser creates the user named in args with a password read from stdin
+func addUser(service *auth.Service, args []string) error {
+	user := auth.NewUser{}
+	if len(args) > 0 && args[0] == "--admin" {
+		user.Admin = true
+		args = args[1:]
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("usage: pbserver adduser [--admin] <username>")
+	}
+	user.Username = args[0]
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		return err
+	}
+	user.Password = strings.TrimRight(password, "\r\n")
+
+	created, err := service.CreateUser(&user)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("User %s created with ID %d\n", created.Username, created.ID)
+	return nil
+}
+
+// purgeTrash permanently removes contacts that have been in the trash for
+// longer than retention, checking every interval
+func purgeTrash(service *contacts.Service, retention, interval time.Duration, logger zerolog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := service.PurgeTrash(retention)
+		if err != nil {
+			logger.Error().Err(err).Msg("Could not purge trash")
+		} else if purged > 0 {
+			logger.Info().Int64("purged", purged).Msg("Purged contacts past their trash retention")
+		}
+		<-ticker.C
+	}
+}
@@ -0,0 +1,213 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"phonebook/internal/auth"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// principalKey is where Authenticate keeps who the request is made by
+const principalKey = "auth.principal"
+
+// importRoutes are the only routes an API key with the import scope may use
+var importRoutes = map[string]bool{
+	"/contacts/import":     true,
+	"/contacts/import/csv": true,
+}
+
+// Authenticate lets a request through only with a valid access token or API
+// key, and records who made it for the handlers. API keys are limited to
+// the routes their scope allows.
+func (h *Handler) Authenticate(c *gin.Context) {
+	principal, err := h.authenticate(c)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrUnauthorized):
+			c.Header("WWW-Authenticate", `Bearer realm="phonebook"`)
+		case errors.Is(err, auth.ErrInvalidToken), errors.Is(err, auth.ErrInvalidAPIKey):
+			c.Header("WWW-Authenticate", `Bearer realm="phonebook", error="invalid_token"`)
+		}
+		c.Error(err).SetMeta("Could not authenticate")
+		c.Abort()
+		return
+	}
+
+	if !scopeAllows(principal.Scope, c.Request.Method, c.FullPath()) {
+		c.Error(auth.ErrInsufficientScope)
+		c.Abort()
+		return
+	}
+
+	c.Set(principalKey, principal)
+	c.Next()
+}
+
+// authenticate reads the credentials of a request: an API key in the
+// X-API-Key header, or a bearer token that is either an API key or an
+// access token
+func (h *Handler) authenticate(c *gin.Context) (*auth.Principal, error) {
+	if key := strings.TrimSpace(c.GetHeader("X-API-Key")); key != "" {
+		return h.auth.AuthenticateKey(key)
+	}
+
+	scheme, token, _ := strings.Cut(c.GetHeader("Authorization"), " ")
+	token = strings.TrimSpace(token)
+	if !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return nil, auth.ErrUnauthorized
+	}
+	if strings.HasPrefix(token, auth.APIKeyPrefix) {
+		return h.auth.AuthenticateKey(token)
+	}
+	return h.auth.Authenticate(token)
+}
+
+// scopeAllows reports whether a request to route may be made with scope.
+// Users have no scope and may make any request.
+func scopeAllows(scope, method, route string) bool {
+	switch scope {
+	case "", auth.ScopeReadWrite:
+		return true
+	case auth.ScopeReadOnly:
+		return method == http.MethodGet || method == http.MethodHead
+	case auth.ScopeImport:
+		return method == http.MethodPost && importRoutes[route]
+	}
+	return false
+}
+
+// RequireAdmin lets a request through only when made by an admin; API keys
+// never are. It must run after Authenticate.
+func (h *Handler) RequireAdmin(c *gin.Context) {
+	if p := currentUser(c); p == nil || !p.Admin {
+		c.Error(auth.ErrForbidden)
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// currentUser returns who the request is authenticated as, or nil on routes
+// that need no authentication
+func currentUser(c *gin.Context) *auth.Principal {
+	if p, ok := c.Get(principalKey); ok {
+		return p.(*auth.Principal)
+	}
+	return nil
+}
+
+// LoginHandler handles signing in with a username and password
+func (h *Handler) LoginHandler(c *gin.Context) {
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Username == "" || req.Password == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	tokens, err := h.auth.Login(req.Username, req.Password)
+	if err != nil {
+		c.Error(err).SetMeta("Could not log in")
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// RefreshHandler handles exchanging a refresh token for new tokens
+func (h *Handler) RefreshHandler(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.RefreshToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	tokens, err := h.auth.Refresh(req.RefreshToken)
+	if err != nil {
+		c.Error(err).SetMeta("Could not refresh token")
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// ListUsersHandler handles listing every user
+func (h *Handler) ListUsersHandler(c *gin.Context) {
+	users, err := h.auth.Users()
+	if err != nil {
+		c.Error(err).SetMeta("Could not list users")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"users": users})
+}
+
+// CreateUserHandler handles the creation of a new user
+func (h *Handler) CreateUserHandler(c *gin.Context) {
+	var user auth.NewUser
+	if err := c.ShouldBindJSON(&user); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	created, err := h.auth.CreateUser(&user)
+	if err != nil {
+		c.Error(err).SetMeta("Could not create user")
+		return
+	}
+
+	c.JSON(http.StatusCreated, created)
+}
+
+// ListAPIKeysHandler handles listing every API key, revoked ones included
+func (h *Handler) ListAPIKeysHandler(c *gin.Context) {
+	keys, err := h.auth.APIKeys()
+	if err != nil {
+		c.Error(err).SetMeta("Could not list API keys")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+// CreateAPIKeyHandler handles the creation of an API key. The key itself is
+// only ever in this response.
+func (h *Handler) CreateAPIKeyHandler(c *gin.Context) {
+	var req auth.NewAPIKey
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	key, created, err := h.auth.CreateAPIKey(&req, actor(c))
+	if err != nil {
+		c.Error(err).SetMeta("Could not create API key")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"key": key, "api_key": created})
+}
+
+// RevokeAPIKeyHandler handles revoking an API key, which is kept listed
+func (h *Handler) RevokeAPIKeyHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	key, err := h.auth.RevokeAPIKey(id)
+	if err != nil {
+		c.Error(err).SetMeta("Could not revoke API key")
+		return
+	}
+
+	c.JSON(http.StatusOK, key)
+}
@@ -0,0 +1,278 @@
+package client
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"phonebook/internal/auth"
+	"strings"
+	"time"
+)
+
+// refreshMargin is how long before it expires an access token is refreshed,
+// so it does not expire on the way to the server
+const refreshMargin = 10 * time.Second
+
+// Login signs in and sends the tokens issued with every later request. It
+// returns auth.ErrInvalidCredentials when the username or password is wrong.
+func (c *Client) Login(username, password string) (*auth.TokenPair, error) {
+	tokens, err := c.requestTokens("/auth/login", map[string]string{"username": username, "password": password})
+	if errors.Is(err, auth.ErrUnauthorized) {
+		return nil, auth.ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.SetTokens(tokens)
+	return tokens, nil
+}
+
+// SetTokens makes the client send tokens from an earlier login, or no
+// tokens when nil
+func (c *Client) SetTokens(tokens *auth.TokenPair) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tokens = tokens
+}
+
+// Tokens returns the tokens the client sends, or nil when not logged in
+func (c *Client) Tokens() *auth.TokenPair {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tokens
+}
+
+// SetAPIKey makes the client send an API key instead of logging in, such as
+// from a script
+func (c *Client) SetAPIKey(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.apiKey = key
+}
+
+// OnRefresh sets fn to be called with the new tokens whenever the client
+// refreshes an expired access token, such as to save them for later runs
+func (c *Client) OnRefresh(fn func(*auth.TokenPair)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onRefresh = fn
+}
+
+// do sends a request with the API key of the client or the access token of
+// the session. An expired access token is refreshed first, and once more
+// when the server rejects it if the body of the request can be sent again.
+// It returns auth.ErrUnauthorized when the server still refuses the request.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	apiKey := c.apiKey
+	c.mu.Unlock()
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+		return checkAuthorized(http.DefaultClient.Do(req))
+	}
+
+	token, err := c.accessToken(false)
+	if err != nil {
+		return nil, err
+	}
+	setBearer(req, token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+	resp.Body.Close()
+	if token == "" || req.Body != nil && req.GetBody == nil {
+		return nil, auth.ErrUnauthorized
+	}
+
+	if token, err = c.accessToken(true); err != nil {
+		return nil, err
+	}
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	setBearer(retry, token)
+	return checkAuthorized(http.DefaultClient.Do(retry))
+}
+
+// checkAuthorized turns a 401 response into auth.ErrUnauthorized
+func checkAuthorized(resp *http.Response, err error) (*http.Response, error) {
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		return nil, auth.ErrUnauthorized
+	}
+	return resp, nil
+}
+
+func setBearer(req *http.Request, token string) {
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// accessToken returns the access token to send, refreshing it first when it
+// is about to expire or refresh is set. It returns an empty token when the
+// client is not logged in.
+func (c *Client) accessToken(refresh bool) (string, error) {
+	c.mu.Lock()
+	tokens, onRefresh := c.tokens, c.onRefresh
+	if tokens == nil || !refresh && !expiresSoon(tokens.AccessToken) {
+		c.mu.Unlock()
+		if tokens == nil {
+			return "", nil
+		}
+		return tokens.AccessToken, nil
+	}
+
+	tokens, err := c.requestTokens("/auth/refresh", map[string]string{"refresh_token": tokens.RefreshToken})
+	if err == nil {
+		c.tokens = tokens
+	}
+	c.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	if onRefresh != nil {
+		onRefresh(tokens)
+	}
+	return tokens.AccessToken, nil
+}
+
+// requestTokens posts credentials to path and returns the tokens issued, or
+// auth.ErrUnauthorized when the server refuses them
+func (c *Client) requestTokens(path string, body interface{}) (*auth.TokenPair, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.Post(c.baseURL+path, "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, auth.ErrUnauthorized
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("failed to log in")
+	}
+
+	var tokens auth.TokenPair
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, err
+	}
+
+	return &tokens, nil
+}
+
+// expiresSoon reads the expiry of a token without verifying it, which only
+// the server can do. Tokens it cannot read are left to the server to judge.
+func expiresSoon(token string) bool {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	var claims auth.Claims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.ExpiresAt == 0 {
+		return false
+	}
+	return time.Now().Add(refreshMargin).Unix() >= claims.ExpiresAt
+}
+
+// APIKeys sends a request for every API key, revoked ones included; only
+// admins may list them
+func (c *Client) APIKeys() ([]auth.APIKey, error) {
+	resp, err := c.get("/api-keys")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusForbidden {
+		return nil, auth.ErrForbidden
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("failed to list API keys")
+	}
+
+	var result struct {
+		APIKeys []auth.APIKey `json:"api_keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	return result.APIKeys, nil
+}
+
+// CreateAPIKey sends a request to create an API key with a scope and returns
+// it with the key itself, which the server never shows again
+func (c *Client) CreateAPIKey(name, scope string) (string, *auth.APIKey, error) {
+	resp, err := c.sendJSON(http.MethodPost, "/api-keys", auth.NewAPIKey{Name: name, Scope: scope})
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusCreated:
+	case http.StatusForbidden:
+		return "", nil, auth.ErrForbidden
+	case http.StatusConflict:
+		return "", nil, auth.ErrAPIKeyExists
+	case http.StatusUnprocessableEntity:
+		return "", nil, errors.New("invalid API key name or scope")
+	default:
+		return "", nil, errors.New("failed to create API key")
+	}
+
+	var result struct {
+		Key    string      `json:"key"`
+		APIKey auth.APIKey `json:"api_key"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", nil, err
+	}
+
+	return result.Key, &result.APIKey, nil
+}
+
+// RevokeAPIKey sends a request to stop an API key from being accepted
+func (c *Client) RevokeAPIKey(id int) error {
+	resp, err := c.send(http.MethodDelete, fmt.Sprintf("/api-keys/%d", id))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusForbidden:
+		return auth.ErrForbidden
+	case http.StatusNotFound:
+		return auth.ErrAPIKeyNotFound
+	}
+	return errors.New("failed to revoke API key")
+}
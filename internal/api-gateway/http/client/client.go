@@ -5,12 +5,23 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"phonebook/internal/auth"
 	"phonebook/internal/contacts"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 type Client struct {
 	baseURL string
+
+	mu        sync.Mutex
+	tokens    *auth.TokenPair
+	onRefresh func(*auth.TokenPair)
+	apiKey    string
 }
 
 func NewClient(baseURL string) *Client {
@@ -24,7 +35,7 @@ func (c *Client) AddContact(contact *contacts.Contact) (int, error) {
 		return 0, err
 	}
 
-	resp, err := http.Post(c.baseURL+"/contacts", "application/json", bytes.NewBuffer(data))
+	resp, err := c.post("/contacts", "application/json", bytes.NewBuffer(data))
 	if err != nil {
 		return 0, err
 	}
@@ -47,7 +58,174 @@ func (c *Client) AddContact(contact *contacts.Contact) (int, error) {
 	return int(id), nil
 }
 
-// UpdateContact sends a request to update an existing contact
+// AddContactCheckingDuplicates creates a contact like AddContact and also
+// returns the stored contacts it probably duplicates
+func (c *Client) AddContactCheckingDuplicates(contact *contacts.Contact) (int, []contacts.DuplicateMatch, error) {
+	data, err := json.Marshal(contact)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	resp, err := c.post("/contacts?check_duplicates=true", "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return 0, nil, errors.New("failed to create contact")
+	}
+
+	var result struct {
+		ContactID  int                       `json:"contact_id"`
+		Duplicates []contacts.DuplicateMatch `json:"duplicates"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, nil, err
+	}
+
+	return result.ContactID, result.Duplicates, nil
+}
+
+// MergeContacts merges the contacts of req into its survivor and returns
+// the merged contact
+func (c *Client) MergeContacts(req contacts.MergeRequest) (*contacts.MergeResult, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.post("/contacts/merge", "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errors.New("contact not found")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("failed to merge contacts")
+	}
+
+	var result contacts.MergeResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// FindDuplicates fetches the clusters of contacts that score at least
+// minScore as duplicates of each other
+func (c *Client) FindDuplicates(minScore float64) ([]contacts.DuplicateCluster, error) {
+	params := url.Values{}
+	params.Set("min_score", strconv.FormatFloat(minScore, 'f', -1, 64))
+
+	resp, err := c.get("/contacts/duplicates?" + params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("failed to find duplicates")
+	}
+
+	var result struct {
+		Clusters []contacts.DuplicateCluster `json:"clusters"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	return result.Clusters, nil
+}
+
+// Suggest sends a request for up to limit contacts whose name or number
+// starts with prefix; a limit of 0 uses the server default
+func (c *Client) Suggest(prefix string, limit int) ([]contacts.Suggestion, error) {
+	params := url.Values{}
+	params.Set("prefix", prefix)
+	if limit > 0 {
+		params.Set("limit", strconv.Itoa(limit))
+	}
+
+	resp, err := c.get("/contacts/suggest?" + params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("failed to suggest contacts")
+	}
+
+	var result struct {
+		Suggestions []contacts.Suggestion `json:"suggestions"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	return result.Suggestions, nil
+}
+
+// Lookup sends a request to find who a phone number belongs to. It returns
+// contacts.ErrNotFound when no contact has the number and
+// contacts.ErrAmbiguousMatch when it only loosely matches several.
+func (c *Client) Lookup(number string) (*contacts.LookupResult, error) {
+	resp, err := c.get("/lookup?" + url.Values{"number": {number}}.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return nil, contacts.ErrNotFound
+	case http.StatusConflict:
+		return nil, contacts.ErrAmbiguousMatch
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("failed to look up number")
+	}
+
+	var result contacts.LookupResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetContact sends a request to fetch a single contact by ID
+func (c *Client) GetContact(id int) (*contacts.Contact, error) {
+	resp, err := c.get(fmt.Sprintf("/contacts/%d", id))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errors.New("contact not found")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("failed to get contact")
+	}
+
+	var contact contacts.Contact
+	if err := json.NewDecoder(resp.Body).Decode(&contact); err != nil {
+		return nil, err
+	}
+
+	return &contact, nil
+}
+
+// UpdateContact sends a request to update an existing contact from the
+// version it was fetched at. When someone else changed the contact since, it
+// returns a *contacts.VersionMismatchError holding the latest copy. On
+// success the contact takes its new version.
 func (c *Client) UpdateContact(contact *contacts.Contact) error {
 	data, err := json.Marshal(contact)
 	if err != nil {
@@ -59,38 +237,718 @@ func (c *Client) UpdateContact(contact *contacts.Contact) error {
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	if contact.Version > 0 {
+		req.Header.Set("If-Match", `"`+strconv.Itoa(contact.Version)+`"`)
+	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return versionMismatch(resp)
+	}
 	if resp.StatusCode != http.StatusOK {
 		return errors.New("failed to update contact")
 	}
 
+	var result struct {
+		Version int `json:"version"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err == nil && result.Version > 0 {
+		contact.Version = result.Version
+	}
+
 	return nil
 }
 
-// SearchContacts sends a request to search for contacts by query
-func (c *Client) SearchContacts(query string) ([]contacts.Contact, error) {
-	resp, err := http.Get(fmt.Sprintf("%s/contacts/search?q=%s", c.baseURL, query))
+// PatchContact sends a JSON Merge Patch or JSON Patch changing part of a
+// contact and returns the patched contact. With a version, the contact must
+// still be at that version or a *contacts.VersionMismatchError holding the
+// latest copy is returned.
+func (c *Client) PatchContact(id int, patch contacts.Patch, version int) (*contacts.Contact, error) {
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPatch, fmt.Sprintf("%s/contacts/%d", c.baseURL, id), bytes.NewBuffer(data))
 	if err != nil {
 		return nil, err
 	}
+	req.Header.Set("Content-Type", patch.ContentType())
+	if version > 0 {
+		req.Header.Set("If-Match", `"`+strconv.Itoa(version)+`"`)
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, errors.New("contact not found")
+	case http.StatusPreconditionFailed:
+		return nil, versionMismatch(resp)
+	default:
+		return nil, errors.New("failed to patch contact")
+	}
+
+	var contact contacts.Contact
+	if err := json.NewDecoder(resp.Body).Decode(&contact); err != nil {
+		return nil, err
+	}
+
+	return &contact, nil
+}
+
+// versionMismatch reads the latest copy of a contact from a 412 response
+func versionMismatch(resp *http.Response) error {
+	var result struct {
+		Details struct {
+			Contact *contacts.Contact `json:"contact"`
+		} `json:"details"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if result.Details.Contact == nil {
+		return contacts.ErrVersionMismatch
+	}
+	return &contacts.VersionMismatchError{Current: result.Details.Contact}
+}
+
+// DeleteContact sends a request to delete a contact by ID
+func (c *Client) DeleteContact(id int) error {
+	req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/contacts/%d", c.baseURL, id), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotFound {
+		return errors.New("contact not found")
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New("search failed")
+		return errors.New("failed to delete contact")
+	}
+
+	return nil
+}
+
+// ListTrash sends a request for one page of the contacts in the trash
+func (c *Client) ListTrash(opts contacts.ListOptions) (*contacts.Page, error) {
+	return c.fetchPage("/contacts/trash", pageParams(opts))
+}
+
+// RestoreContact sends a request to move a contact out of the trash
+func (c *Client) RestoreContact(id int) error {
+	resp, err := c.send(http.MethodPost, fmt.Sprintf("/contacts/%d/restore", id))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errors.New("contact not found in trash")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("failed to restore contact")
+	}
+
+	return nil
+}
+
+// PurgeContact sends a request to permanently remove a contact from the trash
+func (c *Client) PurgeContact(id int) error {
+	resp, err := c.send(http.MethodDelete, fmt.Sprintf("/contacts/trash/%d", id))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errors.New("contact not found in trash")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("failed to purge contact")
+	}
+
+	return nil
+}
+
+// EmptyTrash sends a request to permanently remove every contact in the
+// trash and returns how many were removed
+func (c *Client) EmptyTrash() (int64, error) {
+	resp, err := c.send(http.MethodDelete, "/contacts/trash")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, errors.New("failed to empty trash")
+	}
+
+	var result struct {
+		Purged int64 `json:"purged"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+
+	return result.Purged, nil
+}
+
+// History sends a request for every recorded revision of a contact, oldest first
+func (c *Client) History(id int) ([]contacts.Revision, error) {
+	resp, err := c.get(fmt.Sprintf("/contacts/%d/history", id))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errors.New("contact has no history")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("failed to get contact history")
 	}
 
 	var result struct {
-		Contacts []contacts.Contact `json:"contacts"`
+		Revisions []contacts.Revision `json:"revisions"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return nil, err
 	}
 
-	return result.Contacts, nil
+	return result.Revisions, nil
+}
+
+// DiffRevisions sends a request comparing a contact as it was after two revisions
+func (c *Client) DiffRevisions(id, from, to int) (*contacts.RevisionDiff, error) {
+	resp, err := c.get(fmt.Sprintf("/contacts/%d/history/diff?from=%d&to=%d", id, from, to))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errors.New("revision not found")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("failed to compare revisions")
+	}
+
+	var diff contacts.RevisionDiff
+	if err := json.NewDecoder(resp.Body).Decode(&diff); err != nil {
+		return nil, err
+	}
+
+	return &diff, nil
+}
+
+// RevertContact sends a request to restore the names and phone numbers of a
+// contact to a revision. When old numbers now belong to other contacts it
+// returns a *contacts.PhoneConflictError naming them.
+func (c *Client) RevertContact(id, revision int) (*contacts.Contact, error) {
+	resp, err := c.send(http.MethodPost, fmt.Sprintf("/contacts/%d/revert?revision=%d", id, revision))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, errors.New("contact or revision not found")
+	case http.StatusConflict:
+		var result struct {
+			Details struct {
+				Conflicts []struct {
+					Number  string            `json:"number"`
+					Owner   *contacts.Contact `json:"contact"`
+					Trashed bool              `json:"trashed"`
+				} `json:"conflicts"`
+			} `json:"details"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return nil, err
+		}
+		conflict := &contacts.PhoneConflictError{}
+		for _, c := range result.Details.Conflicts {
+			conflict.Conflicts = append(conflict.Conflicts, contacts.DuplicatePhoneError{Number: c.Number, Owner: c.Owner, Trashed: c.Trashed})
+		}
+		return nil, conflict
+	default:
+		return nil, errors.New("failed to revert contact")
+	}
+
+	var contact contacts.Contact
+	if err := json.NewDecoder(resp.Body).Decode(&contact); err != nil {
+		return nil, err
+	}
+
+	return &contact, nil
+}
+
+// ListTags sends a request for every tag with its number of contacts
+func (c *Client) ListTags() ([]contacts.Tag, error) {
+	resp, err := c.get("/tags")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("failed to list tags")
+	}
+
+	var result struct {
+		Tags []contacts.Tag `json:"tags"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	return result.Tags, nil
+}
+
+// CreateTag sends a request to create a tag
+func (c *Client) CreateTag(name string) (*contacts.Tag, error) {
+	resp, err := c.sendJSON(http.MethodPost, "/tags", map[string]string{"name": name})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusCreated:
+	case http.StatusConflict:
+		return nil, contacts.ErrTagExists
+	default:
+		return nil, errors.New("failed to create tag")
+	}
+
+	var tag contacts.Tag
+	if err := json.NewDecoder(resp.Body).Decode(&tag); err != nil {
+		return nil, err
+	}
+
+	return &tag, nil
+}
+
+// RenameTag sends a request to rename a tag, keeping its contacts
+func (c *Client) RenameTag(name, newName string) (*contacts.Tag, error) {
+	resp, err := c.sendJSON(http.MethodPut, "/tags/"+url.PathEscape(name), map[string]string{"name": newName})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, contacts.ErrTagNotFound
+	case http.StatusConflict:
+		return nil, contacts.ErrTagExists
+	default:
+		return nil, errors.New("failed to rename tag")
+	}
+
+	var tag contacts.Tag
+	if err := json.NewDecoder(resp.Body).Decode(&tag); err != nil {
+		return nil, err
+	}
+
+	return &tag, nil
+}
+
+// DeleteTag sends a request to delete a tag and remove it from its contacts
+func (c *Client) DeleteTag(name string) error {
+	resp, err := c.send(http.MethodDelete, "/tags/"+url.PathEscape(name))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return contacts.ErrTagNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("failed to delete tag")
+	}
+
+	return nil
+}
+
+// TagContacts sends a request to add a tag to contacts, creating the tag
+// when needed, and returns how many contacts did not carry it yet
+func (c *Client) TagContacts(name string, ids []int) (int64, error) {
+	return c.tagContacts(http.MethodPost, name, ids)
+}
+
+// UntagContacts sends a request to remove a tag from contacts and returns
+// how many carried it
+func (c *Client) UntagContacts(name string, ids []int) (int64, error) {
+	return c.tagContacts(http.MethodDelete, name, ids)
+}
+
+func (c *Client) tagContacts(method, name string, ids []int) (int64, error) {
+	resp, err := c.sendJSON(method, "/tags/"+url.PathEscape(name)+"/contacts", map[string][]int{"contact_ids": ids})
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		if method == http.MethodDelete {
+			return 0, contacts.ErrTagNotFound
+		}
+		return 0, errors.New("contact not found")
+	default:
+		return 0, errors.New("failed to change tagged contacts")
+	}
+
+	var result struct {
+		Tagged   int64 `json:"tagged"`
+		Untagged int64 `json:"untagged"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+
+	return result.Tagged + result.Untagged, nil
+}
+
+// ListGroups sends a request for every saved group
+func (c *Client) ListGroups() ([]contacts.Group, error) {
+	resp, err := c.get("/groups")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("failed to list groups")
+	}
+
+	var result struct {
+		Groups []contacts.Group `json:"groups"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	return result.Groups, nil
+}
+
+// CreateGroup sends a request to save a search as a new group
+func (c *Client) CreateGroup(group *contacts.Group) error {
+	return c.saveGroup(http.MethodPost, "/groups", group)
+}
+
+// UpdateGroup sends a request to replace the search of a group, renaming it
+// when group.Name differs from name
+func (c *Client) UpdateGroup(name string, group *contacts.Group) error {
+	return c.saveGroup(http.MethodPut, "/groups/"+url.PathEscape(name), group)
+}
+
+func (c *Client) saveGroup(method, path string, group *contacts.Group) error {
+	resp, err := c.sendJSON(method, path, group)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+	case http.StatusNotFound:
+		return contacts.ErrGroupNotFound
+	case http.StatusConflict:
+		return contacts.ErrGroupExists
+	case http.StatusUnprocessableEntity:
+		return errors.New("invalid group")
+	default:
+		return errors.New("failed to save group")
+	}
+
+	return json.NewDecoder(resp.Body).Decode(group)
+}
+
+// DeleteGroup sends a request to delete a group; its contacts are kept
+func (c *Client) DeleteGroup(name string) error {
+	resp, err := c.send(http.MethodDelete, "/groups/"+url.PathEscape(name))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return contacts.ErrGroupNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("failed to delete group")
+	}
+
+	return nil
+}
+
+// GroupContactsPage sends a request for one page of the contacts a group finds
+func (c *Client) GroupContactsPage(name string, opts contacts.ListOptions) (*contacts.Page, error) {
+	return c.fetchPage("/groups/"+url.PathEscape(name)+"/contacts", pageParams(opts))
+}
+
+// sendJSON sends a request with body encoded as JSON
+func (c *Client) sendJSON(method, path string, body interface{}) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, bytes.NewBuffer(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.do(req)
+}
+
+// send sends a request without a body
+func (c *Client) send(method, path string) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.baseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(req)
+}
+
+func (c *Client) get(path string) (*http.Response, error) {
+	return c.send(http.MethodGet, path)
+}
+
+func (c *Client) post(path, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return c.do(req)
+}
+
+// ListContacts sends a request for one page of contacts
+func (c *Client) ListContacts(opts contacts.ListOptions) (*contacts.Page, error) {
+	return c.fetchPage("/contacts", pageParams(opts))
+}
+
+// SearchContactsPage sends a request for one page of contacts matching query
+func (c *Client) SearchContactsPage(query contacts.SearchQuery, opts contacts.ListOptions) (*contacts.Page, error) {
+	params := pageParams(opts)
+	params.Set("q", query.Text)
+	if query.Mode != "" {
+		params.Set("mode", query.Mode)
+	}
+	return c.fetchPage("/contacts/search", params)
+}
+
+// SearchContacts sends requests to search for contacts by query, following
+// every page of results
+func (c *Client) SearchContacts(query string) ([]contacts.Contact, error) {
+	it := c.IterateContacts(contacts.SearchQuery{Text: query}, contacts.ListOptions{})
+
+	result := []contacts.Contact{}
+	for it.Next() {
+		result = append(result, it.Contact())
+	}
+	return result, it.Err()
+}
+
+// ImportVCards uploads a vCard file and returns the outcome of every card
+func (c *Client) ImportVCards(r io.Reader) (*contacts.ImportReport, error) {
+	resp, err := c.post("/contacts/import", contacts.VCardMIMEType, r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("failed to import contacts")
+	}
+
+	var report contacts.ImportReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return nil, err
+	}
+
+	return &report, nil
+}
+
+// ImportCSV uploads a CSV file and returns the outcome of every record
+func (c *Client) ImportCSV(r io.Reader, opts contacts.CSVImportOptions) (*contacts.ImportReport, error) {
+	params := url.Values{}
+	if opts.Format != "" {
+		params.Set("format", opts.Format)
+	}
+	if opts.DryRun {
+		params.Set("dry_run", "true")
+	}
+	if opts.BatchSize > 0 {
+		params.Set("batch_size", strconv.Itoa(opts.BatchSize))
+	}
+	if opts.Mapping != nil {
+		mapping, err := json.Marshal(opts.Mapping)
+		if err != nil {
+			return nil, err
+		}
+		params.Set("mapping", string(mapping))
+	}
+
+	resp, err := c.post("/contacts/import/csv?"+params.Encode(), "text/csv", r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("failed to import contacts")
+	}
+
+	var report contacts.ImportReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return nil, err
+	}
+
+	return &report, nil
+}
+
+// ExportContacts downloads all contacts, or those matching query when its
+// text is not empty, and writes them to w as CSV, JSON or NDJSON. Only the
+// given fields are exported, or every field when fields is empty.
+func (c *Client) ExportContacts(w io.Writer, format string, fields []string, query contacts.SearchQuery) error {
+	params := url.Values{}
+	params.Set("format", format)
+	if len(fields) > 0 {
+		params.Set("fields", strings.Join(fields, ","))
+	}
+	if query.Text != "" {
+		params.Set("q", query.Text)
+	}
+	if query.Mode != "" {
+		params.Set("mode", query.Mode)
+	}
+	return c.download("/contacts/export", params, w)
+}
+
+// ExportVCards downloads all contacts, or those matching query when its text
+// is not empty, and writes them to w as vCards
+func (c *Client) ExportVCards(w io.Writer, query contacts.SearchQuery) error {
+	params := url.Values{}
+	if query.Text != "" {
+		params.Set("q", query.Text)
+	}
+	if query.Mode != "" {
+		params.Set("mode", query.Mode)
+	}
+
+	return c.download("/contacts/export.vcf", params, w)
+}
+
+// ExportGroup downloads the contacts a group finds and writes them to w as
+// vCards, or as CSV, JSON or NDJSON with the given fields
+func (c *Client) ExportGroup(w io.Writer, name, format string, fields []string) error {
+	params := url.Values{}
+	params.Set("group", name)
+	switch format {
+	case contacts.ExportCSV, contacts.ExportJSON, contacts.ExportNDJSON:
+		params.Set("format", format)
+		if len(fields) > 0 {
+			params.Set("fields", strings.Join(fields, ","))
+		}
+		return c.download("/contacts/export", params, w)
+	}
+	return c.download("/contacts/export.vcf", params, w)
+}
+
+// download copies the response to a GET request to w
+func (c *Client) download(path string, params url.Values, w io.Writer) error {
+	resp, err := c.get(path + "?" + params.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("failed to export contacts")
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+func (c *Client) fetchPage(path string, params url.Values) (*contacts.Page, error) {
+	resp, err := c.get(path + "?" + params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusBadRequest {
+		return nil, queryError(resp)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("search failed")
+	}
+
+	var page contacts.Page
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, err
+	}
+
+	return &page, nil
+}
+
+// queryError reads the position of a rejected search query from a 400
+// response, or reports the request as bad when the body carries none
+func queryError(resp *http.Response) error {
+	var result struct {
+		Code    string              `json:"code"`
+		Details contacts.QueryError `json:"details"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || result.Code != "invalid_query" {
+		return errors.New("bad request")
+	}
+	return &result.Details
+}
+
+// pageParams encodes list options as query parameters
+func pageParams(opts contacts.ListOptions) url.Values {
+	params := url.Values{}
+	if opts.Limit > 0 {
+		params.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Cursor != "" {
+		params.Set("cursor", opts.Cursor)
+	}
+	if opts.Sort != "" {
+		params.Set("sort", opts.Sort)
+	}
+	if opts.Desc {
+		params.Set("order", "desc")
+	}
+	if opts.Tag != "" {
+		params.Set("tag", opts.Tag)
+	}
+	return params
 }
@@ -0,0 +1,71 @@
+package client
+
+import "phonebook/internal/contacts"
+
+// ContactIterator walks every page of a listing or search, fetching the next
+// page only once the current one is exhausted. Use it like sql.Rows:
+//
+//	it := c.IterateContacts(contacts.SearchQuery{Text: "Doe"}, contacts.ListOptions{})
+//	for it.Next() {
+//		contact := it.Contact()
+//	}
+//	if err := it.Err(); err != nil { ... }
+type ContactIterator struct {
+	client  *Client
+	query   contacts.SearchQuery
+	group   string
+	opts    contacts.ListOptions
+	page    []contacts.Contact
+	current contacts.Contact
+	started bool
+	err     error
+}
+
+// IterateContacts returns an iterator over all contacts matching query, or
+// over all contacts when the query text is empty
+func (c *Client) IterateContacts(query contacts.SearchQuery, opts contacts.ListOptions) *ContactIterator {
+	return &ContactIterator{client: c, query: query, opts: opts}
+}
+
+// IterateGroup returns an iterator over the contacts the named group finds
+func (c *Client) IterateGroup(name string, opts contacts.ListOptions) *ContactIterator {
+	return &ContactIterator{client: c, group: name, opts: opts}
+}
+
+// Next advances to the next contact and reports whether there is one
+func (it *ContactIterator) Next() bool {
+	for len(it.page) == 0 {
+		if it.err != nil || (it.started && it.opts.Cursor == "") {
+			return false
+		}
+		it.started = true
+
+		var page *contacts.Page
+		switch {
+		case it.group != "":
+			page, it.err = it.client.GroupContactsPage(it.group, it.opts)
+		case it.query.Text == "":
+			page, it.err = it.client.ListContacts(it.opts)
+		default:
+			page, it.err = it.client.SearchContactsPage(it.query, it.opts)
+		}
+		if it.err != nil {
+			return false
+		}
+		it.page = page.Contacts
+		it.opts.Cursor = page.NextCursor
+	}
+
+	it.current, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+// Contact returns the contact Next advanced to
+func (it *ContactIterator) Contact() contacts.Contact {
+	return it.current
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *ContactIterator) Err() error {
+	return it.err
+}
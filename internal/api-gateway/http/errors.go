@@ -0,0 +1,139 @@
+package http
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"phonebook/internal/auth"
+	"phonebook/internal/contacts"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrorResponse is the JSON body returned for every failed request
+type ErrorResponse struct {
+	Error   string      `json:"error"`
+	Code    string      `json:"code,omitempty"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// DuplicatePhoneDetails identifies the number that caused a conflict and its
+// owner; trashed tells the client the owner can be restored or purged
+type DuplicatePhoneDetails struct {
+	Number  string            `json:"number"`
+	Owner   *contacts.Contact `json:"contact,omitempty"`
+	Trashed bool              `json:"trashed,omitempty"`
+}
+
+// ErrorHandler maps errors attached with c.Error to a status code and a JSON body.
+// Errors that are not domain errors become a 500 whose message is taken from the
+// error's meta when it is a string, so internals never leak to the client.
+// A response that fails after it started streaming, such as an export, has
+// its connection aborted so the client sees a broken transfer rather than a
+// file that looks complete.
+func ErrorHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 {
+			return
+		}
+		if c.Writer.Written() {
+			log.Println(c.Errors.Last().Err)
+			panic(http.ErrAbortHandler)
+		}
+
+		last := c.Errors.Last()
+		status, body := errorResponse(last.Err)
+		if status == http.StatusInternalServerError {
+			log.Println(last.Err)
+			if message, ok := last.Meta.(string); ok {
+				body.Error = message
+			}
+		}
+		c.JSON(status, body)
+	}
+}
+
+func errorResponse(err error) (int, ErrorResponse) {
+	var dupErr *contacts.DuplicatePhoneError
+	var conflictErr *contacts.PhoneConflictError
+	var versionErr *contacts.VersionMismatchError
+	var validationErr *contacts.ValidationError
+	var queryErr *contacts.QueryError
+	var ambiguousErr *contacts.AmbiguousMatchError
+
+	switch {
+	case errors.Is(err, auth.ErrUnauthorized):
+		return http.StatusUnauthorized, ErrorResponse{Error: "Authentication required", Code: "unauthorized"}
+	case errors.Is(err, auth.ErrInvalidToken):
+		return http.StatusUnauthorized, ErrorResponse{Error: "Invalid or expired token", Code: "invalid_token"}
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		return http.StatusUnauthorized, ErrorResponse{Error: "Invalid username or password", Code: "invalid_credentials"}
+	case errors.Is(err, auth.ErrInvalidAPIKey):
+		return http.StatusUnauthorized, ErrorResponse{Error: "Invalid or revoked API key", Code: "invalid_api_key"}
+	case errors.Is(err, auth.ErrInsufficientScope):
+		return http.StatusForbidden, ErrorResponse{Error: "API key scope does not allow this request", Code: "insufficient_scope"}
+	case errors.Is(err, auth.ErrAPIKeyNotFound):
+		return http.StatusNotFound, ErrorResponse{Error: "API key not found", Code: "not_found"}
+	case errors.Is(err, auth.ErrAPIKeyExists):
+		return http.StatusConflict, ErrorResponse{Error: "API key already exists", Code: "api_key_exists"}
+	case errors.Is(err, auth.ErrForbidden):
+		return http.StatusForbidden, ErrorResponse{Error: "Admin access required", Code: "forbidden"}
+	case errors.Is(err, auth.ErrUserExists):
+		return http.StatusConflict, ErrorResponse{Error: "User already exists", Code: "user_exists"}
+	case errors.Is(err, contacts.ErrRevisionNotFound):
+		return http.StatusNotFound, ErrorResponse{Error: "Revision not found", Code: "not_found"}
+	case errors.Is(err, contacts.ErrTagNotFound):
+		return http.StatusNotFound, ErrorResponse{Error: "Tag not found", Code: "not_found"}
+	case errors.Is(err, contacts.ErrTagExists):
+		return http.StatusConflict, ErrorResponse{Error: "Tag already exists", Code: "tag_exists"}
+	case errors.Is(err, contacts.ErrGroupNotFound):
+		return http.StatusNotFound, ErrorResponse{Error: "Group not found", Code: "not_found"}
+	case errors.Is(err, contacts.ErrGroupExists):
+		return http.StatusConflict, ErrorResponse{Error: "Group already exists", Code: "group_exists"}
+	case errors.Is(err, contacts.ErrNotFound):
+		return http.StatusNotFound, ErrorResponse{Error: "Contact not found", Code: "not_found"}
+	case errors.As(err, &dupErr):
+		return http.StatusConflict, ErrorResponse{
+			Error:   dupErr.Error(),
+			Code:    "duplicate_phone",
+			Details: DuplicatePhoneDetails{Number: dupErr.Number, Owner: dupErr.Owner, Trashed: dupErr.Trashed},
+		}
+	case errors.As(err, &conflictErr):
+		conflicts := make([]DuplicatePhoneDetails, 0, len(conflictErr.Conflicts))
+		for _, c := range conflictErr.Conflicts {
+			conflicts = append(conflicts, DuplicatePhoneDetails{Number: c.Number, Owner: c.Owner, Trashed: c.Trashed})
+		}
+		return http.StatusConflict, ErrorResponse{
+			Error:   "Phone numbers belong to other contacts",
+			Code:    "phone_conflict",
+			Details: gin.H{"conflicts": conflicts},
+		}
+	case errors.As(err, &versionErr):
+		return http.StatusPreconditionFailed, ErrorResponse{
+			Error:   "Contact was changed by someone else",
+			Code:    "version_mismatch",
+			Details: gin.H{"contact": versionErr.Current},
+		}
+	case errors.As(err, &ambiguousErr):
+		return http.StatusConflict, ErrorResponse{
+			Error:   "Phone number matches several contacts",
+			Code:    "ambiguous_match",
+			Details: ambiguousErr,
+		}
+	case errors.As(err, &queryErr):
+		return http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid search query",
+			Code:    "invalid_query",
+			Details: queryErr,
+		}
+	case errors.As(err, &validationErr):
+		return http.StatusUnprocessableEntity, ErrorResponse{
+			Error:   "Validation failed",
+			Code:    "validation_failed",
+			Details: gin.H{"fields": validationErr.Fields},
+		}
+	}
+	return http.StatusInternalServerError, ErrorResponse{Error: "Internal server error", Code: "internal"}
+}
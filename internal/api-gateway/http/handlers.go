@@ -2,25 +2,33 @@ package http
 
 import (
 	"database/sql"
+	"encoding/json"
+	"io"
 	"log"
 	"net/http"
+	"phonebook/internal/auth"
 	"phonebook/internal/contacts"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
 type Handler struct {
 	service *contacts.Service
+	auth    *auth.Service
 }
 
-func NewHandler(db *sql.DB) *Handler {
+func NewHandler(db *sql.DB, signer auth.Signer) *Handler {
 	return &Handler{
 		service: contacts.NewService(db),
+		auth:    auth.NewService(db, signer),
 	}
 }
 
-// CreateContactHandler handles the creation of a new contact
+// CreateContactHandler handles the creation of a new contact. With
+// check_duplicates=true the response also lists stored contacts the new one
+// probably duplicates; they are a warning and never stop the creation.
 func (h *Handler) CreateContactHandler(c *gin.Context) {
 	var contact contacts.Contact
 	if err := c.ShouldBindJSON(&contact); err != nil {
@@ -28,14 +36,81 @@ func (h *Handler) CreateContactHandler(c *gin.Context) {
 		return
 	}
 
-	contactID, err := h.service.CreateContact(&contact)
+	contactID, err := h.as(c).CreateContact(&contact)
 	if err != nil {
+		c.Error(err).SetMeta("Could not create contact")
+		return
+	}
+
+	if c.Query("check_duplicates") != "true" {
+		c.JSON(http.StatusCreated, gin.H{"contact_id": contactID})
+		return
+	}
+
+	contact.ID = contactID
+	duplicates, err := h.service.CheckDuplicates(&contact)
+	if err != nil {
+		// The contact is stored; a failed check only loses the warning
 		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create contact"})
+		duplicates = []contacts.DuplicateMatch{}
+	}
+	c.JSON(http.StatusCreated, gin.H{"contact_id": contactID, "duplicates": duplicates})
+}
+
+// MergeContactsHandler handles merging several contacts into a survivor
+func (h *Handler) MergeContactsHandler(c *gin.Context) {
+	var req contacts.MergeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	result, err := h.as(c).MergeContacts(req)
+	if err != nil {
+		c.Error(err).SetMeta("Could not merge contacts")
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// FindDuplicatesHandler handles listing clusters of likely duplicate
+// contacts. min_score sets how alike two contacts must be, from 0 to 1.
+func (h *Handler) FindDuplicatesHandler(c *gin.Context) {
+	minScore := contacts.DefaultDuplicateScore
+	if s := c.Query("min_score"); s != "" {
+		var err error
+		if minScore, err = strconv.ParseFloat(s, 64); err != nil || minScore < 0 || minScore > 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid min score"})
+			return
+		}
+	}
+
+	clusters, err := h.service.FindDuplicates(minScore)
+	if err != nil {
+		c.Error(err).SetMeta("Could not find duplicates")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"clusters": clusters})
+}
+
+// GetContactHandler handles fetching a single contact by ID
+func (h *Handler) GetContactHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid contact ID"})
+		return
+	}
+
+	contact, err := h.service.GetContact(id)
+	if err != nil {
+		c.Error(err).SetMeta("Could not get contact")
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"contact_id": contactID})
+	c.Header("ETag", etag(contact.Version))
+	c.JSON(http.StatusOK, contact)
 }
 
 // UpdateContactHandler handles updating an existing contact
@@ -54,22 +129,740 @@ func (h *Handler) UpdateContactHandler(c *gin.Context) {
 	}
 	contact.ID = id
 
-	if err := h.service.UpdateContact(&contact); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update contact"})
+	// The update must name the version it was made from, by If-Match or the
+	// version field; If-Match: * updates whatever version is stored
+	match := strings.TrimSpace(c.GetHeader("If-Match"))
+	if match != "" && match != "*" {
+		version, ok := parseETag(match)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid If-Match header"})
+			return
+		}
+		contact.Version = version
+	}
+	if match == "*" {
+		contact.Version = 0
+	} else if contact.Version == 0 {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "If-Match header or version is required"})
+		return
+	}
+
+	if err := h.as(c).UpdateContact(&contact); err != nil {
+		c.Error(err).SetMeta("Could not update contact")
+		return
+	}
+
+	c.Header("ETag", etag(contact.Version))
+	c.JSON(http.StatusOK, gin.H{"message": "Contact updated successfully", "version": contact.Version})
+}
+
+// PatchContactHandler handles changing part of a contact with a JSON Merge
+// Patch, the default, or a JSON Patch as chosen by the Content-Type header.
+// If-Match is optional; when given the contact must still be at that version.
+func (h *Handler) PatchContactHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid contact ID"})
+		return
+	}
+
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	var patch contacts.Patch
+	switch c.ContentType() {
+	case contacts.MergePatchType, "application/json", "":
+		var merge contacts.MergePatch
+		if json.Unmarshal(body, &merge) == nil && merge != nil {
+			patch = merge
+		}
+	case contacts.JSONPatchType:
+		var ops contacts.JSONPatch
+		if json.Unmarshal(body, &ops) == nil && ops != nil {
+			patch = ops
+		}
+	default:
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Unsupported patch format"})
+		return
+	}
+	if patch == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid patch"})
+		return
+	}
+
+	version := 0
+	if match := strings.TrimSpace(c.GetHeader("If-Match")); match != "" && match != "*" {
+		var ok bool
+		if version, ok = parseETag(match); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid If-Match header"})
+			return
+		}
+	}
+
+	contact, err := h.as(c).PatchContact(id, patch, version)
+	if err != nil {
+		c.Error(err).SetMeta("Could not update contact")
+		return
+	}
+
+	c.Header("ETag", etag(contact.Version))
+	c.JSON(http.StatusOK, contact)
+}
+
+// DeleteContactHandler handles moving a contact to the trash
+func (h *Handler) DeleteContactHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid contact ID"})
+		return
+	}
+
+	if err := h.as(c).DeleteContact(id); err != nil {
+		c.Error(err).SetMeta("Could not delete contact")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Contact moved to trash"})
+}
+
+// TrashContactsHandler handles listing the contacts in the trash one page at a time
+func (h *Handler) TrashContactsHandler(c *gin.Context) {
+	opts, err := listOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+
+	page, err := h.service.TrashContacts(opts)
+	if err != nil {
+		c.Error(err).SetMeta("Could not list trash")
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// RestoreContactHandler handles moving a contact out of the trash
+func (h *Handler) RestoreContactHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid contact ID"})
+		return
+	}
+
+	if err := h.as(c).RestoreContact(id); err != nil {
+		c.Error(err).SetMeta("Could not restore contact")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Contact restored successfully"})
+}
+
+// PurgeContactHandler handles permanently removing a contact from the trash
+func (h *Handler) PurgeContactHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid contact ID"})
+		return
+	}
+
+	if err := h.as(c).PurgeContact(id); err != nil {
+		c.Error(err).SetMeta("Could not purge contact")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Contact purged successfully"})
+}
+
+// EmptyTrashHandler handles permanently removing every contact in the trash
+func (h *Handler) EmptyTrashHandler(c *gin.Context) {
+	purged, err := h.as(c).PurgeTrash(0)
+	if err != nil {
+		c.Error(err).SetMeta("Could not empty trash")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"purged": purged})
+}
+
+// ListContactsHandler handles listing contacts one page at a time
+func (h *Handler) ListContactsHandler(c *gin.Context) {
+	opts, err := listOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+
+	page, err := h.service.ListContacts(opts)
+	if err != nil {
+		c.Error(err).SetMeta("Could not list contacts")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Contact updated successfully"})
+	c.JSON(http.StatusOK, page)
 }
 
 // SearchContactsHandler handles searching for contacts
 func (h *Handler) SearchContactsHandler(c *gin.Context) {
-	query := c.Query("q")
-	contacts, err := h.service.SearchContacts(query)
+	opts, err := listOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+
+	query := contacts.SearchQuery{Text: c.Query("q"), Mode: c.Query("mode")}
+	page, err := h.service.SearchContacts(query, opts)
+	if err != nil {
+		c.Error(err).SetMeta("Could not search contacts")
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// SuggestContactsHandler handles suggesting contacts by the start of a name
+// or number while it is typed
+func (h *Handler) SuggestContactsHandler(c *gin.Context) {
+	var limit int
+	if l := c.Query("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+	}
+
+	suggestions, err := h.service.Suggest(c.Query("prefix"), limit)
+	if err != nil {
+		c.Error(err).SetMeta("Could not suggest contacts")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"suggestions": suggestions})
+}
+
+// LookupHandler handles finding who a phone number, such as an incoming
+// caller ID, belongs to
+func (h *Handler) LookupHandler(c *gin.Context) {
+	result, err := h.service.Lookup(c.Query("number"))
+	if err != nil {
+		c.Error(err).SetMeta("Could not look up number")
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// GetContactVCardHandler handles downloading a single contact as a vCard
+func (h *Handler) GetContactVCardHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid contact ID"})
+		return
+	}
+
+	version, ok := vcardVersion(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid vCard version"})
+		return
+	}
+
+	contact, err := h.service.GetContact(id)
+	if err != nil {
+		c.Error(err).SetMeta("Could not get contact")
+		return
+	}
+
+	attachmentHeaders(c, contacts.VCardMIMEType, "contact-"+strconv.Itoa(id)+".vcf")
+	if err := contacts.WriteVCards(c.Writer, version, *contact); err != nil {
+		c.Error(err).SetMeta("Could not export contact")
+	}
+}
+
+// ExportVCardHandler handles downloading all contacts, those matching the q
+// and mode search parameters or those of the group parameter, as one vCard
+// file
+func (h *Handler) ExportVCardHandler(c *gin.Context) {
+	version, ok := vcardVersion(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid vCard version"})
+		return
+	}
+
+	if c.Query("group") != "" && c.Query("q") != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Use either q or group"})
+		return
+	}
+
+	// Headers are sent with the first card so a failing query can still be
+	// reported as a JSON error
+	started := false
+	opts := contacts.ListOptions{Tag: c.Query("tag")}
+	err := h.eachExported(c, opts, func(contact contacts.Contact) error {
+		if !started {
+			attachmentHeaders(c, contacts.VCardMIMEType, "contacts.vcf")
+			started = true
+		}
+		return contacts.WriteVCards(c.Writer, version, contact)
+	})
+	if err != nil {
+		c.Error(err).SetMeta("Could not export contacts")
+		return
+	}
+	if !started {
+		attachmentHeaders(c, contacts.VCardMIMEType, "contacts.vcf")
+		c.Status(http.StatusOK)
+	}
+}
+
+// ExportContactsHandler handles streaming all contacts, those matching the q
+// and mode search parameters or those of the group parameter, as CSV, JSON
+// or NDJSON. fields is a comma separated list selecting and ordering the
+// exported fields; sort and order work as they do for listing.
+func (h *Handler) ExportContactsHandler(c *gin.Context) {
+	format := c.DefaultQuery("format", contacts.ExportCSV)
+	var fields []string
+	if f := c.Query("fields"); f != "" {
+		for _, field := range strings.Split(f, ",") {
+			fields = append(fields, strings.TrimSpace(field))
+		}
+	}
+
+	if c.Query("group") != "" && c.Query("q") != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Use either q or group"})
+		return
+	}
+
+	exporter, err := contacts.NewExporter(c.Writer, format, fields)
+	if err != nil {
+		c.Error(err).SetMeta("Could not export contacts")
+		return
+	}
+
+	// Headers are sent with the first contact so a failing query can still be
+	// reported as a JSON error
+	started := false
+	start := func() {
+		if !started {
+			attachmentHeaders(c, contacts.ExportContentTypes[format], "contacts."+format)
+			started = true
+		}
+	}
+
+	opts := contacts.ListOptions{Sort: c.Query("sort"), Desc: c.Query("order") == "desc", Tag: c.Query("tag")}
+	err = h.eachExported(c, opts, func(contact contacts.Contact) error {
+		start()
+		return exporter.Write(contact)
+	})
+	if err == nil {
+		start()
+		err = exporter.Close()
+	}
+	if err != nil {
+		c.Error(err).SetMeta("Could not export contacts")
+	}
+}
+
+// ImportVCardHandler handles creating contacts from an uploaded vCard file,
+// sent either as the "file" field of a multipart form or as the request body
+func (h *Handler) ImportVCardHandler(c *gin.Context) {
+	body, err := uploadedFile(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing vCard file"})
+		return
+	}
+	defer body.Close()
+
+	c.JSON(http.StatusOK, h.as(c).ImportVCards(body))
+}
+
+// ImportCSVHandler handles creating contacts from an uploaded CSV file, sent
+// either as the "file" field of a multipart form or as the request body. The
+// format, dry_run and batch_size query parameters control the import; a
+// custom column mapping is a JSON object in the mapping form field or query
+// parameter.
+func (h *Handler) ImportCSVHandler(c *gin.Context) {
+	opts := contacts.CSVImportOptions{
+		Format: c.Query("format"),
+		DryRun: c.Query("dry_run") == "true",
+	}
+	if size := c.Query("batch_size"); size != "" {
+		var err error
+		if opts.BatchSize, err = strconv.Atoi(size); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid batch size"})
+			return
+		}
+	}
+
+	body, err := uploadedFile(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing CSV file"})
+		return
+	}
+	defer body.Close()
+
+	mapping := c.PostForm("mapping")
+	if mapping == "" {
+		mapping = c.Query("mapping")
+	}
+	if mapping != "" {
+		if err := json.Unmarshal([]byte(mapping), &opts.Mapping); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mapping"})
+			return
+		}
+	}
+
+	report, err := h.as(c).ImportCSV(body, opts)
+	if err != nil {
+		c.Error(err).SetMeta("Could not import contacts")
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// HistoryHandler handles listing every recorded change of a contact
+func (h *Handler) HistoryHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid contact ID"})
+		return
+	}
+
+	revisions, err := h.service.History(id)
+	if err != nil {
+		c.Error(err).SetMeta("Could not get contact history")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"revisions": revisions})
+}
+
+// DiffRevisionsHandler handles comparing the contact as it was after the
+// revisions given by the from and to query parameters
+func (h *Handler) DiffRevisionsHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid contact ID"})
+		return
+	}
+	from, errFrom := strconv.Atoi(c.Query("from"))
+	to, errTo := strconv.Atoi(c.Query("to"))
+	if errFrom != nil || errTo != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision"})
+		return
+	}
+
+	diff, err := h.service.DiffRevisions(id, from, to)
+	if err != nil {
+		c.Error(err).SetMeta("Could not compare revisions")
+		return
+	}
+
+	c.JSON(http.StatusOK, diff)
+}
+
+// RevertContactHandler handles restoring the names and phone numbers of a
+// contact to the revision given by the revision query parameter
+func (h *Handler) RevertContactHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid contact ID"})
+		return
+	}
+	revision, err := strconv.Atoi(c.Query("revision"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision"})
+		return
+	}
+
+	contact, err := h.as(c).RevertContact(id, revision)
+	if err != nil {
+		c.Error(err).SetMeta("Could not revert contact")
+		return
+	}
+
+	c.JSON(http.StatusOK, contact)
+}
+
+// tagRequest is the body of the requests creating or renaming a tag
+type tagRequest struct {
+	Name string `json:"name"`
+}
+
+// tagContactsRequest is the body of the requests tagging and untagging contacts
+type tagContactsRequest struct {
+	ContactIDs []int `json:"contact_ids"`
+}
+
+// ListTagsHandler handles listing every tag with its number of contacts
+func (h *Handler) ListTagsHandler(c *gin.Context) {
+	tags, err := h.service.Tags()
+	if err != nil {
+		c.Error(err).SetMeta("Could not list tags")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tags": tags})
+}
+
+// CreateTagHandler handles creating a tag
+func (h *Handler) CreateTagHandler(c *gin.Context) {
+	var req tagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	tag, err := h.service.CreateTag(req.Name)
+	if err != nil {
+		c.Error(err).SetMeta("Could not create tag")
+		return
+	}
+
+	c.JSON(http.StatusCreated, tag)
+}
+
+// GetTagHandler handles fetching a single tag by name
+func (h *Handler) GetTagHandler(c *gin.Context) {
+	tag, err := h.service.GetTag(c.Param("name"))
+	if err != nil {
+		c.Error(err).SetMeta("Could not get tag")
+		return
+	}
+
+	c.JSON(http.StatusOK, tag)
+}
+
+// RenameTagHandler handles renaming a tag
+func (h *Handler) RenameTagHandler(c *gin.Context) {
+	var req tagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	tag, err := h.service.RenameTag(c.Param("name"), req.Name)
+	if err != nil {
+		c.Error(err).SetMeta("Could not rename tag")
+		return
+	}
+
+	c.JSON(http.StatusOK, tag)
+}
+
+// DeleteTagHandler handles deleting a tag and removing it from its contacts
+func (h *Handler) DeleteTagHandler(c *gin.Context) {
+	if err := h.service.DeleteTag(c.Param("name")); err != nil {
+		c.Error(err).SetMeta("Could not delete tag")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Tag deleted successfully"})
+}
+
+// TagContactsHandler handles adding a tag to several contacts at once,
+// creating the tag when it does not exist yet
+func (h *Handler) TagContactsHandler(c *gin.Context) {
+	var req tagContactsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	tagged, err := h.service.TagContacts(c.Param("name"), req.ContactIDs)
+	if err != nil {
+		c.Error(err).SetMeta("Could not tag contacts")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tagged": tagged})
+}
+
+// UntagContactsHandler handles removing a tag from several contacts at once
+func (h *Handler) UntagContactsHandler(c *gin.Context) {
+	var req tagContactsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	untagged, err := h.service.UntagContacts(c.Param("name"), req.ContactIDs)
+	if err != nil {
+		c.Error(err).SetMeta("Could not untag contacts")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"untagged": untagged})
+}
+
+// ListGroupsHandler handles listing every saved group
+func (h *Handler) ListGroupsHandler(c *gin.Context) {
+	groups, err := h.service.Groups()
+	if err != nil {
+		c.Error(err).SetMeta("Could not list groups")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"groups": groups})
+}
+
+// CreateGroupHandler handles saving a search as a new group
+func (h *Handler) CreateGroupHandler(c *gin.Context) {
+	var group contacts.Group
+	if err := c.ShouldBindJSON(&group); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	if err := h.service.CreateGroup(&group); err != nil {
+		c.Error(err).SetMeta("Could not create group")
+		return
+	}
+
+	c.JSON(http.StatusCreated, group)
+}
+
+// GetGroupHandler handles fetching the definition of a group by name
+func (h *Handler) GetGroupHandler(c *gin.Context) {
+	group, err := h.service.GetGroup(c.Param("name"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not search contacts"})
+		c.Error(err).SetMeta("Could not get group")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"contacts": contacts})
+	c.JSON(http.StatusOK, group)
+}
+
+// UpdateGroupHandler handles replacing the search of a group, renaming it
+// when the body names it differently
+func (h *Handler) UpdateGroupHandler(c *gin.Context) {
+	var group contacts.Group
+	if err := c.ShouldBindJSON(&group); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	if err := h.service.UpdateGroup(c.Param("name"), &group); err != nil {
+		c.Error(err).SetMeta("Could not update group")
+		return
+	}
+
+	c.JSON(http.StatusOK, group)
+}
+
+// DeleteGroupHandler handles deleting a group; its contacts are kept
+func (h *Handler) DeleteGroupHandler(c *gin.Context) {
+	if err := h.service.DeleteGroup(c.Param("name")); err != nil {
+		c.Error(err).SetMeta("Could not delete group")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Group deleted successfully"})
+}
+
+// GroupContactsHandler handles listing the contacts a group finds now, one
+// page at a time
+func (h *Handler) GroupContactsHandler(c *gin.Context) {
+	opts, err := listOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+
+	page, err := h.service.GroupContacts(c.Param("name"), opts)
+	if err != nil {
+		c.Error(err).SetMeta("Could not list group contacts")
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// etag is the entity tag of a contact version
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// parseETag returns the contact version of an entity tag, weak or strong
+func parseETag(tag string) (int, bool) {
+	tag = strings.TrimPrefix(tag, "W/")
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, false
+	}
+	version, err := strconv.Atoi(tag[1 : len(tag)-1])
+	if err != nil || version <= 0 {
+		return 0, false
+	}
+	return version, true
+}
+
+// eachExported calls fn for every contact an export selects: those of the
+// group named by the group parameter, or those matching the q and mode
+// search parameters
+func (h *Handler) eachExported(c *gin.Context, opts contacts.ListOptions, fn func(contacts.Contact) error) error {
+	if group := c.Query("group"); group != "" {
+		return h.service.EachGroupContact(group, opts, fn)
+	}
+	query := contacts.SearchQuery{Text: c.Query("q"), Mode: c.Query("mode")}
+	return h.service.EachContact(query, opts, fn)
+}
+
+// as returns the service recording changes for the actor of the request
+func (h *Handler) as(c *gin.Context) *contacts.Service {
+	return h.service.As(actor(c))
+}
+
+// actor is who the request is made by: the authenticated user or API key
+func actor(c *gin.Context) string {
+	if p := currentUser(c); p != nil {
+		return p.Name
+	}
+	return contacts.AnonymousActor
+}
+
+// uploadedFile returns the "file" field of a multipart form, or the request
+// body when the request is not a multipart form
+func uploadedFile(c *gin.Context) (io.ReadCloser, error) {
+	if !strings.HasPrefix(c.ContentType(), "multipart/") {
+		return c.Request.Body, nil
+	}
+	header, err := c.FormFile("file")
+	if err != nil {
+		return nil, err
+	}
+	return header.Open()
+}
+
+// vcardVersion reads the version query parameter, defaulting to vCard 3.0
+// which is understood by most phones
+func vcardVersion(c *gin.Context) (string, bool) {
+	version := c.DefaultQuery("version", contacts.VCardVersion3)
+	return version, version == contacts.VCardVersion3 || version == contacts.VCardVersion4
+}
+
+// attachmentHeaders marks the response as a file download
+func attachmentHeaders(c *gin.Context, contentType, filename string) {
+	c.Header("Content-Type", contentType+"; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+}
+
+// listOptions reads the limit, cursor, sort and order query parameters
+func listOptions(c *gin.Context) (contacts.ListOptions, error) {
+	opts := contacts.ListOptions{
+		Cursor: c.Query("cursor"),
+		Sort:   c.Query("sort"),
+		Desc:   c.Query("order") == "desc",
+		Tag:    c.Query("tag"),
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		var err error
+		if opts.Limit, err = strconv.Atoi(limit); err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
 }
@@ -2,18 +2,69 @@ package http
 
 import (
 	"database/sql"
+	"phonebook/internal/auth"
 
 	"github.com/gin-gonic/gin"
 )
 
-func NewRouter(db *sql.DB) *gin.Engine {
-	router := gin.Default()
-	handler := NewHandler(db)
+// NewRouter sets up every route. Only logging in is allowed without an
+// access token signed by signer or an API key, and managing users and API
+// keys needs an admin.
+func NewRouter(db *sql.DB, signer auth.Signer) *gin.Engine {
+	// ErrorHandler runs outside the recovery middleware so it can abort a
+	// response that failed after it started
+	router := gin.New()
+	router.Use(gin.Logger(), ErrorHandler(), gin.Recovery())
+	handler := NewHandler(db, signer)
 
-	// Define routes
-	router.POST("/contacts", handler.CreateContactHandler)
-	router.PUT("/contacts/:id", handler.UpdateContactHandler)
-	router.GET("/contacts/search", handler.SearchContactsHandler)
+	router.POST("/auth/login", handler.LoginHandler)
+	router.POST("/auth/refresh", handler.RefreshHandler)
+
+	// Every other route needs an access token or API key
+	api := router.Group("/", handler.Authenticate)
+	api.GET("/contacts", handler.ListContactsHandler)
+	api.POST("/contacts", handler.CreateContactHandler)
+	api.GET("/contacts/:id", handler.GetContactHandler)
+	api.PUT("/contacts/:id", handler.UpdateContactHandler)
+	api.PATCH("/contacts/:id", handler.PatchContactHandler)
+	api.DELETE("/contacts/:id", handler.DeleteContactHandler)
+	api.GET("/contacts/search", handler.SearchContactsHandler)
+	api.GET("/contacts/suggest", handler.SuggestContactsHandler)
+	api.GET("/contacts/duplicates", handler.FindDuplicatesHandler)
+	api.GET("/contacts/trash", handler.TrashContactsHandler)
+	api.DELETE("/contacts/trash", handler.EmptyTrashHandler)
+	api.DELETE("/contacts/trash/:id", handler.PurgeContactHandler)
+	api.POST("/contacts/:id/restore", handler.RestoreContactHandler)
+	api.GET("/contacts/:id/history", handler.HistoryHandler)
+	api.GET("/contacts/:id/history/diff", handler.DiffRevisionsHandler)
+	api.POST("/contacts/:id/revert", handler.RevertContactHandler)
+	api.POST("/contacts/merge", handler.MergeContactsHandler)
+	api.GET("/contacts/:id/vcard", handler.GetContactVCardHandler)
+	api.GET("/contacts/export", handler.ExportContactsHandler)
+	api.GET("/contacts/export.vcf", handler.ExportVCardHandler)
+	api.POST("/contacts/import", handler.ImportVCardHandler)
+	api.POST("/contacts/import/csv", handler.ImportCSVHandler)
+	api.GET("/lookup", handler.LookupHandler)
+	api.GET("/tags", handler.ListTagsHandler)
+	api.POST("/tags", handler.CreateTagHandler)
+	api.GET("/tags/:name", handler.GetTagHandler)
+	api.PUT("/tags/:name", handler.RenameTagHandler)
+	api.DELETE("/tags/:name", handler.DeleteTagHandler)
+	api.POST("/tags/:name/contacts", handler.TagContactsHandler)
+	api.DELETE("/tags/:name/contacts", handler.UntagContactsHandler)
+	api.GET("/groups", handler.ListGroupsHandler)
+	api.POST("/groups", handler.CreateGroupHandler)
+	api.GET("/groups/:name", handler.GetGroupHandler)
+	api.PUT("/groups/:name", handler.UpdateGroupHandler)
+	api.DELETE("/groups/:name", handler.DeleteGroupHandler)
+	api.GET("/groups/:name/contacts", handler.GroupContactsHandler)
+
+	admin := api.Group("/", handler.RequireAdmin)
+	admin.GET("/users", handler.ListUsersHandler)
+	admin.POST("/users", handler.CreateUserHandler)
+	admin.GET("/api-keys", handler.ListAPIKeysHandler)
+	admin.POST("/api-keys", handler.CreateAPIKeyHandler)
+	admin.DELETE("/api-keys/:id", handler.RevokeAPIKeyHandler)
 
 	return router
 }
@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"phonebook/internal/contacts"
+	"time"
+	"unicode/utf8"
+)
+
+// Scopes an API key can be limited to
+const (
+	// ScopeReadOnly allows reading contacts, tags and groups
+	ScopeReadOnly = "read-only"
+	// ScopeReadWrite allows everything a user can do, except managing users
+	// and API keys
+	ScopeReadWrite = "read-write"
+	// ScopeImport only allows importing contacts
+	ScopeImport = "import"
+)
+
+var scopes = map[string]bool{
+	ScopeReadOnly:  true,
+	ScopeReadWrite: true,
+	ScopeImport:    true,
+}
+
+// APIKeyPrefix starts every API key, so a key sent as a bearer token can be
+// told apart from an access token
+const APIKeyPrefix = "pbk_"
+
+// apiKeyShownLength is how much of a key is stored in the clear, so keys
+// can be recognized in listings
+const apiKeyShownLength = len(APIKeyPrefix) + 6
+
+// APIKey is a long-lived credential for scripts and other services. Only
+// a hash of the key itself is stored.
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scope      string     `json:"scope"`
+	CreatedBy  string     `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// NewAPIKey is an API key to create
+type NewAPIKey struct {
+	Name  string `json:"name"`
+	Scope string `json:"scope"`
+}
+
+// Validate checks the name and scope of a new API key
+func (k *NewAPIKey) Validate() error {
+	verr := &contacts.ValidationError{}
+
+	switch {
+	case k.Name == "":
+		verr.Add("name", "is required")
+	case utf8.RuneCountInString(k.Name) > maxUsernameLength:
+		verr.Add("name", "must be at most 50 characters")
+	}
+	if !scopes[k.Scope] {
+		verr.Add("scope", "must be one of read-only, read-write or import")
+	}
+
+	return verr.Err()
+}
+
+// generateAPIKey returns a new random API key
+func generateAPIKey() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return APIKeyPrefix + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// hashAPIKey returns the hash a key is stored and looked up by. Keys are
+// random and long, so unlike passwords a fast hash cannot be brute forced.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+const selectAPIKey = `id, name, prefix, scope, created_by, created_at, last_used_at, revoked_at`
+
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (*APIKey, error) {
+	var k APIKey
+	var lastUsedAt, revokedAt sql.NullTime
+	if err := row.Scan(&k.ID, &k.Name, &k.Prefix, &k.Scope, &k.CreatedBy, &k.CreatedAt, &lastUsedAt, &revokedAt); err != nil {
+		return nil, err
+	}
+	if lastUsedAt.Valid {
+		k.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		k.RevokedAt = &revokedAt.Time
+	}
+	return &k, nil
+}
+
+// CreateAPIKey stores an API key by its hash
+func (r *Repository) CreateAPIKey(k *NewAPIKey, prefix, hash, createdBy string) (*APIKey, error) {
+	key := &APIKey{Name: k.Name, Prefix: prefix, Scope: k.Scope, CreatedBy: createdBy}
+	err := r.DB.QueryRow(`INSERT INTO api_keys (name, prefix, key_hash, scope, created_by) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`,
+		k.Name, prefix, hash, k.Scope, createdBy).Scan(&key.ID, &key.CreatedAt)
+	if isUniqueViolation(err) {
+		return nil, ErrAPIKeyExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// APIKeys returns every API key, revoked ones included, oldest first
+func (r *Repository) APIKeys() ([]APIKey, error) {
+	rows, err := r.DB.Query(`SELECT ` + selectAPIKey + ` FROM api_keys ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *k)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey stops an API key from being accepted. Revoking a key twice
+// keeps the time it was first revoked.
+func (r *Repository) RevokeAPIKey(id int) (*APIKey, error) {
+	k, err := scanAPIKey(r.DB.QueryRow(`UPDATE api_keys SET revoked_at = COALESCE(revoked_at, now()) WHERE id = $1 RETURNING `+selectAPIKey, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAPIKeyNotFound
+	}
+	return k, err
+}
+
+// UseAPIKey returns the live API key with hash, recording that it was used
+// now. The time is only written when it is unknown or older than a minute,
+// so a busy key does not cost a write on every request. It returns
+// ErrInvalidAPIKey when there is none.
+func (r *Repository) UseAPIKey(hash string) (*APIKey, error) {
+	k, err := scanAPIKey(r.DB.QueryRow(`UPDATE api_keys SET last_used_at = now() WHERE key_hash = $1 AND revoked_at IS NULL AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute') RETURNING `+selectAPIKey, hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		k, err = scanAPIKey(r.DB.QueryRow(`SELECT `+selectAPIKey+` FROM api_keys WHERE key_hash = $1 AND revoked_at IS NULL`, hash))
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidAPIKey
+	}
+	return k, err
+}
+
+// CreateAPIKey creates an API key on behalf of createdBy and returns it with
+// the key itself, which is never shown again
+func (s *Service) CreateAPIKey(k *NewAPIKey, createdBy string) (string, *APIKey, error) {
+	if err := k.Validate(); err != nil {
+		return "", nil, err
+	}
+	key, err := generateAPIKey()
+	if err != nil {
+		return "", nil, err
+	}
+	created, err := s.repo.CreateAPIKey(k, key[:apiKeyShownLength], hashAPIKey(key), createdBy)
+	if err != nil {
+		return "", nil, err
+	}
+	return key, created, nil
+}
+
+func (s *Service) APIKeys() ([]APIKey, error) {
+	return s.repo.APIKeys()
+}
+
+func (s *Service) RevokeAPIKey(id int) (*APIKey, error) {
+	return s.repo.RevokeAPIKey(id)
+}
+
+// AuthenticateKey returns the principal a live API key acts as. Its changes
+// are recorded as made by "api-key:" and the name of the key.
+func (s *Service) AuthenticateKey(key string) (*Principal, error) {
+	k, err := s.repo.UseAPIKey(hashAPIKey(key))
+	if err != nil {
+		return nil, err
+	}
+	return &Principal{Name: "api-key:" + k.Name, KeyID: k.ID, Scope: k.Scope}, nil
+}
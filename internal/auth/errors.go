@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	// ErrUnauthorized is returned when a request carries no credentials
+	ErrUnauthorized = errors.New("authentication required")
+
+	// ErrInvalidToken is returned when a token is malformed, forged, expired
+	// or of the wrong type
+	ErrInvalidToken = errors.New("invalid or expired token")
+
+	// ErrInvalidCredentials is returned when a username and password do not match
+	ErrInvalidCredentials = errors.New("invalid username or password")
+
+	// ErrForbidden is returned when a user may not do what was requested
+	ErrForbidden = errors.New("admin access required")
+
+	// ErrInvalidAPIKey is returned when an API key is unknown or revoked
+	ErrInvalidAPIKey = errors.New("invalid or revoked API key")
+
+	// ErrInsufficientScope is returned when an API key is used beyond its scope
+	ErrInsufficientScope = errors.New("API key scope does not allow this request")
+
+	// ErrAPIKeyNotFound is returned when the requested API key does not exist
+	ErrAPIKeyNotFound = errors.New("API key not found")
+
+	// ErrAPIKeyExists is returned when a live API key already has the name
+	ErrAPIKeyExists = errors.New("API key already exists")
+
+	// ErrUserNotFound is returned when the requested user does not exist
+	ErrUserNotFound = errors.New("user not found")
+
+	// ErrUserExists is returned when a username is already taken
+	ErrUserExists = errors.New("user already exists")
+)
+
+// isUniqueViolation reports whether err is a PostgreSQL unique violation
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
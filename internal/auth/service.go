@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dummyHash is compared against when a username is unknown, so a failed
+// login takes as long whether or not the user exists
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+
+type Service struct {
+	repo   *Repository
+	signer Signer
+}
+
+func NewService(db *sql.DB, signer Signer) *Service {
+	return &Service{
+		repo:   NewRepository(db),
+		signer: signer,
+	}
+}
+
+// CreateUser stores a new user with a bcrypt hash of their password
+func (s *Service) CreateUser(u *NewUser) (*User, error) {
+	if err := u.Validate(); err != nil {
+		return nil, err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.CreateUser(u, hash)
+}
+
+func (s *Service) Users() ([]User, error) {
+	return s.repo.Users()
+}
+
+// Login checks a username and password and issues tokens for the user. It
+// returns ErrInvalidCredentials without saying which of the two was wrong.
+func (s *Service) Login(username, password string) (*TokenPair, error) {
+	user, hash, err := s.repo.UserByName(username)
+	if errors.Is(err, ErrUserNotFound) {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return s.signer.Issue(user)
+}
+
+// Refresh exchanges a refresh token for a new pair of tokens. The user is
+// read again so deleted users cannot refresh and admin rights are current.
+func (s *Service) Refresh(refreshToken string) (*TokenPair, error) {
+	claims, err := s.signer.Verify(refreshToken, RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.repo.GetUser(claims.UserID)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.signer.Issue(user)
+}
+
+// Principal is who a request is authenticated as: a user signed in with an
+// access token, or an API key limited to its scope
+type Principal struct {
+	// Name is recorded as the actor of the changes made
+	Name   string
+	UserID int
+	Admin  bool
+	KeyID  int
+	// Scope limits what an API key may do; it is empty for users
+	Scope string
+}
+
+// Authenticate returns the user a valid access token was issued to
+func (s *Service) Authenticate(accessToken string) (*Principal, error) {
+	claims, err := s.signer.Verify(accessToken, AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	return &Principal{Name: claims.Subject, UserID: claims.UserID, Admin: claims.Admin}, nil
+}
@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"phonebook/internal/contacts"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits on the credentials of a user. bcrypt only hashes the first 72
+// bytes of a password, so longer ones are refused rather than truncated.
+const (
+	maxUsernameLength = 50
+	minPasswordLength = 8
+	maxPasswordBytes  = 72
+)
+
+// User is someone who can sign in to the API
+type User struct {
+	ID        int       `json:"id"`
+	Username  string    `json:"username"`
+	Admin     bool      `json:"admin"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// NewUser is a user to create, with the password they will sign in with
+type NewUser struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Admin    bool   `json:"admin"`
+}
+
+// Validate checks the username and password of a new user
+func (u *NewUser) Validate() error {
+	verr := &contacts.ValidationError{}
+
+	switch {
+	case u.Username == "":
+		verr.Add("username", "is required")
+	case utf8.RuneCountInString(u.Username) > maxUsernameLength:
+		verr.Add("username", "must be at most 50 characters")
+	case strings.IndexFunc(u.Username, unicode.IsSpace) >= 0:
+		verr.Add("username", "must not contain spaces")
+	}
+	switch {
+	case utf8.RuneCountInString(u.Password) < minPasswordLength:
+		verr.Add("password", "must be at least 8 characters")
+	case len(u.Password) > maxPasswordBytes:
+		verr.Add("password", "must be at most 72 bytes")
+	}
+
+	return verr.Err()
+}
+
+type Repository struct {
+	DB *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{DB: db}
+}
+
+// CreateUser stores a user with the hash of their password
+func (r *Repository) CreateUser(u *NewUser, hash []byte) (*User, error) {
+	user := &User{Username: u.Username, Admin: u.Admin}
+	err := r.DB.QueryRow(`INSERT INTO users (username, password_hash, is_admin) VALUES ($1, $2, $3) RETURNING id, created_at`,
+		u.Username, string(hash), u.Admin).Scan(&user.ID, &user.CreatedAt)
+	if isUniqueViolation(err) {
+		return nil, ErrUserExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// UserByName returns a user and their password hash. Usernames are matched
+// regardless of case.
+func (r *Repository) UserByName(username string) (*User, []byte, error) {
+	var user User
+	var hash string
+	err := r.DB.QueryRow(`SELECT id, username, password_hash, is_admin, created_at FROM users WHERE lower(username) = lower($1)`, username).
+		Scan(&user.ID, &user.Username, &hash, &user.Admin, &user.CreatedAt)
+	if err != nil {
+		return nil, nil, userNotFound(err)
+	}
+	return &user, []byte(hash), nil
+}
+
+// GetUser returns a user by ID
+func (r *Repository) GetUser(id int) (*User, error) {
+	var user User
+	err := r.DB.QueryRow(`SELECT id, username, is_admin, created_at FROM users WHERE id = $1`, id).
+		Scan(&user.ID, &user.Username, &user.Admin, &user.CreatedAt)
+	if err != nil {
+		return nil, userNotFound(err)
+	}
+	return &user, nil
+}
+
+// Users returns every user ordered by username
+func (r *Repository) Users() ([]User, error) {
+	rows, err := r.DB.Query(`SELECT id, username, is_admin, created_at FROM users ORDER BY lower(username)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Username, &user.Admin, &user.CreatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// userNotFound maps sql.ErrNoRows to ErrUserNotFound and returns other errors unchanged
+func userNotFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUserNotFound
+	}
+	return err
+}
@@ -0,0 +1,496 @@
+package contacts
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Built-in CSV formats accepted by CSVImportOptions
+const (
+	// CSVFormatAuto picks the format from the header row
+	CSVFormatAuto = "auto"
+	// CSVFormatGoogle reads files exported from Google Contacts
+	CSVFormatGoogle = "google"
+	// CSVFormatOutlook reads files exported from Outlook
+	CSVFormatOutlook = "outlook"
+	// CSVFormatGeneric reads columns named after contact fields, such as
+	// first_name, last_name, phone and email
+	CSVFormatGeneric = "generic"
+)
+
+// Batch sizes of a CSV import
+const (
+	DefaultCSVBatchSize = 100
+	MaxCSVBatchSize     = 1000
+)
+
+// CSVMapping maps CSV column headers to contact fields. A field is one of
+// first_name, last_name, name (a full name that is split when first_name and
+// last_name are empty) and note, or a <collection>.<key>.<field> path into
+// phones (number, label, extension), emails (address, label), addresses
+// (street, city, region, postal_code, country, label) or websites (url, label).
+// Columns with the same collection and key make up one item, e.g.
+// "Phone 1 - Value" to phones.1.number and "Phone 1 - Label" to phones.1.label.
+// Without a label column a non-numeric key, minus trailing digits, is the
+// label: "Home Phone 2" to phones.home2.number is a home number.
+type CSVMapping map[string]string
+
+// CSVImportOptions controls a CSV import. Mapping, when set, takes precedence
+// over Format.
+type CSVImportOptions struct {
+	Format    string
+	Mapping   CSVMapping
+	DryRun    bool
+	BatchSize int
+}
+
+// csvFields lists the fields each collection of a mapping accepts
+var csvFields = map[string][]string{
+	"phones":    {"number", "label", "extension"},
+	"emails":    {"address", "label"},
+	"addresses": {"street", "city", "region", "postal_code", "country", "label"},
+	"websites":  {"url", "label"},
+}
+
+// csvNumbered is how many numbered columns of each kind the Google mapping covers
+const csvNumbered = 9
+
+// csvValueSeparator separates several values in one Google Contacts cell
+const csvValueSeparator = ":::"
+
+// CSVMappingFor returns the built-in mapping of a format
+func CSVMappingFor(format string) (CSVMapping, error) {
+	switch format {
+	case CSVFormatGoogle:
+		return googleCSVMapping(), nil
+	case CSVFormatOutlook:
+		return outlookCSVMapping(), nil
+	case CSVFormatGeneric:
+		return genericCSVMapping(), nil
+	}
+	return nil, &ValidationError{Fields: []FieldError{{Field: "format", Message: "must be auto, google, outlook or generic"}}}
+}
+
+func googleCSVMapping() CSVMapping {
+	m := CSVMapping{
+		"First Name":  "first_name",
+		"Given Name":  "first_name",
+		"Last Name":   "last_name",
+		"Family Name": "last_name",
+		"Name":        "name",
+		"Notes":       "note",
+	}
+	for i := 1; i <= csvNumbered; i++ {
+		n := strconv.Itoa(i)
+		for _, kind := range []string{"Type", "Label"} {
+			m["Phone "+n+" - "+kind] = "phones." + n + ".label"
+			m["E-mail "+n+" - "+kind] = "emails." + n + ".label"
+			m["Address "+n+" - "+kind] = "addresses." + n + ".label"
+			m["Website "+n+" - "+kind] = "websites." + n + ".label"
+		}
+		m["Phone "+n+" - Value"] = "phones." + n + ".number"
+		m["E-mail "+n+" - Value"] = "emails." + n + ".address"
+		m["Address "+n+" - Street"] = "addresses." + n + ".street"
+		m["Address "+n+" - City"] = "addresses." + n + ".city"
+		m["Address "+n+" - Region"] = "addresses." + n + ".region"
+		m["Address "+n+" - Postal Code"] = "addresses." + n + ".postal_code"
+		m["Address "+n+" - Country"] = "addresses." + n + ".country"
+		m["Website "+n+" - Value"] = "websites." + n + ".url"
+	}
+	return m
+}
+
+func outlookCSVMapping() CSVMapping {
+	m := CSVMapping{
+		"First Name":       "first_name",
+		"Last Name":        "last_name",
+		"Notes":            "note",
+		"E-mail Address":   "emails.1.address",
+		"E-mail 2 Address": "emails.2.address",
+		"E-mail 3 Address": "emails.3.address",
+		"Mobile Phone":     "phones.mobile.number",
+		"Home Phone":       "phones.home.number",
+		"Home Phone 2":     "phones.home2.number",
+		"Business Phone":   "phones.work.number",
+		"Business Phone 2": "phones.work2.number",
+		"Business Fax":     "phones.fax.number",
+		"Home Fax":         "phones.fax2.number",
+		"Other Phone":      "phones.other.number",
+		"Web Page":         "websites.other.url",
+	}
+	for prefix, key := range map[string]string{"Home": "home", "Business": "work", "Other": "other"} {
+		m[prefix+" Street"] = "addresses." + key + ".street"
+		m[prefix+" City"] = "addresses." + key + ".city"
+		m[prefix+" State"] = "addresses." + key + ".region"
+		m[prefix+" Postal Code"] = "addresses." + key + ".postal_code"
+		m[prefix+" Country/Region"] = "addresses." + key + ".country"
+	}
+	return m
+}
+
+func genericCSVMapping() CSVMapping {
+	return CSVMapping{
+		"first_name":   "first_name",
+		"last_name":    "last_name",
+		"name":         "name",
+		"note":         "note",
+		"phone":        "phones.1.number",
+		"phone_number": "phones.1.number",
+		"phone_label":  "phones.1.label",
+		"extension":    "phones.1.extension",
+		"email":        "emails.1.address",
+		"street":       "addresses.1.street",
+		"city":         "addresses.1.city",
+		"region":       "addresses.1.region",
+		"postal_code":  "addresses.1.postal_code",
+		"country":      "addresses.1.country",
+		"website":      "websites.1.url",
+	}
+}
+
+// detectCSVFormat guesses the format of a file from its header row
+func detectCSVFormat(header []string) string {
+	for _, h := range header {
+		switch strings.ToLower(h) {
+		case "given name", "phone 1 - value", "e-mail 1 - value":
+			return CSVFormatGoogle
+		case "mobile phone", "business phone", "e-mail address":
+			return CSVFormatOutlook
+		}
+	}
+	return CSVFormatGeneric
+}
+
+// csvTarget is a parsed mapping field
+type csvTarget struct {
+	collection, key, field string
+}
+
+func parseCSVTarget(target string) (csvTarget, error) {
+	switch target {
+	case "first_name", "last_name", "name", "note":
+		return csvTarget{field: target}, nil
+	}
+
+	parts := strings.Split(target, ".")
+	if len(parts) == 3 && parts[1] != "" {
+		for _, field := range csvFields[parts[0]] {
+			if field == parts[2] {
+				return csvTarget{collection: parts[0], key: parts[1], field: parts[2]}, nil
+			}
+		}
+	}
+	return csvTarget{}, errors.New("unknown field " + target)
+}
+
+// csvItem collects the columns of one phone, email, address or website
+type csvItem struct {
+	key    string
+	values map[string]string
+}
+
+// csvMapper turns CSV records into contacts using the columns of a header row
+type csvMapper struct {
+	targets []*csvTarget
+}
+
+// newCSVMapper matches the header row against the mapping. Headers are
+// compared case-insensitively and columns without a mapping are ignored.
+func newCSVMapper(header []string, mapping CSVMapping) (*csvMapper, error) {
+	fields := make(map[string]csvTarget, len(mapping))
+	verr := &ValidationError{}
+	for column, target := range mapping {
+		t, err := parseCSVTarget(target)
+		if err != nil {
+			verr.Add("mapping", err.Error())
+			continue
+		}
+		fields[strings.ToLower(strings.TrimSpace(column))] = t
+	}
+	if err := verr.Err(); err != nil {
+		return nil, err
+	}
+
+	m := &csvMapper{targets: make([]*csvTarget, len(header))}
+	mapped := false
+	for i, h := range header {
+		h = strings.TrimPrefix(h, "\ufeff")
+		if t, ok := fields[strings.ToLower(strings.TrimSpace(h))]; ok {
+			m.targets[i] = &t
+			mapped = true
+		}
+	}
+	if !mapped {
+		return nil, &ValidationError{Fields: []FieldError{{Field: "mapping", Message: "no column of the file is mapped to a contact field"}}}
+	}
+	return m, nil
+}
+
+// contact builds the contact described by a record
+func (m *csvMapper) contact(record []string) Contact {
+	var contact Contact
+	var name string
+	items := map[string][]*csvItem{}
+
+	for i, value := range record {
+		if i >= len(m.targets) || m.targets[i] == nil {
+			continue
+		}
+		t := m.targets[i]
+		value = strings.TrimSpace(value)
+
+		switch t.field {
+		case "first_name":
+			contact.FirstName = value
+			continue
+		case "last_name":
+			contact.LastName = value
+			continue
+		case "name":
+			name = value
+			continue
+		case "note":
+			contact.Note = value
+			continue
+		}
+
+		var item *csvItem
+		for _, it := range items[t.collection] {
+			if it.key == t.key {
+				item = it
+			}
+		}
+		if item == nil {
+			item = &csvItem{key: t.key, values: map[string]string{}}
+			items[t.collection] = append(items[t.collection], item)
+		}
+		item.values[t.field] = value
+	}
+
+	if contact.FirstName == "" && contact.LastName == "" {
+		if fn := strings.Fields(name); len(fn) > 1 {
+			contact.FirstName = strings.Join(fn[:len(fn)-1], " ")
+			contact.LastName = fn[len(fn)-1]
+		} else if len(fn) == 1 {
+			contact.FirstName = fn[0]
+		}
+	}
+
+	for _, it := range items["phones"] {
+		label, primary := csvLabel(it)
+		for _, number := range csvValues(it.values["number"]) {
+			contact.Phones = append(contact.Phones, Phone{Number: number, Label: label, Primary: primary, Extension: it.values["extension"]})
+			primary = false
+		}
+	}
+	for _, it := range items["emails"] {
+		label, _ := csvLabel(it)
+		for _, address := range csvValues(it.values["address"]) {
+			contact.Emails = append(contact.Emails, Email{Address: address, Label: label})
+		}
+	}
+	for _, it := range items["addresses"] {
+		label, _ := csvLabel(it)
+		address := Address{
+			Label:      label,
+			Street:     it.values["street"],
+			City:       it.values["city"],
+			Region:     it.values["region"],
+			PostalCode: it.values["postal_code"],
+			Country:    it.values["country"],
+		}
+		if address.Street != "" || address.City != "" || address.Region != "" || address.PostalCode != "" || address.Country != "" {
+			contact.Addresses = append(contact.Addresses, address)
+		}
+	}
+	for _, it := range items["websites"] {
+		label, _ := csvLabel(it)
+		for _, url := range csvValues(it.values["url"]) {
+			contact.Websites = append(contact.Websites, Website{URL: url, Label: label})
+		}
+	}
+
+	// Only the first number marked primary stays primary
+	primary := false
+	for i := range contact.Phones {
+		contact.Phones[i].Primary = contact.Phones[i].Primary && !primary
+		primary = primary || contact.Phones[i].Primary
+	}
+	return contact
+}
+
+// csvLabel returns the label of an item: its label column, or else its key
+// when the key is not a number. Google Contacts marks the primary value of a
+// kind with a leading "* ".
+func csvLabel(it *csvItem) (label string, primary bool) {
+	label, ok := it.values["label"]
+	if !ok {
+		if _, err := strconv.Atoi(it.key); err != nil {
+			label = strings.TrimRight(it.key, "0123456789")
+		}
+	}
+	label = strings.TrimSpace(label)
+	if rest, ok := strings.CutPrefix(label, "*"); ok {
+		label, primary = strings.TrimSpace(rest), true
+	}
+	// Google joins the labels of several values in one cell; the first is kept
+	label, _, _ = strings.Cut(label, csvValueSeparator)
+	return strings.TrimSpace(label), primary
+}
+
+// csvValues splits a cell that may hold several values
+func csvValues(cell string) []string {
+	values := []string{}
+	for _, v := range strings.Split(cell, csvValueSeparator) {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// csvRecord is a contact read from one line of a CSV file
+type csvRecord struct {
+	line    int
+	contact Contact
+}
+
+// ImportCSV reads contacts from a CSV file one record at a time and stores
+// them in batches, one transaction per batch. Every record is validated and
+// checked for numbers that already belong to a contact or appear earlier in
+// the file; those are skipped. With opts.DryRun nothing is written and the
+// report tells what an import would do. The error is only set when the file
+// or the options cannot be used at all.
+func (s *Service) ImportCSV(r io.Reader, opts CSVImportOptions) (*ImportReport, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultCSVBatchSize
+	}
+	if opts.BatchSize > MaxCSVBatchSize {
+		opts.BatchSize = MaxCSVBatchSize
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, &ValidationError{Fields: []FieldError{{Field: "file", Message: "could not read the CSV header: " + err.Error()}}}
+	}
+
+	mapping := opts.Mapping
+	if mapping == nil {
+		format := opts.Format
+		if format == "" || format == CSVFormatAuto {
+			format = detectCSVFormat(header)
+		}
+		if mapping, err = CSVMappingFor(format); err != nil {
+			return nil, err
+		}
+	}
+	mapper, err := newCSVMapper(header, mapping)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ImportReport{DryRun: opts.DryRun, Results: []ImportResult{}}
+	seen := map[string]int{}
+	batch := make([]csvRecord, 0, opts.BatchSize)
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			report.add(ImportResult{Line: parseErr.Line, Status: ImportFailed, Reason: parseErr.Error()})
+			continue
+		}
+		if err != nil {
+			report.add(ImportResult{Status: ImportFailed, Reason: err.Error()})
+			break
+		}
+		line, _ := reader.FieldPos(0)
+
+		contact := mapper.contact(record)
+		if err := contact.Validate(); err != nil {
+			report.add(ImportResult{Line: line, Name: contact.FirstName + " " + contact.LastName, Status: ImportFailed, Reason: err.Error()})
+			continue
+		}
+
+		batch = append(batch, csvRecord{line: line, contact: contact})
+		if len(batch) == opts.BatchSize {
+			if err := s.importBatch(batch, opts.DryRun, seen, report); err != nil {
+				return nil, err
+			}
+			batch = batch[:0]
+		}
+	}
+
+	if len(batch) > 0 {
+		if err := s.importBatch(batch, opts.DryRun, seen, report); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// importBatch stores a batch of valid records and adds their results to the
+// report. seen holds the line of every number already imported from the
+// file, which the database cannot report during a dry run.
+func (s *Service) importBatch(batch []csvRecord, dryRun bool, seen map[string]int, report *ImportReport) error {
+	contacts := make([]*Contact, len(batch))
+	for i := range batch {
+		contacts[i] = &batch[i].contact
+	}
+
+	errs, err := s.repo.CreateContacts(contacts, dryRun)
+	if err != nil {
+		return err
+	}
+
+	for i, rec := range batch {
+		c := rec.contact
+		result := ImportResult{Line: rec.line, Name: c.FirstName + " " + c.LastName, ID: c.ID}
+
+		err := errs[i]
+		var batchErr *BatchDuplicatePhoneError
+		if errors.As(err, &batchErr) {
+			result.Reason = "phone number " + batchErr.Number + " is already on line " + strconv.Itoa(batch[batchErr.First].line)
+		}
+		if err == nil && dryRun {
+			for _, p := range c.Phones {
+				if line, ok := seen[p.E164]; ok {
+					err = &DuplicatePhoneError{Number: p.Number}
+					result.Reason = "phone number " + p.Number + " is already on line " + strconv.Itoa(line)
+					break
+				}
+			}
+		}
+		if err == nil {
+			for _, p := range c.Phones {
+				seen[p.E164] = rec.line
+			}
+		}
+
+		switch {
+		case err == nil:
+			result.Status = ImportCreated
+		case errors.Is(err, ErrDuplicatePhone):
+			result.Status = ImportSkipped
+		default:
+			result.Status = ImportFailed
+		}
+		if err != nil && result.Reason == "" {
+			result.Reason = err.Error()
+		}
+		report.add(result)
+	}
+	return nil
+}
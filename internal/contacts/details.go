@@ -0,0 +1,97 @@
+package contacts
+
+import (
+	"database/sql"
+	"encoding/json"
+)
+
+// detailColumns selects the emails, addresses, websites and tag names of
+// contact c as JSON arrays, so contacts are read with their details in a
+// single query
+const detailColumns = `
+        COALESCE((SELECT json_agg(json_build_object('address', e.address, 'label', e.label) ORDER BY e.id)
+                  FROM emails e WHERE e.contact_id = c.id), '[]') AS emails,
+        COALESCE((SELECT json_agg(json_build_object('label', a.label, 'street', a.street, 'city', a.city,
+                  'region', a.region, 'postal_code', a.postal_code, 'country', a.country) ORDER BY a.id)
+                  FROM addresses a WHERE a.contact_id = c.id), '[]') AS addresses,
+        COALESCE((SELECT json_agg(json_build_object('url', w.url, 'label', w.label) ORDER BY w.id)
+                  FROM websites w WHERE w.contact_id = c.id), '[]') AS websites,
+        COALESCE((SELECT json_agg(t.name ORDER BY lower(t.name))
+                  FROM contact_tags ct JOIN tags t ON t.id = ct.tag_id WHERE ct.contact_id = c.id), '[]') AS tags`
+
+// detailsRow scans the JSON arrays selected by detailColumns
+type detailsRow struct {
+	emails, addresses, websites, tags []byte
+}
+
+// apply decodes the scanned details into the contact
+func (d detailsRow) apply(contact *Contact) error {
+	contact.Emails, contact.Addresses, contact.Websites, contact.Tags = []Email{}, []Address{}, []Website{}, []string{}
+	if err := unmarshalDetail(d.emails, &contact.Emails); err != nil {
+		return err
+	}
+	if err := unmarshalDetail(d.addresses, &contact.Addresses); err != nil {
+		return err
+	}
+	if err := unmarshalDetail(d.websites, &contact.Websites); err != nil {
+		return err
+	}
+	return unmarshalDetail(d.tags, &contact.Tags)
+}
+
+func unmarshalDetail(data []byte, v interface{}) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+// prepareDetails normalizes labels and adds a scheme to websites typed without one
+func prepareDetails(contact *Contact) {
+	for i := range contact.Emails {
+		contact.Emails[i].Label = normalizeLabel(contact.Emails[i].Label, LabelOther)
+	}
+	for i := range contact.Addresses {
+		contact.Addresses[i].Label = normalizeLabel(contact.Addresses[i].Label, LabelOther)
+	}
+	for i := range contact.Websites {
+		contact.Websites[i].Label = normalizeLabel(contact.Websites[i].Label, LabelOther)
+		contact.Websites[i].URL = websiteURL(contact.Websites[i].URL)
+	}
+}
+
+// insertDetails stores the prepared emails, addresses and websites of a contact inside tx
+func insertDetails(tx *sql.Tx, contactID int, contact *Contact) error {
+	for _, e := range contact.Emails {
+		_, err := tx.Exec(`INSERT INTO emails (contact_id, address, label) VALUES ($1, $2, $3)`,
+			contactID, e.Address, e.Label)
+		if err != nil {
+			return detailError(err, "emails")
+		}
+	}
+	for _, a := range contact.Addresses {
+		_, err := tx.Exec(`INSERT INTO addresses (contact_id, label, street, city, city_folded, region, postal_code, country) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			contactID, a.Label, a.Street, a.City, FoldText(a.City), a.Region, a.PostalCode, a.Country)
+		if err != nil {
+			return detailError(err, "addresses")
+		}
+	}
+	for _, w := range contact.Websites {
+		_, err := tx.Exec(`INSERT INTO websites (contact_id, url, label) VALUES ($1, $2, $3)`,
+			contactID, w.URL, w.Label)
+		if err != nil {
+			return detailError(err, "websites")
+		}
+	}
+	return nil
+}
+
+// deleteDetails removes the emails, addresses and websites of a contact inside tx
+func deleteDetails(tx *sql.Tx, contactID int) error {
+	for _, table := range []string{"emails", "addresses", "websites"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE contact_id = $1`, contactID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,369 @@
+package contacts
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// DefaultDuplicateScore is the score from which two contacts are reported as
+// likely duplicates
+const DefaultDuplicateScore = 0.5
+
+// Reasons a pair of contacts matched
+const (
+	ReasonSamePhone   = "same_phone"
+	ReasonPhoneSuffix = "phone_suffix"
+	ReasonSimilarName = "similar_name"
+	ReasonSwappedName = "swapped_name"
+)
+
+// Weights of the duplicate score. Names only count from minNameSimilarity,
+// below which unrelated names share too many trigrams by chance.
+const (
+	samePhoneScore    = 0.7
+	phoneSuffixScore  = 0.4
+	nameScoreWeight   = 0.6
+	minNameSimilarity = 0.3
+)
+
+// phoneSuffixLength is how many trailing digits of two numbers must agree for
+// them to count as the same local number written with different prefixes
+const phoneSuffixLength = 7
+
+// MatchReason explains part of a duplicate score
+type MatchReason struct {
+	Reason string `json:"reason"`
+	Detail string `json:"detail"`
+}
+
+// DuplicateMatch is a contact that looks like a duplicate of another one
+type DuplicateMatch struct {
+	Contact Contact       `json:"contact"`
+	Score   float64       `json:"score"`
+	Reasons []MatchReason `json:"reasons"`
+}
+
+// DuplicatePair is a scored pair of contacts within a cluster
+type DuplicatePair struct {
+	A       int           `json:"a"`
+	B       int           `json:"b"`
+	Score   float64       `json:"score"`
+	Reasons []MatchReason `json:"reasons"`
+}
+
+// DuplicateCluster is a group of contacts connected by likely duplicate
+// pairs. Its score is the score of its strongest pair.
+type DuplicateCluster struct {
+	Score    float64         `json:"score"`
+	Contacts []Contact       `json:"contacts"`
+	Pairs    []DuplicatePair `json:"pairs"`
+}
+
+// ScoreDuplicate scores how likely a and b are the same person, from 0 to 1,
+// by their phone numbers and names, and tells why
+func ScoreDuplicate(a, b Contact) (float64, []MatchReason) {
+	score := 0.0
+	reasons := []MatchReason{}
+
+	suffixes := map[string]string{}
+	numbers := map[string]bool{}
+	for _, p := range a.Phones {
+		e164 := phoneKey(p)
+		numbers[e164] = true
+		if len(e164) > phoneSuffixLength {
+			suffixes[e164[len(e164)-phoneSuffixLength:]] = e164
+		}
+	}
+
+	var same, suffix []string
+	for _, p := range b.Phones {
+		e164 := phoneKey(p)
+		switch {
+		case numbers[e164]:
+			same = append(same, e164)
+		case len(e164) > phoneSuffixLength && suffixes[e164[len(e164)-phoneSuffixLength:]] != "":
+			suffix = append(suffix, suffixes[e164[len(e164)-phoneSuffixLength:]]+" and "+e164)
+		}
+	}
+	for _, n := range same {
+		reasons = append(reasons, MatchReason{ReasonSamePhone, n})
+	}
+	for _, n := range suffix {
+		reasons = append(reasons, MatchReason{ReasonPhoneSuffix, n})
+	}
+	switch {
+	case len(same) > 0:
+		score += samePhoneScore
+	case len(suffix) > 0:
+		score += phoneSuffixScore
+	}
+
+	// Trigrams ignore word order, so a swapped name is as similar as a straight
+	// one; comparing the parts tells them apart
+	similarity := trigramSimilarity(FoldText(a.FirstName+" "+a.LastName), FoldText(b.FirstName+" "+b.LastName))
+	if similarity >= minNameSimilarity {
+		reason := ReasonSimilarName
+		straight := trigramSimilarity(FoldText(a.FirstName), FoldText(b.FirstName)) + trigramSimilarity(FoldText(a.LastName), FoldText(b.LastName))
+		swapped := trigramSimilarity(FoldText(a.FirstName), FoldText(b.LastName)) + trigramSimilarity(FoldText(a.LastName), FoldText(b.FirstName))
+		if swapped > straight {
+			reason = ReasonSwappedName
+		}
+		reasons = append(reasons, MatchReason{reason, "name similarity " + strconv.FormatFloat(roundScore(similarity), 'f', -1, 64)})
+		score += nameScoreWeight * similarity
+	}
+
+	if score > 1 {
+		score = 1
+	}
+	return roundScore(score), reasons
+}
+
+// phoneKey is the number two phones are compared by
+func phoneKey(p Phone) string {
+	if p.E164 != "" {
+		return p.E164
+	}
+	return p.Number
+}
+
+func roundScore(s float64) float64 {
+	return float64(int(s*1000+0.5)) / 1000
+}
+
+// trigramSimilarity compares two strings the way pg_trgm's similarity does:
+// the share of trigrams of their lowercased, space padded words they have in common
+func trigramSimilarity(a, b string) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0
+	}
+
+	common := 0
+	for t := range ta {
+		if tb[t] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(ta)+len(tb)-common)
+}
+
+func trigrams(s string) map[string]bool {
+	set := map[string]bool{}
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, w := range words {
+		runes := []rune("  " + w + " ")
+		for i := 0; i+3 <= len(runes); i++ {
+			set[string(runes[i:i+3])] = true
+		}
+	}
+	return set
+}
+
+// FindDuplicates groups contacts that score at least minScore against each
+// other into clusters, strongest first
+func (s *Service) FindDuplicates(minScore float64) ([]DuplicateCluster, error) {
+	candidates, err := s.repo.DuplicateCandidates()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []int{}
+	seen := map[int]bool{}
+	for _, pair := range candidates {
+		for _, id := range pair {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	contacts, err := s.repo.ContactsByID(ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int]Contact, len(contacts))
+	for _, c := range contacts {
+		byID[c.ID] = c
+	}
+
+	// Pairs above the threshold join their contacts' clusters
+	parent := map[int]int{}
+	var root func(id int) int
+	root = func(id int) int {
+		if p, ok := parent[id]; ok && p != id {
+			parent[id] = root(p)
+			return parent[id]
+		}
+		parent[id] = id
+		return id
+	}
+
+	pairs := []DuplicatePair{}
+	for _, pair := range candidates {
+		a, okA := byID[pair[0]]
+		b, okB := byID[pair[1]]
+		if !okA || !okB {
+			continue
+		}
+		score, reasons := ScoreDuplicate(a, b)
+		if score < minScore {
+			continue
+		}
+		pairs = append(pairs, DuplicatePair{A: a.ID, B: b.ID, Score: score, Reasons: reasons})
+		parent[root(a.ID)] = root(b.ID)
+	}
+
+	clusters := map[int]*DuplicateCluster{}
+	for _, pair := range pairs {
+		r := root(pair.A)
+		cluster, ok := clusters[r]
+		if !ok {
+			cluster = &DuplicateCluster{}
+			clusters[r] = cluster
+		}
+		cluster.Pairs = append(cluster.Pairs, pair)
+		if pair.Score > cluster.Score {
+			cluster.Score = pair.Score
+		}
+	}
+	for _, c := range contacts {
+		if cluster, ok := clusters[root(c.ID)]; ok {
+			cluster.Contacts = append(cluster.Contacts, c)
+		}
+	}
+
+	result := make([]DuplicateCluster, 0, len(clusters))
+	for _, cluster := range clusters {
+		result = append(result, *cluster)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Score != result[j].Score {
+			return result[i].Score > result[j].Score
+		}
+		return result[i].Contacts[0].ID < result[j].Contacts[0].ID
+	})
+	return result, nil
+}
+
+// CheckDuplicates returns the stored contacts that score at least
+// DefaultDuplicateScore against contact, best match first
+func (s *Service) CheckDuplicates(contact *Contact) ([]DuplicateMatch, error) {
+	similar, err := s.repo.SimilarContacts(contact)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []DuplicateMatch{}
+	for _, other := range similar {
+		if other.ID == contact.ID {
+			continue
+		}
+		if score, reasons := ScoreDuplicate(*contact, other); score >= DefaultDuplicateScore {
+			matches = append(matches, DuplicateMatch{Contact: other, Score: score, Reasons: reasons})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Score > matches[j].Score })
+	return matches, nil
+}
+
+// DuplicateCandidates returns pairs of contact IDs, lowest first, that share
+// the last digits of a phone number or have similar names. The pairs are
+// only candidates; they are scored by ScoreDuplicate, and those in the trash
+// are left out when the contacts are loaded.
+func (r *Repository) DuplicateCandidates() ([][2]int, error) {
+	rows, err := r.DB.Query(fmt.Sprintf(`
+        SELECT a.contact_id, b.contact_id
+        FROM phone_numbers a
+        JOIN phone_numbers b ON right(a.e164, %[1]d) = right(b.e164, %[1]d) AND a.contact_id < b.contact_id
+        UNION
+        SELECT a.id, b.id
+        FROM contacts a
+        JOIN contacts b ON a.id < b.id AND b.deleted_at IS NULL
+         AND (a.first_name_folded || ' ' || a.last_name_folded) %% (b.first_name_folded || ' ' || b.last_name_folded)
+        WHERE a.deleted_at IS NULL
+    `, phoneSuffixLength))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pairs := [][2]int{}
+	for rows.Next() {
+		var pair [2]int
+		if err := rows.Scan(&pair[0], &pair[1]); err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs, rows.Err()
+}
+
+// SimilarContacts returns the stored contacts that share the last digits of
+// a phone number with contact or have a similar name. contact does not need
+// to be stored; the E.164 form of its phone numbers is filled in so it can
+// be scored against them.
+func (r *Repository) SimilarContacts(contact *Contact) ([]Contact, error) {
+	suffixes := []string{}
+	for i, p := range contact.Phones {
+		e164, err := NormalizePhone(p.Number, r.Region)
+		if err != nil {
+			continue
+		}
+		contact.Phones[i].E164 = e164
+		if len(e164) > phoneSuffixLength {
+			suffixes = append(suffixes, e164[len(e164)-phoneSuffixLength:])
+		}
+	}
+
+	q := listQuery{
+		filter: fmt.Sprintf(`(c.id IN (SELECT contact_id FROM phone_numbers WHERE right(e164, %d) = ANY($1::text[]))
+           OR `+fullName+` %% $2)`, phoneSuffixLength),
+		args: []interface{}{
+			textArray(suffixes),
+			FoldText(contact.FirstName + " " + contact.LastName),
+		},
+	}
+	return collectContacts(r.DB, q)
+}
+
+// ContactsByID returns the contacts with the given IDs in ID order; IDs that
+// do not exist are left out
+func (r *Repository) ContactsByID(ids []int) ([]Contact, error) {
+	if len(ids) == 0 {
+		return []Contact{}, nil
+	}
+
+	return collectContacts(r.DB, idQuery(ids))
+}
+
+// idQuery matches the contacts with the given IDs
+func idQuery(ids []int) listQuery {
+	return listQuery{filter: "c.id = ANY($1::int[])", args: []interface{}{intArray(ids)}}
+}
+
+// querier runs queries on the database or inside a transaction
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// collectContacts returns every contact matching q in ID order
+func collectContacts(db querier, q listQuery) ([]Contact, error) {
+	rows, err := db.Query(selectContacts(q, "TRUE", "c.id ASC", "OFFSET 0"), q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contacts := []Contact{}
+	err = scanContacts(rows, func(contact Contact) error {
+		contacts = append(contacts, contact)
+		return nil
+	})
+	return contacts, err
+}
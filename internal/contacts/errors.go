@@ -0,0 +1,246 @@
+package contacts
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// PostgreSQL error codes the repository translates into domain errors
+const (
+	pgUniqueViolation           = "23505"
+	pgStringDataRightTruncation = "22001"
+)
+
+var (
+	// ErrNotFound is returned when the requested contact does not exist
+	ErrNotFound = errors.New("contact not found")
+
+	// ErrDuplicatePhone is returned when a phone number already belongs to a contact
+	ErrDuplicatePhone = errors.New("phone number already exists")
+
+	// ErrVersionMismatch is returned when a contact changed since the version an update was made from
+	ErrVersionMismatch = errors.New("contact was changed by someone else")
+
+	// ErrValidation is returned when a contact fails validation
+	ErrValidation = errors.New("validation failed")
+
+	// ErrInvalidQuery is returned when a search query cannot be parsed
+	ErrInvalidQuery = errors.New("invalid search query")
+
+	// ErrAmbiguousMatch is returned when a looked up number only loosely
+	// matches the numbers of more than one contact
+	ErrAmbiguousMatch = errors.New("phone number matches several contacts")
+)
+
+// DuplicatePhoneError describes a phone number that violates the UNIQUE constraint.
+// Owner is nil when the owning contact could not be determined. Trashed is set
+// when the owner is in the trash, where it still holds its numbers until it is
+// restored or purged.
+type DuplicatePhoneError struct {
+	Number  string
+	Owner   *Contact
+	Trashed bool
+}
+
+func (e *DuplicatePhoneError) Error() string {
+	if e.Owner != nil && e.Trashed {
+		return fmt.Sprintf("phone number %s belongs to contact %d in the trash", e.Number, e.Owner.ID)
+	}
+	if e.Owner != nil {
+		return fmt.Sprintf("phone number %s already belongs to contact %d", e.Number, e.Owner.ID)
+	}
+	return fmt.Sprintf("phone number %s already exists", e.Number)
+}
+
+// Is makes errors.Is(err, ErrDuplicatePhone) match a *DuplicatePhoneError
+func (e *DuplicatePhoneError) Is(target error) bool {
+	return target == ErrDuplicatePhone
+}
+
+// BatchDuplicatePhoneError describes a phone number given to two contacts of
+// the same batch. First and Index are the positions of the earlier and the
+// rejected contact in the batch.
+type BatchDuplicatePhoneError struct {
+	Number string
+	First  int
+	Index  int
+}
+
+func (e *BatchDuplicatePhoneError) Error() string {
+	return fmt.Sprintf("phone number %s of batch contact %d is already on batch contact %d", e.Number, e.Index, e.First)
+}
+
+// Is makes errors.Is(err, ErrDuplicatePhone) match a *BatchDuplicatePhoneError
+func (e *BatchDuplicatePhoneError) Is(target error) bool {
+	return target == ErrDuplicatePhone
+}
+
+// PhoneConflictError lists every phone number that cannot be given to a
+// contact because it already belongs to another one
+type PhoneConflictError struct {
+	Conflicts []DuplicatePhoneError
+}
+
+func (e *PhoneConflictError) Error() string {
+	messages := make([]string, 0, len(e.Conflicts))
+	for _, c := range e.Conflicts {
+		messages = append(messages, c.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Is makes errors.Is(err, ErrDuplicatePhone) match a *PhoneConflictError
+func (e *PhoneConflictError) Is(target error) bool {
+	return target == ErrDuplicatePhone
+}
+
+// VersionMismatchError carries the current copy of a contact that changed
+// since the version an update was made from
+type VersionMismatchError struct {
+	Current *Contact
+}
+
+func (e *VersionMismatchError) Error() string {
+	return fmt.Sprintf("contact %d was changed by someone else and is now at version %d", e.Current.ID, e.Current.Version)
+}
+
+// Is makes errors.Is(err, ErrVersionMismatch) match a *VersionMismatchError
+func (e *VersionMismatchError) Is(target error) bool {
+	return target == ErrVersionMismatch
+}
+
+// AmbiguousMatchError lists the contacts a looked up number matches equally
+// well when it matches none of them exactly
+type AmbiguousMatchError struct {
+	Match      string `json:"match"`
+	ContactIDs []int  `json:"contact_ids"`
+}
+
+func (e *AmbiguousMatchError) Error() string {
+	return fmt.Sprintf("phone number has a %s match with %d contacts", e.Match, len(e.ContactIDs))
+}
+
+// Is makes errors.Is(err, ErrAmbiguousMatch) match a *AmbiguousMatchError
+func (e *AmbiguousMatchError) Is(target error) bool {
+	return target == ErrAmbiguousMatch
+}
+
+// QueryError describes why a search query cannot be parsed. Position is the
+// 1-based character position of the offending token.
+type QueryError struct {
+	Position int    `json:"position"`
+	Message  string `json:"message"`
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("invalid search query at position %d: %s", e.Position, e.Message)
+}
+
+// Is makes errors.Is(err, ErrInvalidQuery) match a *QueryError
+func (e *QueryError) Is(target error) bool {
+	return target == ErrInvalidQuery
+}
+
+// FieldError describes a single invalid field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError collects every invalid field of a contact
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		messages = append(messages, f.Field+": "+f.Message)
+	}
+	return "validation failed: " + strings.Join(messages, "; ")
+}
+
+// Is makes errors.Is(err, ErrValidation) match a *ValidationError
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// Add records an invalid field
+func (e *ValidationError) Add(field, message string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: message})
+}
+
+// Err returns the validation error, or nil when no field was recorded
+func (e *ValidationError) Err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// notFound maps sql.ErrNoRows to ErrNotFound and returns other errors unchanged
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// phoneError translates a failed phone number insert into a domain error.
+// For unique violations the current owner of the number is looked up by its
+// E.164 form outside the failed transaction so it can be reported back, even
+// when it is in the trash.
+func (r *Repository) phoneError(err error, number, e164 string) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+
+	switch pgErr.Code {
+	case pgUniqueViolation:
+		dup := &DuplicatePhoneError{Number: number}
+		var ownerID int
+		err := r.DB.QueryRow(`SELECT p.contact_id, c.deleted_at IS NOT NULL FROM phone_numbers p JOIN contacts c ON c.id = p.contact_id WHERE p.e164 = $1`, e164).
+			Scan(&ownerID, &dup.Trashed)
+		if err != nil {
+			return dup
+		}
+		if !dup.Trashed {
+			if owner, err := r.GetContact(ownerID); err == nil {
+				dup.Owner = owner
+			}
+			return dup
+		}
+		q := idQuery([]int{ownerID})
+		q.trashed = true
+		if owners, err := collectContacts(r.DB, q); err == nil && len(owners) == 1 {
+			dup.Owner = &owners[0]
+		}
+		return dup
+	case pgStringDataRightTruncation:
+		return &ValidationError{Fields: []FieldError{{Field: "phones", Message: "phone number " + number + " is too long"}}}
+	}
+	return err
+}
+
+// contactError translates a failed contacts row write into a domain error
+func contactError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgStringDataRightTruncation {
+		return &ValidationError{Fields: []FieldError{{Field: "name", Message: "name is too long"}}}
+	}
+	return err
+}
+
+// detailError translates a failed email, address or website insert into a domain error
+func detailError(err error, field string) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgStringDataRightTruncation {
+		return &ValidationError{Fields: []FieldError{{Field: field, Message: "value is too long"}}}
+	}
+	return err
+}
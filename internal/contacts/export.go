@@ -0,0 +1,255 @@
+package contacts
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export formats accepted by NewExporter
+const (
+	ExportCSV    = "csv"
+	ExportJSON   = "json"
+	ExportNDJSON = "ndjson"
+)
+
+// ExportFields lists the fields an export can select, in their default order
+var ExportFields = []string{"id", "first_name", "last_name", "phones", "emails", "addresses", "websites", "note", "created_at"}
+
+// ExportContentTypes maps each export format to its content type
+var ExportContentTypes = map[string]string{
+	ExportCSV:    "text/csv",
+	ExportJSON:   "application/json",
+	ExportNDJSON: "application/x-ndjson",
+}
+
+// Exporter writes contacts to w one at a time in CSV, JSON or NDJSON,
+// keeping only the selected fields. Nothing is written before the first
+// contact or Close, so a failure before that can still be reported.
+type Exporter struct {
+	w       io.Writer
+	format  string
+	fields  []string
+	csv     *csv.Writer
+	started bool
+}
+
+// NewExporter checks the format and fields of an export. An empty fields
+// list selects every field.
+func NewExporter(w io.Writer, format string, fields []string) (*Exporter, error) {
+	verr := &ValidationError{}
+	if _, ok := ExportContentTypes[format]; !ok {
+		verr.Add("format", "must be csv, json or ndjson")
+	}
+	if len(fields) == 0 {
+		fields = ExportFields
+	}
+	for _, f := range fields {
+		if !isExportField(f) {
+			verr.Add("fields", "unknown field "+f)
+		}
+	}
+	if err := verr.Err(); err != nil {
+		return nil, err
+	}
+
+	e := &Exporter{w: w, format: format, fields: fields}
+	if format == ExportCSV {
+		e.csv = csv.NewWriter(w)
+	}
+	return e, nil
+}
+
+func isExportField(field string) bool {
+	for _, f := range ExportFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Write writes one contact
+func (e *Exporter) Write(contact Contact) error {
+	first := !e.started
+	if err := e.start(); err != nil {
+		return err
+	}
+
+	switch e.format {
+	case ExportCSV:
+		record := make([]string, len(e.fields))
+		for i, f := range e.fields {
+			record[i] = csvCell(contact, f)
+		}
+		return e.csv.Write(record)
+	case ExportJSON:
+		if !first {
+			if _, err := io.WriteString(e.w, ","); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := json.Marshal(e.object(contact))
+	if err != nil {
+		return err
+	}
+	if e.format == ExportNDJSON {
+		data = append(data, '\n')
+	}
+	_, err = e.w.Write(data)
+	return err
+}
+
+// Close finishes the export; it must be called after the last contact
+func (e *Exporter) Close() error {
+	if err := e.start(); err != nil {
+		return err
+	}
+
+	switch e.format {
+	case ExportCSV:
+		e.csv.Flush()
+		return e.csv.Error()
+	case ExportJSON:
+		_, err := io.WriteString(e.w, "]\n")
+		return err
+	}
+	return nil
+}
+
+// start writes the CSV header or the opening bracket of a JSON array
+func (e *Exporter) start() error {
+	if e.started {
+		return nil
+	}
+	e.started = true
+
+	switch e.format {
+	case ExportCSV:
+		return e.csv.Write(e.fields)
+	case ExportJSON:
+		_, err := io.WriteString(e.w, "[")
+		return err
+	}
+	return nil
+}
+
+// object holds the selected fields of a contact in the selected order
+func (e *Exporter) object(contact Contact) json.Marshaler {
+	obj := make(orderedObject, 0, len(e.fields))
+	for _, f := range e.fields {
+		obj = append(obj, objectField{f, exportValue(contact, f)})
+	}
+	return obj
+}
+
+func exportValue(contact Contact, field string) interface{} {
+	switch field {
+	case "id":
+		return contact.ID
+	case "first_name":
+		return contact.FirstName
+	case "last_name":
+		return contact.LastName
+	case "phones":
+		return nonNil(contact.Phones)
+	case "emails":
+		return nonNil(contact.Emails)
+	case "addresses":
+		return nonNil(contact.Addresses)
+	case "websites":
+		return nonNil(contact.Websites)
+	case "note":
+		return contact.Note
+	case "created_at":
+		return contact.CreatedAt
+	}
+	return nil
+}
+
+// nonNil exports missing lists as empty arrays rather than null
+func nonNil[T any](list []T) []T {
+	if list == nil {
+		return []T{}
+	}
+	return list
+}
+
+// csvCell formats a field as one CSV cell. Lists are joined with "; " and
+// each item is written as label:value, the way pbclient reads phone numbers.
+func csvCell(contact Contact, field string) string {
+	var items []string
+	switch field {
+	case "id":
+		return strconv.Itoa(contact.ID)
+	case "first_name":
+		return contact.FirstName
+	case "last_name":
+		return contact.LastName
+	case "note":
+		return contact.Note
+	case "created_at":
+		return contact.CreatedAt.Format(time.RFC3339)
+	case "phones":
+		for _, p := range contact.Phones {
+			item := p.Label + ":" + p.Number
+			if p.Extension != "" {
+				item += "x" + p.Extension
+			}
+			items = append(items, item)
+		}
+	case "emails":
+		for _, e := range contact.Emails {
+			items = append(items, e.Label+":"+e.Address)
+		}
+	case "addresses":
+		for _, a := range contact.Addresses {
+			parts := []string{}
+			for _, part := range []string{a.Street, a.City, a.Region, a.PostalCode, a.Country} {
+				if part != "" {
+					parts = append(parts, part)
+				}
+			}
+			items = append(items, a.Label+":"+strings.Join(parts, ", "))
+		}
+	case "websites":
+		for _, w := range contact.Websites {
+			items = append(items, w.Label+":"+w.URL)
+		}
+	}
+	return strings.Join(items, "; ")
+}
+
+// orderedObject is a JSON object that keeps its keys in order
+type orderedObject []objectField
+
+type objectField struct {
+	key   string
+	value interface{}
+}
+
+func (o orderedObject) MarshalJSON() ([]byte, error) {
+	buf := []byte{'{'}
+	for i, f := range o {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		key, err := json.Marshal(f.key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, key...)
+		buf = append(buf, ':')
+		buf = append(buf, value...)
+	}
+	return append(buf, '}'), nil
+}
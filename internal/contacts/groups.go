@@ -0,0 +1,243 @@
+package contacts
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	// ErrGroupNotFound is returned when no group has the requested name
+	ErrGroupNotFound = errors.New("group not found")
+
+	// ErrGroupExists is returned when a group is created or renamed to a name in use
+	ErrGroupExists = errors.New("group already exists")
+)
+
+// Group is a saved search, such as all contacts with a Tehran number. Its
+// contacts are not stored but found by running Query, limited to the
+// contacts carrying Tag when it is set, every time the group is read. Names
+// are unique regardless of case.
+type Group struct {
+	Name      string      `json:"name"`
+	Query     SearchQuery `json:"query"`
+	Tag       string      `json:"tag,omitempty"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+}
+
+// Validate trims the name, query text and tag of the group and checks them.
+// A group needs a query text or a tag; the query itself is checked when it
+// is saved.
+func (g *Group) Validate() error {
+	g.Name = strings.TrimSpace(g.Name)
+	g.Query.Text = strings.TrimSpace(g.Query.Text)
+	g.Tag = strings.TrimSpace(g.Tag)
+
+	verr := &ValidationError{}
+	checkName(verr, "name", g.Name)
+	if g.Tag != "" {
+		checkName(verr, "tag", g.Tag)
+	}
+	if g.Query.Text == "" && g.Tag == "" {
+		verr.Add("query", "a query text or a tag is required")
+	}
+	return verr.Err()
+}
+
+// listQuery compiles the search of the group the way SearchContacts does
+func (g *Group) listQuery(region string) (listQuery, error) {
+	q := listQuery{filter: "TRUE"}
+	if g.Query.Text != "" {
+		var err error
+		if q, err = g.Query.compile(region); err != nil {
+			return listQuery{}, err
+		}
+	}
+	return q.withTag(g.Tag), nil
+}
+
+func (s *Service) Groups() ([]Group, error) {
+	return s.repo.Groups()
+}
+
+func (s *Service) GetGroup(name string) (*Group, error) {
+	return s.repo.GetGroup(strings.TrimSpace(name))
+}
+
+func (s *Service) CreateGroup(group *Group) error {
+	if err := group.Validate(); err != nil {
+		return err
+	}
+	return s.repo.CreateGroup(group)
+}
+
+// UpdateGroup replaces the search of the named group, renaming it to
+// group.Name; an empty group.Name keeps the current name
+func (s *Service) UpdateGroup(name string, group *Group) error {
+	name = strings.TrimSpace(name)
+	if strings.TrimSpace(group.Name) == "" {
+		group.Name = name
+	}
+	if err := group.Validate(); err != nil {
+		return err
+	}
+	return s.repo.UpdateGroup(name, group)
+}
+
+func (s *Service) DeleteGroup(name string) error {
+	return s.repo.DeleteGroup(strings.TrimSpace(name))
+}
+
+// GroupContacts returns one page of the contacts currently in the group,
+// limited further to opts.Tag when it is set
+func (s *Service) GroupContacts(name string, opts ListOptions) (*Page, error) {
+	return s.repo.GroupContacts(strings.TrimSpace(name), opts)
+}
+
+// EachGroupContact calls fn for every contact currently in the group, as
+// EachContact does for a search
+func (s *Service) EachGroupContact(name string, opts ListOptions, fn func(Contact) error) error {
+	return s.repo.EachGroupContact(strings.TrimSpace(name), opts, fn)
+}
+
+// Groups returns every group in alphabetical order
+func (r *Repository) Groups() ([]Group, error) {
+	rows, err := r.DB.Query(`SELECT name, query, tag, created_at, updated_at FROM contact_groups ORDER BY lower(name)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []Group{}
+	for rows.Next() {
+		group, err := scanGroup(rows)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, *group)
+	}
+	return groups, rows.Err()
+}
+
+// GetGroup returns the group with the given name, or ErrGroupNotFound
+func (r *Repository) GetGroup(name string) (*Group, error) {
+	row := r.DB.QueryRow(`SELECT name, query, tag, created_at, updated_at FROM contact_groups WHERE lower(name) = lower($1)`, name)
+	group, err := scanGroup(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrGroupNotFound
+	}
+	return group, err
+}
+
+// CreateGroup stores a validated group after checking its query, or returns
+// ErrGroupExists
+func (r *Repository) CreateGroup(group *Group) error {
+	query, err := r.groupQuery(group)
+	if err != nil {
+		return err
+	}
+
+	err = r.DB.QueryRow(`INSERT INTO contact_groups (name, query, tag) VALUES ($1, $2, $3) RETURNING created_at, updated_at`,
+		group.Name, query, group.Tag).Scan(&group.CreatedAt, &group.UpdatedAt)
+	return groupError(err)
+}
+
+// UpdateGroup replaces the named group with a validated group after checking
+// its query. It returns ErrGroupNotFound or ErrGroupExists.
+func (r *Repository) UpdateGroup(name string, group *Group) error {
+	query, err := r.groupQuery(group)
+	if err != nil {
+		return err
+	}
+
+	err = r.DB.QueryRow(`UPDATE contact_groups SET name = $2, query = $3, tag = $4, updated_at = now() WHERE lower(name) = lower($1) RETURNING created_at, updated_at`,
+		name, group.Name, query, group.Tag).Scan(&group.CreatedAt, &group.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrGroupNotFound
+	}
+	return groupError(err)
+}
+
+// DeleteGroup deletes the group with the given name; its contacts are left
+// as they are. It returns ErrGroupNotFound when no group has the name.
+func (r *Repository) DeleteGroup(name string) error {
+	result, err := r.DB.Exec(`DELETE FROM contact_groups WHERE lower(name) = lower($1)`, name)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrGroupNotFound
+	}
+	return nil
+}
+
+// GroupContacts runs the search of the named group for one page of contacts
+func (r *Repository) GroupContacts(name string, opts ListOptions) (*Page, error) {
+	group, err := r.GetGroup(name)
+	if err != nil {
+		return nil, err
+	}
+	q, err := group.listQuery(r.Region)
+	if err != nil {
+		return nil, err
+	}
+	return r.listContacts(q, opts)
+}
+
+// EachGroupContact runs the search of the named group and streams its
+// contacts to fn
+func (r *Repository) EachGroupContact(name string, opts ListOptions, fn func(Contact) error) error {
+	group, err := r.GetGroup(name)
+	if err != nil {
+		return err
+	}
+	q, err := group.listQuery(r.Region)
+	if err != nil {
+		return err
+	}
+	return r.eachContact(q, opts, fn)
+}
+
+// groupQuery checks that the search of the group compiles and returns it as
+// stored in the query column
+func (r *Repository) groupQuery(group *Group) ([]byte, error) {
+	if _, err := group.listQuery(r.Region); err != nil {
+		return nil, err
+	}
+	return json.Marshal(group.Query)
+}
+
+func scanGroup(row interface{ Scan(...interface{}) error }) (*Group, error) {
+	var group Group
+	var query []byte
+	if err := row.Scan(&group.Name, &query, &group.Tag, &group.CreatedAt, &group.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(query, &group.Query); err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+// groupError translates a failed contact_groups row write into a domain error
+func groupError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case pgUniqueViolation:
+			return ErrGroupExists
+		case pgStringDataRightTruncation:
+			return &ValidationError{Fields: []FieldError{{Field: "name", Message: "name is too long"}}}
+		}
+	}
+	return err
+}
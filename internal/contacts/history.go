@@ -0,0 +1,413 @@
+package contacts
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Revision actions
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionRestore = "restore"
+	ActionPurge   = "purge"
+	ActionMerge   = "merge"
+	ActionRevert  = "revert"
+)
+
+// AnonymousActor is recorded for changes made without a known actor
+const AnonymousActor = "anonymous"
+
+// ErrRevisionNotFound is returned when a contact has no revision with the requested number
+var ErrRevisionNotFound = errors.New("revision not found")
+
+// Revision is one recorded change of a contact. Before and After are the
+// whole contact around the change; Before is nil for a create and After is
+// nil once the contact no longer exists, after a purge or when it was
+// merged into another contact.
+type Revision struct {
+	ContactID int       `json:"contact_id"`
+	Revision  int       `json:"revision"`
+	Action    string    `json:"action"`
+	Actor     string    `json:"actor"`
+	Before    *Contact  `json:"before"`
+	After     *Contact  `json:"after"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// FieldChange is a field that differs between two versions of a contact.
+// For lists, Added and Removed hold the entries only one side has.
+type FieldChange struct {
+	Field   string            `json:"field"`
+	From    json.RawMessage   `json:"from"`
+	To      json.RawMessage   `json:"to"`
+	Added   []json.RawMessage `json:"added,omitempty"`
+	Removed []json.RawMessage `json:"removed,omitempty"`
+}
+
+// RevisionDiff lists the changes between the contact as it was after two revisions
+type RevisionDiff struct {
+	ContactID int           `json:"contact_id"`
+	From      int           `json:"from"`
+	To        int           `json:"to"`
+	Changes   []FieldChange `json:"changes"`
+}
+
+// diffFields are the fields compared by DiffContacts, in order
+var diffFields = []string{"first_name", "last_name", "phones", "emails", "addresses", "websites", "note", "deleted_at"}
+
+// DiffContacts returns the fields that differ between from and to. A nil
+// contact compares as an empty one.
+func DiffContacts(from, to *Contact) ([]FieldChange, error) {
+	if from == nil {
+		from = &Contact{}
+	}
+	if to == nil {
+		to = &Contact{}
+	}
+
+	changes := []FieldChange{}
+	for _, field := range diffFields {
+		a, err := json.Marshal(diffValue(*from, field))
+		if err != nil {
+			return nil, err
+		}
+		b, err := json.Marshal(diffValue(*to, field))
+		if err != nil {
+			return nil, err
+		}
+		if string(a) == string(b) {
+			continue
+		}
+
+		change := FieldChange{Field: field, From: a, To: b}
+		var itemsA, itemsB []json.RawMessage
+		if json.Unmarshal(a, &itemsA) == nil && json.Unmarshal(b, &itemsB) == nil {
+			change.Added = missingItems(itemsB, itemsA)
+			change.Removed = missingItems(itemsA, itemsB)
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+func diffValue(contact Contact, field string) interface{} {
+	if field == "deleted_at" {
+		return contact.DeletedAt
+	}
+	return exportValue(contact, field)
+}
+
+// missingItems returns the items of list that other does not have
+func missingItems(list, other []json.RawMessage) []json.RawMessage {
+	seen := make(map[string]bool, len(other))
+	for _, item := range other {
+		seen[string(item)] = true
+	}
+
+	var missing []json.RawMessage
+	for _, item := range list {
+		if !seen[string(item)] {
+			missing = append(missing, item)
+		}
+	}
+	return missing
+}
+
+// History returns every revision of a contact, oldest first. It returns
+// ErrNotFound when the contact has no recorded revisions.
+func (s *Service) History(id int) ([]Revision, error) {
+	return s.repo.History(id)
+}
+
+// DiffRevisions compares the contact as it was after revision from with how
+// it was after revision to
+func (s *Service) DiffRevisions(id, from, to int) (*RevisionDiff, error) {
+	a, err := s.repo.Revision(id, from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := s.repo.Revision(id, to)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := DiffContacts(a.After, b.After)
+	if err != nil {
+		return nil, err
+	}
+	return &RevisionDiff{ContactID: id, From: from, To: to, Changes: changes}, nil
+}
+
+// RevertContact restores the names and phone numbers of a contact to how they
+// were after the given revision and records that as a new revision. It
+// returns a *PhoneConflictError naming the contacts that hold any of the old
+// numbers now.
+func (s *Service) RevertContact(id, revision int) (*Contact, error) {
+	return s.repo.RevertContact(id, revision)
+}
+
+// History returns the revisions of a contact, oldest first. Revisions are
+// kept after the contact itself is purged.
+func (r *Repository) History(id int) ([]Revision, error) {
+	rows, err := r.DB.Query(`SELECT contact_id, revision, action, actor, before, after, created_at FROM contact_revisions WHERE contact_id = $1 ORDER BY revision`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []Revision{}
+	for rows.Next() {
+		revision, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, *revision)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(revisions) == 0 {
+		return nil, ErrNotFound
+	}
+	return revisions, nil
+}
+
+// Revision returns one revision of a contact, or ErrRevisionNotFound
+func (r *Repository) Revision(id, revision int) (*Revision, error) {
+	row := r.DB.QueryRow(`SELECT contact_id, revision, action, actor, before, after, created_at FROM contact_revisions WHERE contact_id = $1 AND revision = $2`, id, revision)
+	rev, err := scanRevision(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRevisionNotFound
+	}
+	return rev, err
+}
+
+// RevertContact restores the names and phone numbers of a live contact to its
+// snapshot after revision, leaving its other details as they are. Numbers of
+// the snapshot owned by other contacts are checked for before anything is
+// written.
+func (r *Repository) RevertContact(id, revision int) (*Contact, error) {
+	rev, err := r.Revision(id, revision)
+	if err != nil {
+		return nil, err
+	}
+	if rev.After == nil {
+		return nil, &ValidationError{Fields: []FieldError{{Field: "revision", Message: "the contact did not exist after revision " + strconv.Itoa(revision)}}}
+	}
+	phones := append([]Phone{}, rev.After.Phones...)
+	if err := r.preparePhones(phones); err != nil {
+		return nil, err
+	}
+
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	locked, err := lockContacts(tx, false, id)
+	if err != nil {
+		return nil, err
+	}
+	before := locked[id]
+
+	if err := phoneConflicts(tx, id, phones); err != nil {
+		return nil, err
+	}
+
+	after := before
+	after.FirstName, after.LastName, after.Phones = rev.After.FirstName, rev.After.LastName, phones
+	after.Version++
+	_, err = tx.Exec(`UPDATE contacts SET first_name = $1, last_name = $2, first_name_folded = $3, last_name_folded = $4, version = version + 1 WHERE id = $5`,
+		after.FirstName, after.LastName, FoldText(after.FirstName), FoldText(after.LastName), id)
+	if err != nil {
+		return nil, contactError(err)
+	}
+	if _, err := tx.Exec(`DELETE FROM phone_numbers WHERE contact_id = $1`, id); err != nil {
+		return nil, err
+	}
+	if err := r.insertPhones(tx, id, phones); err != nil {
+		return nil, err
+	}
+
+	if err := r.recordRevision(tx, ActionRevert, &before, &after); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &after, nil
+}
+
+// phoneConflicts returns a *PhoneConflictError when any of the prepared
+// phones belongs to a contact other than id. Live owners are reported in
+// full, those in the trash by ID only.
+func phoneConflicts(tx *sql.Tx, id int, phones []Phone) error {
+	e164s := make([]string, len(phones))
+	for i, p := range phones {
+		e164s[i] = p.E164
+	}
+	owners, err := phoneOwners(tx, e164s)
+	if err != nil {
+		return err
+	}
+
+	ownerIDs := []int{}
+	for _, p := range phones {
+		if owner, ok := owners[p.E164]; ok && owner != id {
+			ownerIDs = append(ownerIDs, owner)
+		}
+	}
+	if len(ownerIDs) == 0 {
+		return nil
+	}
+
+	live, err := collectContacts(tx, idQuery(ownerIDs))
+	if err != nil {
+		return err
+	}
+	byID := make(map[int]Contact, len(live))
+	for _, c := range live {
+		byID[c.ID] = c
+	}
+
+	conflict := &PhoneConflictError{}
+	for _, p := range phones {
+		owner, ok := owners[p.E164]
+		if !ok || owner == id {
+			continue
+		}
+		// Owners that are not live are in the trash
+		c, live := byID[owner]
+		if !live {
+			c = Contact{ID: owner}
+		}
+		conflict.Conflicts = append(conflict.Conflicts, DuplicatePhoneError{Number: p.Number, Owner: &c, Trashed: !live})
+	}
+	return conflict
+}
+
+func scanRevision(row interface{ Scan(...interface{}) error }) (*Revision, error) {
+	var rev Revision
+	var before, after []byte
+	if err := row.Scan(&rev.ContactID, &rev.Revision, &rev.Action, &rev.Actor, &before, &after, &rev.CreatedAt); err != nil {
+		return nil, err
+	}
+	for _, snapshot := range []struct {
+		data []byte
+		into **Contact
+	}{{before, &rev.Before}, {after, &rev.After}} {
+		if snapshot.data == nil {
+			continue
+		}
+		*snapshot.into = &Contact{}
+		if err := json.Unmarshal(snapshot.data, *snapshot.into); err != nil {
+			return nil, err
+		}
+	}
+	return &rev, nil
+}
+
+// recordRevision stores a revision of the contact inside the transaction that
+// changes it. The contact row is locked by then, so revision numbers of a
+// contact cannot race.
+func (r *Repository) recordRevision(tx *sql.Tx, action string, before, after *Contact) error {
+	id := 0
+	var snapshots [2]interface{}
+	for i, c := range []*Contact{before, after} {
+		if c == nil {
+			continue
+		}
+		id = c.ID
+		data, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		snapshots[i] = data
+	}
+
+	_, err := tx.Exec(`INSERT INTO contact_revisions (contact_id, revision, action, actor, before, after)
+        VALUES ($1, COALESCE((SELECT MAX(revision) FROM contact_revisions WHERE contact_id = $1), 0) + 1, $2, $3, $4, $5)`,
+		id, action, r.actor(), snapshots[0], snapshots[1])
+	return err
+}
+
+// purgeContacts permanently removes the contacts in the trash matching
+// condition and records a purge revision for each, whose before is the last
+// recorded state of the contact. It returns how many were removed.
+func (r *Repository) purgeContacts(condition string, args ...interface{}) (int64, error) {
+	args = append(args, r.actor())
+	result, err := r.DB.Exec(`
+        WITH purged AS (
+            DELETE FROM contacts WHERE deleted_at IS NOT NULL AND `+condition+` RETURNING id
+        )
+        INSERT INTO contact_revisions (contact_id, revision, action, actor, before)
+        SELECT p.id, COALESCE(last.revision, 0) + 1, 'purge', $`+strconv.Itoa(len(args))+`, last.after
+        FROM purged p
+        LEFT JOIN LATERAL (
+            SELECT revision, after FROM contact_revisions WHERE contact_id = p.id ORDER BY revision DESC LIMIT 1
+        ) last ON TRUE
+    `, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// lockContacts locks the contacts with the given IDs for the rest of tx and
+// returns them by ID. Only live contacts are locked, or only those in the
+// trash with trashed. It returns ErrNotFound when any of them is missing.
+func lockContacts(tx *sql.Tx, trashed bool, ids ...int) (map[int]Contact, error) {
+	q := idQuery(ids)
+	q.trashed = trashed
+	condition := "deleted_at IS NULL"
+	if trashed {
+		condition = "deleted_at IS NOT NULL"
+	}
+
+	rows, err := tx.Query(`SELECT id FROM contacts WHERE id = ANY($1::int[]) AND `+condition+` ORDER BY id FOR UPDATE`, q.args...)
+	if err != nil {
+		return nil, err
+	}
+	locked := 0
+	for rows.Next() {
+		locked++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if locked != len(ids) {
+		return nil, ErrNotFound
+	}
+
+	stored, err := collectContacts(tx, q)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int]Contact, len(stored))
+	for _, c := range stored {
+		byID[c.ID] = c
+	}
+	return byID, nil
+}
+
+// actor is who the changes made through the repository are recorded for
+func (r *Repository) actor() string {
+	if r.Actor == "" {
+		return AnonymousActor
+	}
+	return r.Actor
+}
+
+// WithActor returns a copy of the repository that records its changes for actor
+func (r *Repository) WithActor(actor string) IRepository {
+	copy := *r
+	copy.Actor = actor
+	return &copy
+}
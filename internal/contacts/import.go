@@ -0,0 +1,63 @@
+package contacts
+
+import "errors"
+
+// Outcomes of importing a single card
+const (
+	ImportCreated = "created"
+	ImportSkipped = "skipped"
+	ImportFailed  = "failed"
+)
+
+// ImportResult is the outcome of importing one card or CSV record. Card
+// counts from 1 in the order the records appear in the upload; Line is the
+// line a CSV record starts on.
+type ImportResult struct {
+	Card   int    `json:"card"`
+	Line   int    `json:"line,omitempty"`
+	Status string `json:"status"`
+	Name   string `json:"name,omitempty"`
+	ID     int    `json:"id,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// ImportReport sums up an import and lists the result of every record. In a
+// dry run nothing is stored and created means the record would be created.
+type ImportReport struct {
+	DryRun  bool           `json:"dry_run,omitempty"`
+	Created int            `json:"created"`
+	Skipped int            `json:"skipped"`
+	Failed  int            `json:"failed"`
+	Results []ImportResult `json:"results"`
+}
+
+// add records the result of the next card
+func (r *ImportReport) add(result ImportResult) {
+	result.Card = len(r.Results) + 1
+	switch result.Status {
+	case ImportCreated:
+		r.Created++
+	case ImportSkipped:
+		r.Skipped++
+	case ImportFailed:
+		r.Failed++
+	}
+	r.Results = append(r.Results, result)
+}
+
+// importContact creates one imported contact. A contact whose phone number
+// already belongs to someone is skipped as a duplicate rather than failed.
+func (s *Service) importContact(contact *Contact) ImportResult {
+	result := ImportResult{Name: contact.FirstName + " " + contact.LastName}
+
+	id, err := s.CreateContact(contact)
+	switch {
+	case err == nil:
+		result.Status, result.ID = ImportCreated, id
+	case errors.Is(err, ErrDuplicatePhone):
+		result.Status, result.Reason = ImportSkipped, err.Error()
+	default:
+		result.Status, result.Reason = ImportFailed, err.Error()
+	}
+	return result
+}
@@ -0,0 +1,182 @@
+package contacts
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// How a looked up number matched a stored one, from most to least certain
+const (
+	// MatchExact is the same number in E.164 form
+	MatchExact = "exact"
+	// MatchNational is the same national significant number under another
+	// country code, such as a number stored with the wrong region
+	MatchNational = "national"
+	// MatchSuffix is the same last digits, such as a number the caller's
+	// network sent with an unusual prefix
+	MatchSuffix = "suffix"
+)
+
+// LookupResult is the contact a phone number belongs to, with the number
+// that matched as stored and how it matched
+type LookupResult struct {
+	Contact Contact `json:"contact"`
+	Number  string  `json:"number"`
+	Label   string  `json:"label"`
+	Match   string  `json:"match"`
+}
+
+// Lookup finds who a phone number belongs to, such as an incoming caller ID.
+// It returns ErrNotFound when no live contact has a matching number.
+func (s *Service) Lookup(number string) (*LookupResult, error) {
+	if strings.TrimSpace(number) == "" {
+		return nil, &ValidationError{Fields: []FieldError{{Field: "number", Message: "is required"}}}
+	}
+	return s.repo.Lookup(number)
+}
+
+// callerKeys are the forms of a looked up number compared with stored ones;
+// a key is empty when the number has no such form
+type callerKeys struct {
+	e164   string
+	nsn    string
+	suffix string
+}
+
+// lookupKeys normalizes a caller ID. Numbers sent without the "+" of their
+// international form, as PBXs often do, are tried in that form too.
+func lookupKeys(number, regionCode string) (callerKeys, error) {
+	digits, international, err := phoneDigits(number)
+	if err != nil {
+		return callerKeys{}, err
+	}
+
+	var keys callerKeys
+	if e164, err := NormalizePhone(number, regionCode); err == nil {
+		keys.e164 = e164
+	} else if !international {
+		if e164, err := NormalizePhone("+"+digits, regionCode); err == nil {
+			keys.e164 = e164
+		}
+	}
+
+	switch {
+	case keys.e164 != "":
+		digits = strings.TrimPrefix(keys.e164, "+")
+		for n := 1; n <= 3; n++ {
+			if _, ok := callingCodes[digits[:n]]; ok {
+				keys.nsn = digits[n:]
+				break
+			}
+		}
+	case !international:
+		if reg, ok := regions[strings.ToUpper(regionCode)]; ok {
+			keys.nsn = strings.TrimPrefix(digits, reg.trunk)
+		}
+	}
+
+	// The suffix index only narrows by the last phoneSuffixLength digits, so
+	// shorter numbers and national numbers can only match exactly
+	if len(digits) >= phoneSuffixLength {
+		keys.suffix = digits[len(digits)-phoneSuffixLength:]
+	}
+	if len(keys.nsn) < phoneSuffixLength {
+		keys.nsn = ""
+	}
+	return keys, nil
+}
+
+// Lookup matches a phone number against the stored numbers of live contacts:
+// exactly by its E.164 form, else by its national significant number under
+// any country code, else by its last digits. The exact match uses the unique
+// e164 index and the fallbacks the index on the last digits of e164, each in
+// a query of its own. Ties go to primary numbers, but a fallback that
+// matches several contacts equally well returns an *AmbiguousMatchError.
+func (r *Repository) Lookup(number string) (*LookupResult, error) {
+	keys, err := lookupKeys(number, r.Region)
+	if err != nil {
+		return nil, &ValidationError{Fields: []FieldError{{Field: "number", Message: "is not a phone number"}}}
+	}
+
+	result := LookupResult{Match: MatchExact}
+	var contactID int
+	err = sql.ErrNoRows
+	if keys.e164 != "" {
+		err = r.DB.QueryRow(`
+            SELECT p.contact_id, p.number, p.label
+            FROM phone_numbers p
+            JOIN contacts c ON c.id = p.contact_id AND c.deleted_at IS NULL
+            WHERE p.e164 = $1`,
+			keys.e164).Scan(&contactID, &result.Number, &result.Label)
+	}
+	if errors.Is(err, sql.ErrNoRows) && keys.suffix != "" {
+		contactID, err = r.lookupFallback(keys, &result)
+	}
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	contact, err := r.GetContact(contactID)
+	if err != nil {
+		return nil, err
+	}
+	result.Contact = *contact
+	return &result, nil
+}
+
+// lookupFallback matches numbers by their last digits, preferring those with
+// the same national significant number. It fills in the number, label and
+// kind of match of result and returns the contact it belongs to.
+func (r *Repository) lookupFallback(keys callerKeys, result *LookupResult) (int, error) {
+	// Country codes are one to three digits long, after the "+"
+	rows, err := r.DB.Query(fmt.Sprintf(`
+        SELECT p.contact_id, p.number, p.label,
+            $2 <> '' AND p.e164 LIKE '+%%' || $2 AND length(p.e164) - length($2) BETWEEN 2 AND 4 AS national
+        FROM phone_numbers p
+        JOIN contacts c ON c.id = p.contact_id AND c.deleted_at IS NULL
+        WHERE right(p.e164, %d) = $1
+        ORDER BY national DESC, p.is_primary DESC, p.contact_id`, phoneSuffixLength),
+		keys.suffix, keys.nsn)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var contactIDs []int
+	seen := make(map[int]bool)
+	bestNational := false
+	for rows.Next() {
+		var contactID int
+		var number, label string
+		var national bool
+		if err := rows.Scan(&contactID, &number, &label, &national); err != nil {
+			return 0, err
+		}
+		if len(contactIDs) == 0 {
+			result.Number, result.Label, bestNational = number, label, national
+		}
+		if national == bestNational && !seen[contactID] {
+			seen[contactID] = true
+			contactIDs = append(contactIDs, contactID)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	result.Match = MatchSuffix
+	if bestNational {
+		result.Match = MatchNational
+	}
+	switch len(contactIDs) {
+	case 0:
+		return 0, sql.ErrNoRows
+	case 1:
+		return contactIDs[0], nil
+	}
+	sort.Ints(contactIDs)
+	return 0, &AmbiguousMatchError{Match: result.Match, ContactIDs: contactIDs}
+}
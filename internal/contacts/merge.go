@@ -0,0 +1,264 @@
+package contacts
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Merge rules. Name and note fields take MergeKeepSurvivor or
+// MergeKeepLongest, list fields take MergeKeepSurvivor or MergeUnion.
+const (
+	MergeKeepSurvivor = "keep_survivor"
+	MergeKeepLongest  = "keep_longest"
+	MergeUnion        = "union"
+)
+
+// mergeDefaults is the rule of every field a merge request leaves out
+var mergeDefaults = map[string]string{
+	"first_name": MergeKeepSurvivor,
+	"last_name":  MergeKeepSurvivor,
+	"note":       MergeKeepSurvivor,
+	"phones":     MergeUnion,
+	"emails":     MergeUnion,
+	"addresses":  MergeUnion,
+	"websites":   MergeUnion,
+	"tags":       MergeUnion,
+}
+
+// MergeRequest combines the contacts in ContactIDs into the survivor, which
+// is kept while the others are deleted. Rules maps a field to how it is
+// resolved; fields without a rule use mergeDefaults.
+type MergeRequest struct {
+	SurvivorID int               `json:"survivor_id"`
+	ContactIDs []int             `json:"contact_ids"`
+	Rules      map[string]string `json:"rules,omitempty"`
+}
+
+// MergeResult is the surviving contact of a merge and its audit record
+type MergeResult struct {
+	MergeID   int       `json:"merge_id"`
+	Contact   Contact   `json:"contact"`
+	MergedIDs []int     `json:"merged_ids"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Validate checks the request and fills in the default rules. The survivor
+// may be left out of ContactIDs; repeated IDs are dropped.
+func (m *MergeRequest) Validate() error {
+	verr := &ValidationError{}
+	if m.SurvivorID <= 0 {
+		verr.Add("survivor_id", "is required")
+	}
+
+	ids := []int{}
+	seen := map[int]bool{m.SurvivorID: true}
+	for _, id := range m.ContactIDs {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	m.ContactIDs = ids
+	if len(ids) == 0 {
+		verr.Add("contact_ids", "must name a contact besides the survivor")
+	}
+
+	rules := make(map[string]string, len(mergeDefaults))
+	for field, rule := range mergeDefaults {
+		rules[field] = rule
+	}
+	for field, rule := range m.Rules {
+		def, ok := mergeDefaults[field]
+		if !ok {
+			verr.Add("rules", "unknown field "+field)
+			continue
+		}
+		if def == MergeUnion && rule != MergeKeepSurvivor && rule != MergeUnion {
+			verr.Add("rules", field+" must be keep_survivor or union")
+			continue
+		}
+		if def != MergeUnion && rule != MergeKeepSurvivor && rule != MergeKeepLongest {
+			verr.Add("rules", field+" must be keep_survivor or keep_longest")
+			continue
+		}
+		rules[field] = rule
+	}
+	m.Rules = rules
+	return verr.Err()
+}
+
+// mergeContacts combines survivor with others according to rules. Lists are
+// joined in order, survivor first, leaving out entries already present; only
+// the survivor's primary phone stays primary.
+func mergeContacts(survivor Contact, others []Contact, rules map[string]string) Contact {
+	merged := survivor
+	merged.Phones = append([]Phone{}, survivor.Phones...)
+	all := append([]Contact{survivor}, others...)
+
+	longest := func(field func(Contact) string) string {
+		value := field(survivor)
+		for _, c := range others {
+			if utf8.RuneCountInString(field(c)) > utf8.RuneCountInString(value) {
+				value = field(c)
+			}
+		}
+		return value
+	}
+	if rules["first_name"] == MergeKeepLongest {
+		merged.FirstName = longest(func(c Contact) string { return c.FirstName })
+	}
+	if rules["last_name"] == MergeKeepLongest {
+		merged.LastName = longest(func(c Contact) string { return c.LastName })
+	}
+	if rules["note"] == MergeKeepLongest {
+		merged.Note = longest(func(c Contact) string { return c.Note })
+	}
+
+	if rules["phones"] == MergeUnion {
+		merged.Phones = union(all, func(c Contact) []Phone { return c.Phones }, phoneKey)
+		for i := len(survivor.Phones); i < len(merged.Phones); i++ {
+			merged.Phones[i].Primary = false
+		}
+	}
+	if rules["emails"] == MergeUnion {
+		merged.Emails = union(all, func(c Contact) []Email { return c.Emails }, func(e Email) string {
+			return strings.ToLower(e.Address)
+		})
+	}
+	if rules["addresses"] == MergeUnion {
+		merged.Addresses = union(all, func(c Contact) []Address { return c.Addresses }, func(a Address) string {
+			return FoldText(strings.Join([]string{a.Street, a.City, a.Region, a.PostalCode, a.Country}, "\x00"))
+		})
+	}
+	if rules["websites"] == MergeUnion {
+		merged.Websites = union(all, func(c Contact) []Website { return c.Websites }, func(w Website) string {
+			return strings.TrimSuffix(w.URL, "/")
+		})
+	}
+	if rules["tags"] == MergeUnion {
+		merged.Tags = union(all, func(c Contact) []string { return c.Tags }, strings.ToLower)
+		sort.Slice(merged.Tags, func(i, j int) bool { return strings.ToLower(merged.Tags[i]) < strings.ToLower(merged.Tags[j]) })
+	}
+	return merged
+}
+
+// union joins the lists of the contacts, keeping the first entry of each key
+func union[T any](contacts []Contact, list func(Contact) []T, key func(T) string) []T {
+	result := []T{}
+	seen := map[string]bool{}
+	for _, c := range contacts {
+		for _, item := range list(c) {
+			if k := key(item); !seen[k] {
+				seen[k] = true
+				result = append(result, item)
+			}
+		}
+	}
+	return result
+}
+
+// MergeContacts combines the contacts of req into its survivor, deleting the
+// others, and records the merge. It returns ErrNotFound when any of the
+// contacts does not exist.
+func (s *Service) MergeContacts(req MergeRequest) (*MergeResult, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	return s.repo.MergeContacts(req)
+}
+
+// MergeContacts merges the contacts of a validated request in one
+// transaction: the survivor takes the merged fields, phone numbers, details
+// and tags, the other contacts are deleted, and the contacts as they were
+// before are stored in contact_merges. Each contact gets a merge revision;
+// those merged away have no after.
+func (r *Repository) MergeContacts(req MergeRequest) (*MergeResult, error) {
+	ids := append([]int{req.SurvivorID}, req.ContactIDs...)
+
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock the contacts so none of them changes while it is merged
+	byID, err := lockContacts(tx, false, ids...)
+	if err != nil {
+		return nil, err
+	}
+	others := make([]Contact, 0, len(req.ContactIDs))
+	for _, id := range req.ContactIDs {
+		others = append(others, byID[id])
+	}
+
+	merged := mergeContacts(byID[req.SurvivorID], others, req.Rules)
+	if err := r.preparePhones(merged.Phones); err != nil {
+		return nil, err
+	}
+	prepareDetails(&merged)
+
+	// The survivor takes the tags of the others before they are gone
+	if req.Rules["tags"] == MergeUnion {
+		_, err = tx.Exec(`INSERT INTO contact_tags (contact_id, tag_id) SELECT DISTINCT $1::int, tag_id FROM contact_tags WHERE contact_id = ANY($2::int[]) ON CONFLICT DO NOTHING`,
+			req.SurvivorID, intArray(req.ContactIDs))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The others go first so their phone numbers are free to move
+	if _, err := tx.Exec(`DELETE FROM contacts WHERE id = ANY($1::int[])`, intArray(req.ContactIDs)); err != nil {
+		return nil, err
+	}
+	merged.Version++
+	_, err = tx.Exec(`UPDATE contacts SET first_name = $1, last_name = $2, first_name_folded = $3, last_name_folded = $4, note = $5, version = version + 1 WHERE id = $6`,
+		merged.FirstName, merged.LastName, FoldText(merged.FirstName), FoldText(merged.LastName), merged.Note, merged.ID)
+	if err != nil {
+		return nil, contactError(err)
+	}
+	if _, err := tx.Exec(`DELETE FROM phone_numbers WHERE contact_id = $1`, merged.ID); err != nil {
+		return nil, err
+	}
+	if err := r.insertPhones(tx, merged.ID, merged.Phones); err != nil {
+		return nil, err
+	}
+	if err := deleteDetails(tx, merged.ID); err != nil {
+		return nil, err
+	}
+	if err := insertDetails(tx, merged.ID, &merged); err != nil {
+		return nil, err
+	}
+
+	survivor := byID[req.SurvivorID]
+	if err := r.recordRevision(tx, ActionMerge, &survivor, &merged); err != nil {
+		return nil, err
+	}
+	for i := range others {
+		if err := r.recordRevision(tx, ActionMerge, &others[i], nil); err != nil {
+			return nil, err
+		}
+	}
+
+	rules, err := json.Marshal(req.Rules)
+	if err != nil {
+		return nil, err
+	}
+	before, err := json.Marshal(append([]Contact{byID[req.SurvivorID]}, others...))
+	if err != nil {
+		return nil, err
+	}
+	result := &MergeResult{Contact: merged, MergedIDs: req.ContactIDs}
+	err = tx.QueryRow(`INSERT INTO contact_merges (survivor_id, merged_ids, rules, contacts) VALUES ($1, $2::int[], $3, $4) RETURNING id, created_at`,
+		merged.ID, intArray(req.ContactIDs), rules, before).Scan(&result.MergeID, &result.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
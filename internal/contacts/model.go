@@ -1,8 +1,289 @@
 package contacts
 
+import (
+	"encoding/json"
+	"errors"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Column sizes of the contacts and phone_numbers tables
+const (
+	maxNameLength      = 50
+	maxNumberLength    = 32
+	maxLabelLength     = 32
+	maxExtensionLength = 10
+	maxEmailLength     = 254
+	maxStreetLength    = 200
+	maxCityLength      = 100
+	maxPostalLength    = 20
+	maxURLLength       = 2048
+	maxNoteLength      = 10000
+)
+
+// Predefined phone labels; any other non-empty label is kept as a custom label
+const (
+	LabelMobile = "mobile"
+	LabelHome   = "home"
+	LabelWork   = "work"
+	LabelFax    = "fax"
+	LabelOther  = "other"
+)
+
+// PhoneLabels lists the predefined phone labels
+var PhoneLabels = []string{LabelMobile, LabelHome, LabelWork, LabelFax, LabelOther}
+
+// Phone is one of a contact's phone numbers. Number is kept as typed for
+// display; E164 is its canonical form and is filled in by the repository.
+type Phone struct {
+	Number    string `json:"number"`
+	E164      string `json:"e164,omitempty"`
+	Label     string `json:"label"`
+	Primary   bool   `json:"primary"`
+	Extension string `json:"extension,omitempty"`
+}
+
+// UnmarshalJSON accepts a bare number string as well as the object form, so
+// requests from clients that send phone numbers as strings keep working
+func (p *Phone) UnmarshalJSON(data []byte) error {
+	var number string
+	if err := json.Unmarshal(data, &number); err == nil {
+		*p = Phone{Number: number}
+		return nil
+	}
+
+	type phone Phone
+	return json.Unmarshal(data, (*phone)(p))
+}
+
+// Email is one of a contact's email addresses
+type Email struct {
+	Address string `json:"address"`
+	Label   string `json:"label"`
+}
+
+// Address is one of a contact's postal addresses
+type Address struct {
+	Label      string `json:"label"`
+	Street     string `json:"street"`
+	City       string `json:"city"`
+	Region     string `json:"region"`
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country"`
+}
+
+// Website is one of a contact's URLs
+type Website struct {
+	URL   string `json:"url"`
+	Label string `json:"label"`
+}
+
 type Contact struct {
-	ID           int      `json:"id"`
-	FirstName    string   `json:"first_name"`
-	LastName     string   `json:"last_name"`
-	PhoneNumbers []string `json:"phone_numbers"`
+	ID        int       `json:"id"`
+	FirstName string    `json:"first_name"`
+	LastName  string    `json:"last_name"`
+	Phones    []Phone   `json:"phones"`
+	Emails    []Email   `json:"emails"`
+	Addresses []Address `json:"addresses"`
+	Websites  []Website `json:"websites"`
+	Note      string    `json:"note"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// Tags are the names of the tags the contact carries, in alphabetical
+	// order. They are read-only here and changed through the tag endpoints.
+	Tags []string `json:"tags"`
+
+	// Version is incremented on every change of the contact. An update that
+	// names the version it was made from fails when the contact has moved on.
+	Version int `json:"version"`
+
+	// DeletedAt is when the contact was moved to the trash; it is only set
+	// for trashed contacts
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+
+	// Score is the relevance of the contact to a fuzzy search
+	Score float64 `json:"score,omitempty"`
+}
+
+// Numbers returns the phone numbers of the contact as typed
+func (c *Contact) Numbers() []string {
+	numbers := make([]string, len(c.Phones))
+	for i, p := range c.Phones {
+		numbers[i] = p.Number
+	}
+	return numbers
+}
+
+// MarshalJSON adds the legacy phone_numbers string array next to phones so
+// clients that predate labeled phone numbers can still decode contacts
+func (c Contact) MarshalJSON() ([]byte, error) {
+	type contact Contact
+	return json.Marshal(struct {
+		contact
+		PhoneNumbers []string `json:"phone_numbers"`
+	}{contact(c), c.Numbers()})
+}
+
+// UnmarshalJSON reads phones, falling back to the legacy phone_numbers field
+// which may hold strings or phone objects
+func (c *Contact) UnmarshalJSON(data []byte) error {
+	type contact Contact
+	aux := struct {
+		*contact
+		PhoneNumbers []Phone `json:"phone_numbers"`
+	}{contact: (*contact)(c)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if c.Phones == nil {
+		c.Phones = aux.PhoneNumbers
+	}
+	return nil
+}
+
+// Validate checks the contact against the constraints of the database schema
+func (c *Contact) Validate() error {
+	verr := &ValidationError{}
+
+	if c.FirstName == "" && c.LastName == "" {
+		verr.Add("first_name", "first name or last name is required")
+	}
+	if utf8.RuneCountInString(c.FirstName) > maxNameLength {
+		verr.Add("first_name", "must be at most 50 characters")
+	}
+	if utf8.RuneCountInString(c.LastName) > maxNameLength {
+		verr.Add("last_name", "must be at most 50 characters")
+	}
+	if utf8.RuneCountInString(c.Note) > maxNoteLength {
+		verr.Add("note", "must be at most 10000 characters")
+	}
+
+	seen := make(map[string]bool, len(c.Phones))
+	primaries := 0
+	for _, p := range c.Phones {
+		switch {
+		case p.Number == "":
+			verr.Add("phones", "phone number must not be empty")
+		case utf8.RuneCountInString(p.Number) > maxNumberLength:
+			verr.Add("phones", "phone number "+p.Number+" must be at most 32 characters")
+		case seen[p.Number]:
+			verr.Add("phones", "phone number "+p.Number+" is listed more than once")
+		}
+		seen[p.Number] = true
+
+		if utf8.RuneCountInString(p.Label) > maxLabelLength {
+			verr.Add("phones", "label "+p.Label+" must be at most 32 characters")
+		}
+		if err := validateExtension(p.Extension); err != nil {
+			verr.Add("phones", err.Error())
+		}
+		if p.Primary {
+			primaries++
+		}
+	}
+	if primaries > 1 {
+		verr.Add("phones", "only one phone number can be primary")
+	}
+
+	for _, e := range c.Emails {
+		if err := validateEmail(e.Address); err != nil {
+			verr.Add("emails", err.Error())
+		}
+		if utf8.RuneCountInString(e.Label) > maxLabelLength {
+			verr.Add("emails", "label "+e.Label+" must be at most 32 characters")
+		}
+	}
+
+	for _, a := range c.Addresses {
+		switch {
+		case a.Street == "" && a.City == "" && a.Region == "" && a.PostalCode == "" && a.Country == "":
+			verr.Add("addresses", "address must not be empty")
+		case utf8.RuneCountInString(a.Street) > maxStreetLength:
+			verr.Add("addresses", "street must be at most 200 characters")
+		case utf8.RuneCountInString(a.City) > maxCityLength,
+			utf8.RuneCountInString(a.Region) > maxCityLength,
+			utf8.RuneCountInString(a.Country) > maxCityLength:
+			verr.Add("addresses", "city, region and country must be at most 100 characters")
+		case utf8.RuneCountInString(a.PostalCode) > maxPostalLength:
+			verr.Add("addresses", "postal code must be at most 20 characters")
+		}
+		if utf8.RuneCountInString(a.Label) > maxLabelLength {
+			verr.Add("addresses", "label "+a.Label+" must be at most 32 characters")
+		}
+	}
+
+	for _, w := range c.Websites {
+		if err := validateURL(w.URL); err != nil {
+			verr.Add("websites", err.Error())
+		}
+		if utf8.RuneCountInString(w.Label) > maxLabelLength {
+			verr.Add("websites", "label "+w.Label+" must be at most 32 characters")
+		}
+	}
+
+	return verr.Err()
+}
+
+func validateEmail(address string) error {
+	if len(address) > maxEmailLength {
+		return errors.New("email " + address + " must be at most 254 characters")
+	}
+	parsed, err := mail.ParseAddress(address)
+	if err != nil || parsed.Address != address {
+		return errors.New("email " + address + " is not a valid address")
+	}
+	return nil
+}
+
+// validateURL accepts absolute http(s) URLs and bare hosts such as
+// "example.com", which are stored with an https scheme
+func validateURL(raw string) error {
+	if len(raw) > maxURLLength {
+		return errors.New("url must be at most 2048 characters")
+	}
+	u, err := url.Parse(websiteURL(raw))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url " + raw + " is not a valid http or https URL")
+	}
+	return nil
+}
+
+// websiteURL adds an https scheme to URLs typed without one
+func websiteURL(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if raw != "" && !strings.Contains(raw, "://") {
+		return "https://" + raw
+	}
+	return raw
+}
+
+func validateExtension(ext string) error {
+	if len(ext) > maxExtensionLength {
+		return errors.New("extension " + ext + " must be at most 10 digits")
+	}
+	for _, r := range ext {
+		if r < '0' || r > '9' {
+			return errors.New("extension " + ext + " must only contain digits")
+		}
+	}
+	return nil
+}
+
+// normalizeLabel trims the label, maps predefined labels to their canonical
+// spelling and defaults an empty label to fallback
+func normalizeLabel(label, fallback string) string {
+	label = strings.TrimSpace(label)
+	if label == "" {
+		return fallback
+	}
+	for _, predefined := range PhoneLabels {
+		if strings.EqualFold(label, predefined) {
+			return predefined
+		}
+	}
+	return label
 }
@@ -0,0 +1,142 @@
+package contacts
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Page size limits for listing and searching contacts
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// Sort fields accepted by ListOptions
+const (
+	SortFirstName = "first_name"
+	SortLastName  = "last_name"
+	SortCreatedAt = "created_at"
+	// SortRelevance orders ranked searches by score and is their default
+	SortRelevance = "relevance"
+)
+
+// sortColumns maps each sort field to the SQL expression used for the keyset
+// and to the placeholder cast applied to the cursor value.
+var sortColumns = map[string]struct{ column, cast string }{
+	SortFirstName: {"c.first_name", ""},
+	SortLastName:  {"c.last_name", ""},
+	SortCreatedAt: {"c.created_at", "::timestamptz"},
+	SortRelevance: {"c.score", "::float8"},
+}
+
+// ListOptions controls the ordering and pagination of contact listings.
+// Tag limits a listing to the contacts carrying the named tag.
+type ListOptions struct {
+	Limit  int
+	Cursor string
+	Sort   string
+	Desc   bool
+	Tag    string
+}
+
+// Page is one page of contacts; NextCursor is empty on the last page
+type Page struct {
+	Contacts   []Contact `json:"contacts"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+// cursor is the decoded form of the opaque next_cursor token. It remembers the
+// sort it was issued for so it cannot be replayed against a different ordering.
+type cursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d,omitempty"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+// normalize applies defaults and rejects unknown sorts, limits and cursors.
+// Ranked listings default to the most relevant contacts first.
+func (o *ListOptions) normalize(ranked bool) (*cursor, error) {
+	verr := &ValidationError{}
+
+	if o.Sort == "" && ranked {
+		o.Sort, o.Desc = SortRelevance, true
+	}
+	if o.Sort == "" {
+		o.Sort = SortFirstName
+	}
+	if _, ok := sortColumns[o.Sort]; !ok || (o.Sort == SortRelevance && !ranked) {
+		verr.Add("sort", "must be one of first_name, last_name, created_at, or relevance for ranked searches")
+	}
+
+	switch {
+	case o.Limit == 0:
+		o.Limit = DefaultPageSize
+	case o.Limit < 0 || o.Limit > MaxPageSize:
+		verr.Add("limit", fmt.Sprintf("must be between 1 and %d", MaxPageSize))
+	}
+
+	var cur *cursor
+	if o.Cursor != "" {
+		var err error
+		cur, err = decodeCursor(o.Cursor)
+		if err != nil || cur.Sort != o.Sort || cur.Desc != o.Desc {
+			verr.Add("cursor", "is invalid for this listing")
+		}
+	}
+
+	return cur, verr.Err()
+}
+
+// keyset returns the WHERE fragment that resumes after cur and the matching
+// ORDER BY clause. Placeholders are numbered from len(args)+1.
+func (o *ListOptions) keyset(cur *cursor, args []interface{}) (string, string, []interface{}) {
+	col := sortColumns[o.Sort]
+	dir, cmp := "ASC", ">"
+	if o.Desc {
+		dir, cmp = "DESC", "<"
+	}
+
+	order := fmt.Sprintf("%s %s, c.id %s", col.column, dir, dir)
+	if cur == nil {
+		return "TRUE", order, args
+	}
+
+	args = append(args, cur.Value, cur.ID)
+	where := fmt.Sprintf("(%s, c.id) %s ($%d%s, $%d)", col.column, cmp, len(args)-1, col.cast, len(args))
+	return where, order, args
+}
+
+// nextCursor builds the cursor that resumes after the given contact
+func (o *ListOptions) nextCursor(last Contact) string {
+	cur := cursor{Sort: o.Sort, Desc: o.Desc, ID: last.ID}
+	switch o.Sort {
+	case SortFirstName:
+		cur.Value = last.FirstName
+	case SortLastName:
+		cur.Value = last.LastName
+	case SortCreatedAt:
+		cur.Value = last.CreatedAt.Format(time.RFC3339Nano)
+	case SortRelevance:
+		cur.Value = strconv.FormatFloat(last.Score, 'g', -1, 64)
+	}
+
+	data, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(token string) (*cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+
+	var cur cursor
+	if err := json.Unmarshal(data, &cur); err != nil {
+		return nil, err
+	}
+	return &cur, nil
+}
@@ -0,0 +1,651 @@
+package contacts
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Media types of the patch formats PATCH /contacts/:id accepts
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+)
+
+// Patch changes the JSON document of a contact
+type Patch interface {
+	// Apply returns the document with the patch applied; doc may be changed in place
+	Apply(doc interface{}) (interface{}, error)
+
+	// ContentType is the media type the patch is sent as
+	ContentType() string
+}
+
+// MergePatch is a JSON Merge Patch (RFC 7396). Members set to null are
+// removed from the contact, objects are merged and everything else, lists
+// included, replaces the stored value.
+type MergePatch map[string]interface{}
+
+// Apply merges the patch into doc
+func (p MergePatch) Apply(doc interface{}) (interface{}, error) {
+	return mergePatch(doc, map[string]interface{}(p)), nil
+}
+
+// ContentType is MergePatchType
+func (p MergePatch) ContentType() string {
+	return MergePatchType
+}
+
+func mergePatch(target, patch interface{}) interface{} {
+	members, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	doc, ok := target.(map[string]interface{})
+	if !ok {
+		doc = map[string]interface{}{}
+	}
+	for name, value := range members {
+		if value == nil {
+			delete(doc, name)
+			continue
+		}
+		doc[name] = mergePatch(doc[name], value)
+	}
+	return doc
+}
+
+// PatchOperation is one operation of a JSON Patch. Value is left nil when
+// the operation has no value member.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// JSONPatch is a JSON Patch (RFC 6902), a list of operations applied in
+// order. It fails as a whole when any operation fails.
+type JSONPatch []PatchOperation
+
+// ContentType is JSONPatchType
+func (p JSONPatch) ContentType() string {
+	return JSONPatchType
+}
+
+// Apply runs the operations against doc
+func (p JSONPatch) Apply(doc interface{}) (interface{}, error) {
+	for i, op := range p {
+		var err error
+		if doc, err = op.apply(doc); err != nil {
+			return nil, &ValidationError{Fields: []FieldError{{Field: "patch", Message: fmt.Sprintf("operation %d (%s %s): %v", i, op.Op, op.Path, err)}}}
+		}
+	}
+	return doc, nil
+}
+
+func (op PatchOperation) apply(doc interface{}) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, fmt.Errorf("value is required")
+		}
+		var value interface{}
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return nil, err
+		}
+		switch op.Op {
+		case "add":
+			return addValue(doc, path, value)
+		case "replace":
+			if len(path) == 0 {
+				return value, nil
+			}
+			if doc, err = removeValue(doc, path); err != nil {
+				return nil, err
+			}
+			return addValue(doc, path, value)
+		}
+		current, err := getValue(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(current, value) {
+			return nil, fmt.Errorf("value does not match")
+		}
+		return doc, nil
+	case "remove":
+		return removeValue(doc, path)
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		value, err := getValue(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "copy" {
+			// Copy through JSON so the copy shares nothing with the original
+			data, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			var copied interface{}
+			if err := json.Unmarshal(data, &copied); err != nil {
+				return nil, err
+			}
+			return addValue(doc, path, copied)
+		}
+		if len(path) > len(from) && reflect.DeepEqual(path[:len(from)], from) {
+			return nil, fmt.Errorf("cannot move a value into itself")
+		}
+		if doc, err = removeValue(doc, from); err != nil {
+			return nil, err
+		}
+		return addValue(doc, path, value)
+	}
+	return nil, fmt.Errorf("unknown operation %q", op.Op)
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into its unescaped tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("path %q must start with /", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// arrayIndex parses the token of an array element. With end, "-" and the
+// length of the array, the position after the last element, are accepted.
+func arrayIndex(token string, length int, end bool) (int, error) {
+	if end && token == "-" {
+		return length, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (token != "0" && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	if i > length || (i == length && !end) {
+		return 0, fmt.Errorf("array index %d is out of range", i)
+	}
+	return i, nil
+}
+
+func getValue(doc interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("member %q does not exist", token)
+			}
+			doc = value
+		case []interface{}:
+			i, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, fmt.Errorf("%q is not inside an object or array", token)
+		}
+	}
+	return doc, nil
+}
+
+// updateParent calls leaf with the container the last token of path refers
+// into and stores the container leaf returns in its place
+func updateParent(doc interface{}, path []string, leaf func(node interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return leaf(doc, path[0])
+	}
+
+	child, err := getValue(doc, path[:1])
+	if err != nil {
+		return nil, err
+	}
+	if child, err = updateParent(child, path[1:], leaf); err != nil {
+		return nil, err
+	}
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		node[path[0]] = child
+	case []interface{}:
+		i, _ := arrayIndex(path[0], len(node), false)
+		node[i] = child
+	}
+	return doc, nil
+}
+
+func addValue(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return updateParent(doc, path, func(node interface{}, token string) (interface{}, error) {
+		switch node := node.(type) {
+		case map[string]interface{}:
+			node[token] = value
+			return node, nil
+		case []interface{}:
+			i, err := arrayIndex(token, len(node), true)
+			if err != nil {
+				return nil, err
+			}
+			node = append(node, nil)
+			copy(node[i+1:], node[i:])
+			node[i] = value
+			return node, nil
+		}
+		return nil, fmt.Errorf("%q is not inside an object or array", token)
+	})
+}
+
+func removeValue(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("the whole contact cannot be removed")
+	}
+	return updateParent(doc, path, func(node interface{}, token string) (interface{}, error) {
+		switch node := node.(type) {
+		case map[string]interface{}:
+			if _, ok := node[token]; !ok {
+				return nil, fmt.Errorf("member %q does not exist", token)
+			}
+			delete(node, token)
+			return node, nil
+		case []interface{}:
+			i, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			return append(node[:i], node[i+1:]...), nil
+		}
+		return nil, fmt.Errorf("%q is not inside an object or array", token)
+	})
+}
+
+// applyPatch returns contact with patch applied to its JSON document. The
+// patched document must still describe a contact; fields that are not
+// stored by an update must keep their values.
+func applyPatch(contact Contact, patch Patch) (*Contact, error) {
+	data, err := json.Marshal(contact)
+	if err != nil {
+		return nil, err
+	}
+	var stored map[string]interface{}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+
+	// Patches apply to phones; the legacy phone_numbers copy is left out so
+	// it cannot bring back numbers a patch removed
+	delete(stored, "phone_numbers")
+	known := map[string]bool{"deleted_at": true, "score": true}
+	for field := range stored {
+		known[field] = true
+	}
+
+	doc, err := patch.Apply(stored)
+	if err != nil {
+		return nil, err
+	}
+	fields, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, &ValidationError{Fields: []FieldError{{Field: "patch", Message: "result is not a contact"}}}
+	}
+	verr := &ValidationError{}
+	for field := range fields {
+		if !known[field] {
+			verr.Add("patch", "unknown field "+field)
+		}
+	}
+	if err := verr.Err(); err != nil {
+		return nil, err
+	}
+	if data, err = json.Marshal(doc); err != nil {
+		return nil, err
+	}
+
+	patched := &Contact{}
+	if err := json.Unmarshal(data, patched); err != nil {
+		return nil, &ValidationError{Fields: []FieldError{{Field: "patch", Message: "result is not a contact: " + err.Error()}}}
+	}
+
+	if patched.ID != contact.ID {
+		verr.Add("id", "cannot be changed")
+	}
+	if !patched.CreatedAt.Equal(contact.CreatedAt) {
+		verr.Add("created_at", "cannot be changed")
+	}
+	if patched.DeletedAt != nil || patched.Score != 0 {
+		verr.Add("patch", "deleted_at and score cannot be set")
+	}
+	if strings.Join(patched.Tags, "\x00") != strings.Join(contact.Tags, "\x00") {
+		verr.Add("tags", "cannot be changed by a patch; use the tag endpoints")
+	}
+	if err := verr.Err(); err != nil {
+		return nil, err
+	}
+	if patched.Version != 0 && patched.Version != contact.Version {
+		return nil, &VersionMismatchError{Current: &contact}
+	}
+	patched.CreatedAt, patched.Version = contact.CreatedAt, contact.Version
+	return patched, nil
+}
+
+// PatchContact applies patch to a contact. When version is set it must be
+// the stored version of the contact; the version in the patched contact is
+// checked the same way, so a patch can carry its own precondition.
+func (s *Service) PatchContact(id int, patch Patch, version int) (*Contact, error) {
+	return s.repo.PatchContact(id, version, func(contact Contact) (*Contact, error) {
+		patched, err := applyPatch(contact, patch)
+		if err != nil {
+			return nil, err
+		}
+		if err := patched.Validate(); err != nil {
+			return nil, err
+		}
+		return patched, nil
+	})
+}
+
+// PatchContact changes a live contact to the one apply returns for it and
+// writes only what changed: phone numbers, emails, addresses and websites
+// are deleted, updated or inserted one by one. A change to nothing leaves
+// the contact and its version as they are.
+func (r *Repository) PatchContact(id, version int, apply func(Contact) (*Contact, error)) (*Contact, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	locked, err := lockContacts(tx, false, id)
+	if err != nil {
+		return nil, err
+	}
+	before := locked[id]
+	if version != 0 && version != before.Version {
+		return nil, &VersionMismatchError{Current: &before}
+	}
+
+	after, err := apply(before)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.preparePhones(after.Phones); err != nil {
+		return nil, err
+	}
+	prepareDetails(after)
+
+	changes, err := DiffContacts(&before, after)
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 {
+		return &before, nil
+	}
+
+	for _, change := range changes {
+		switch change.Field {
+		case "phones":
+			err = r.patchPhones(tx, id, before.Phones, after.Phones)
+		case "emails", "addresses", "websites":
+			err = patchDetails(tx, id, change.Field, after)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	_, err = tx.Exec(`UPDATE contacts SET first_name = $1, last_name = $2, first_name_folded = $3, last_name_folded = $4, note = $5, version = version + 1 WHERE id = $6`,
+		after.FirstName, after.LastName, FoldText(after.FirstName), FoldText(after.LastName), after.Note, id)
+	if err != nil {
+		return nil, contactError(err)
+	}
+
+	after.Version = before.Version + 1
+	if err := r.recordRevision(tx, ActionUpdate, &before, after); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return after, nil
+}
+
+// patchPhones turns the prepared phones of a contact from stored into
+// patched, matching numbers by their E.164 form. Stored numbers the e164
+// backfill could not normalize have none and are matched by the number as
+// typed instead. Numbers are deleted first and new ones inserted last, and a
+// number only gives up being primary before another one becomes primary, so
+// the UNIQUE constraints hold throughout.
+func (r *Repository) patchPhones(tx *sql.Tx, contactID int, stored, patched []Phone) error {
+	kept := make(map[string]Phone, len(patched))
+	for _, p := range patched {
+		kept[p.E164] = p
+	}
+	matched := make(map[string]bool, len(stored))
+
+	// Numbers with an E.164 form are matched first, so a legacy row cannot
+	// claim a number another row already stores
+	type change struct{ stored, next Phone }
+	var changed []change
+	var gone []Phone
+	for _, p := range stored {
+		if p.E164 == "" {
+			continue
+		}
+		next, ok := kept[p.E164]
+		if !ok {
+			gone = append(gone, p)
+			continue
+		}
+		matched[next.E164] = true
+		if next != p {
+			changed = append(changed, change{p, next})
+		}
+	}
+	for _, p := range stored {
+		if p.E164 != "" {
+			continue
+		}
+		next, ok := Phone{}, false
+		for _, candidate := range patched {
+			if candidate.Number == p.Number && !matched[candidate.E164] {
+				next, ok = candidate, true
+				break
+			}
+		}
+		if !ok {
+			gone = append(gone, p)
+			continue
+		}
+		matched[next.E164] = true
+		changed = append(changed, change{p, next})
+	}
+
+	for _, p := range gone {
+		condition, arg := storedPhone(p, 2)
+		if _, err := tx.Exec(`DELETE FROM phone_numbers WHERE contact_id = $1 AND `+condition, contactID, arg); err != nil {
+			return err
+		}
+	}
+	for _, primary := range []bool{false, true} {
+		for _, c := range changed {
+			p := c.next
+			if p.Primary != primary {
+				continue
+			}
+			condition, arg := storedPhone(c.stored, 7)
+			_, err := tx.Exec(`UPDATE phone_numbers SET number = $1, e164 = $2, label = $3, is_primary = $4, extension = $5 WHERE contact_id = $6 AND `+condition,
+				p.Number, p.E164, p.Label, p.Primary, p.Extension, contactID, arg)
+			if err != nil {
+				return r.phoneError(err, p.Number, p.E164)
+			}
+		}
+	}
+
+	var added []Phone
+	for _, p := range patched {
+		if !matched[p.E164] {
+			added = append(added, p)
+		}
+	}
+	return r.insertPhones(tx, contactID, added)
+}
+
+// storedPhone returns the condition, using placeholder n, and its argument
+// that find the row of stored phone p among those of its contact
+func storedPhone(p Phone, n int) (string, string) {
+	if p.E164 == "" {
+		return fmt.Sprintf("e164 IS NULL AND number = $%d", n), p.Number
+	}
+	return fmt.Sprintf("e164 = $%d", n), p.E164
+}
+
+// detailColumnsOf lists the columns the rows of the named detail list are
+// stored in, after contact_id
+func detailColumnsOf(field string) []string {
+	switch field {
+	case "emails":
+		return []string{"address", "label"}
+	case "addresses":
+		return []string{"label", "street", "city", "city_folded", "region", "postal_code", "country"}
+	}
+	return []string{"url", "label"}
+}
+
+// detailValues returns the values of the rows of the named detail list of
+// contact, in the order of detailColumnsOf
+func detailValues(contact *Contact, field string) [][]string {
+	var rows [][]string
+	switch field {
+	case "emails":
+		for _, e := range contact.Emails {
+			rows = append(rows, []string{e.Address, e.Label})
+		}
+	case "addresses":
+		for _, a := range contact.Addresses {
+			rows = append(rows, []string{a.Label, a.Street, a.City, FoldText(a.City), a.Region, a.PostalCode, a.Country})
+		}
+	case "websites":
+		for _, w := range contact.Websites {
+			rows = append(rows, []string{w.URL, w.Label})
+		}
+	}
+	return rows
+}
+
+// detailRow is a stored row of a detail list
+type detailRow struct {
+	id     int
+	values []string
+}
+
+// storedDetails reads the rows of the named detail list of a contact inside tx
+func storedDetails(tx *sql.Tx, contactID int, field string) ([]detailRow, error) {
+	columns := detailColumnsOf(field)
+	rows, err := tx.Query(`SELECT id, `+strings.Join(columns, ", ")+` FROM `+field+` WHERE contact_id = $1 ORDER BY id`, contactID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stored []detailRow
+	for rows.Next() {
+		row := detailRow{values: make([]string, len(columns))}
+		dest := []interface{}{&row.id}
+		for i := range row.values {
+			dest = append(dest, &row.values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		stored = append(stored, row)
+	}
+	return stored, rows.Err()
+}
+
+// patchDetails turns the stored rows of the named detail list of a contact
+// into those of patched. Rows that are unchanged are left alone; the other
+// stored rows are updated in place to the new ones as far as they go, and
+// the rest are deleted or inserted.
+func patchDetails(tx *sql.Tx, contactID int, field string, patched *Contact) error {
+	stored, err := storedDetails(tx, contactID, field)
+	if err != nil {
+		return err
+	}
+	next := detailValues(patched, field)
+
+	unchanged := make([]bool, len(next))
+	var gone []detailRow
+	for _, row := range stored {
+		found := false
+		for i, values := range next {
+			if !unchanged[i] && reflect.DeepEqual(values, row.values) {
+				unchanged[i], found = true, true
+				break
+			}
+		}
+		if !found {
+			gone = append(gone, row)
+		}
+	}
+	var added [][]string
+	for i, values := range next {
+		if !unchanged[i] {
+			added = append(added, values)
+		}
+	}
+
+	columns := detailColumnsOf(field)
+	for _, row := range gone {
+		if len(added) == 0 {
+			if _, err := tx.Exec(`DELETE FROM `+field+` WHERE id = $1`, row.id); err != nil {
+				return err
+			}
+			continue
+		}
+		set := make([]string, len(columns))
+		args := make([]interface{}, 0, len(columns)+1)
+		for i, column := range columns {
+			set[i] = column + " = $" + strconv.Itoa(i+1)
+			args = append(args, added[0][i])
+		}
+		args = append(args, row.id)
+		_, err := tx.Exec(`UPDATE `+field+` SET `+strings.Join(set, ", ")+` WHERE id = $`+strconv.Itoa(len(args)), args...)
+		if err != nil {
+			return detailError(err, field)
+		}
+		added = added[1:]
+	}
+	for _, values := range added {
+		placeholders := make([]string, len(values)+1)
+		args := []interface{}{contactID}
+		placeholders[0] = "$1"
+		for i, value := range values {
+			placeholders[i+1] = "$" + strconv.Itoa(i+2)
+			args = append(args, value)
+		}
+		_, err := tx.Exec(`INSERT INTO `+field+` (contact_id, `+strings.Join(columns, ", ")+`) VALUES (`+strings.Join(placeholders, ", ")+`)`, args...)
+		if err != nil {
+			return detailError(err, field)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package contacts
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidPhone is returned when a phone number cannot be parsed
+var ErrInvalidPhone = errors.New("invalid phone number")
+
+// DefaultRegion is the region assumed for numbers written without an
+// international prefix. It is set from the phone.default_region config.
+var DefaultRegion = "IR"
+
+// region describes how national numbers are written in a country
+type region struct {
+	code   string // country calling code
+	trunk  string // national trunk prefix dropped in international form
+	minNSN int    // shortest national significant number
+	maxNSN int    // longest national significant number
+}
+
+// regions lists the regions numbers can be parsed for, keyed by ISO 3166 code
+var regions = map[string]region{
+	"AE": {"971", "0", 8, 9},
+	"AF": {"93", "0", 9, 9},
+	"AU": {"61", "0", 9, 9},
+	"CA": {"1", "1", 10, 10},
+	"CN": {"86", "0", 5, 12},
+	"DE": {"49", "0", 5, 14},
+	"ES": {"34", "", 9, 9},
+	"FR": {"33", "0", 9, 9},
+	"GB": {"44", "0", 9, 10},
+	"IN": {"91", "0", 10, 10},
+	"IQ": {"964", "0", 8, 10},
+	"IR": {"98", "0", 10, 10},
+	"IT": {"39", "", 6, 11},
+	"NL": {"31", "0", 9, 9},
+	"RU": {"7", "8", 10, 10},
+	"SA": {"966", "0", 8, 9},
+	"TR": {"90", "0", 10, 10},
+	"US": {"1", "1", 10, 10},
+}
+
+// callingCodes maps country calling codes back to their number lengths
+var callingCodes = func() map[string]region {
+	codes := make(map[string]region, len(regions))
+	for _, r := range regions {
+		codes[r.code] = r
+	}
+	return codes
+}()
+
+// E.164 limits the full number, country code included, to 15 digits
+const (
+	minE164Digits = 7
+	maxE164Digits = 15
+)
+
+// KnownRegion reports whether numbers can be parsed for the region code
+func KnownRegion(code string) bool {
+	_, ok := regions[strings.ToUpper(code)]
+	return ok
+}
+
+// NormalizePhone parses a phone number typed in any common format and returns
+// its canonical E.164 form, e.g. "0912 123 4567" with region IR becomes
+// "+989121234567". Numbers starting with "+" or "00" are read as
+// international; anything else is read as a national number of regionCode.
+func NormalizePhone(raw, regionCode string) (string, error) {
+	digits, international, err := phoneDigits(raw)
+	if err != nil {
+		return "", err
+	}
+
+	if !international {
+		reg, ok := regions[strings.ToUpper(regionCode)]
+		if !ok {
+			return "", fmt.Errorf("%w: %q has no country code and region %q is unknown", ErrInvalidPhone, raw, regionCode)
+		}
+		if reg.trunk != "" && strings.HasPrefix(digits, reg.trunk) && len(digits)-len(reg.trunk) >= reg.minNSN {
+			digits = digits[len(reg.trunk):]
+		}
+		digits = reg.code + digits
+	}
+
+	if len(digits) < minE164Digits || len(digits) > maxE164Digits {
+		return "", fmt.Errorf("%w: %q has the wrong number of digits", ErrInvalidPhone, raw)
+	}
+
+	// Country codes are prefix-free, so at most one of these matches
+	for n := 1; n <= 3; n++ {
+		if reg, ok := callingCodes[digits[:n]]; ok {
+			nsn := len(digits) - n
+			if nsn < reg.minNSN || nsn > reg.maxNSN {
+				return "", fmt.Errorf("%w: %q has the wrong number of digits for +%s", ErrInvalidPhone, raw, reg.code)
+			}
+			break
+		}
+	}
+
+	return "+" + digits, nil
+}
+
+// phoneDigits strips formatting from a phone number and reports whether it
+// was written with an international prefix. Persian and Arabic-Indic digits
+// are accepted.
+func phoneDigits(raw string) (string, bool, error) {
+	s := FoldText(raw)
+	international := strings.HasPrefix(s, "+")
+	s = strings.TrimPrefix(s, "+")
+
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case strings.ContainsRune(" -./()\u00a0", r):
+			// separators are ignored
+		default:
+			return "", false, fmt.Errorf("%w: %q contains %q", ErrInvalidPhone, raw, r)
+		}
+	}
+
+	digits := b.String()
+	if !international && strings.HasPrefix(digits, "00") {
+		digits, international = digits[2:], true
+	}
+	if digits == "" {
+		return "", false, fmt.Errorf("%w: %q has no digits", ErrInvalidPhone, raw)
+	}
+	return digits, international, nil
+}
+
+// phoneSearchDigits returns the digits a partial phone number query should
+// match inside stored E.164 numbers, or "" when the query is not a number.
+// Full numbers are normalized; partial ones lose their formatting and any
+// international or trunk prefix so "0912 123" matches "+989121234567".
+func phoneSearchDigits(query, regionCode string) string {
+	if e164, err := NormalizePhone(query, regionCode); err == nil {
+		return strings.TrimPrefix(e164, "+")
+	}
+
+	digits, international, err := phoneDigits(query)
+	if err != nil || len(digits) < 3 {
+		return ""
+	}
+	if reg, ok := regions[strings.ToUpper(regionCode)]; ok && !international && reg.trunk != "" {
+		digits = strings.TrimPrefix(digits, reg.trunk)
+	}
+	return digits
+}
+
+// phonePrefix returns the E.164 form of the start of a phone number, so
+// "0912*" with region IR matches every number starting with "+98912"
+func phonePrefix(query, regionCode string) (string, error) {
+	digits, international, err := phoneDigits(query)
+	if err != nil {
+		return "", err
+	}
+	if international {
+		return "+" + digits, nil
+	}
+
+	reg, ok := regions[strings.ToUpper(regionCode)]
+	if !ok {
+		return "", fmt.Errorf("%w: %q has no country code and region %q is unknown", ErrInvalidPhone, query, regionCode)
+	}
+	return "+" + reg.code + strings.TrimPrefix(digits, reg.trunk), nil
+}
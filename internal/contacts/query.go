@@ -0,0 +1,389 @@
+package contacts
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Fields a search term can be scoped to by writing field:value
+var queryFields = map[string]bool{
+	"first": true,
+	"last":  true,
+	"phone": true,
+	"id":    true,
+}
+
+type queryTokenKind int
+
+const (
+	queryTerm queryTokenKind = iota
+	queryAnd
+	queryOr
+	queryNot
+	queryOpen
+	queryClose
+	queryEnd
+)
+
+// queryToken is one piece of a search query: an operator, a parenthesis or a
+// term, optionally scoped to a field and ending in the * prefix wildcard.
+// pos is the 1-based character position the token starts at.
+type queryToken struct {
+	kind   queryTokenKind
+	pos    int
+	field  string
+	text   string
+	quoted bool
+	prefix bool
+
+	// unknownField is what comes before the colon of a term such as "12:30"
+	// that looks scoped to a field but is not; the term keeps the colon
+	unknownField string
+}
+
+// lexQuery splits a search query into tokens. Operators are only recognized
+// in upper case so "and" can still be searched for, and a word whose colon
+// does not follow a field name, such as a time or a URL, is a plain term.
+func lexQuery(s string) ([]queryToken, error) {
+	runes := []rune(s)
+	isWord := func(r rune) bool {
+		return !unicode.IsSpace(r) && r != '(' && r != ')' && r != '"'
+	}
+
+	var tokens []queryToken
+	for i := 0; i < len(runes); {
+		switch r := runes[i]; {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case r == '(':
+			tokens = append(tokens, queryToken{kind: queryOpen, pos: i + 1, text: "("})
+			i++
+			continue
+		case r == ')':
+			tokens = append(tokens, queryToken{kind: queryClose, pos: i + 1, text: ")"})
+			i++
+			continue
+		}
+
+		tok := queryToken{kind: queryTerm, pos: i + 1}
+
+		// A field name runs up to the first colon of a word
+		j := i
+		for j < len(runes) && isWord(runes[j]) && runes[j] != ':' {
+			j++
+		}
+		if j > i && j < len(runes) && runes[j] == ':' {
+			if field := strings.ToLower(string(runes[i:j])); queryFields[field] {
+				tok.field = field
+				i = j + 1
+			} else {
+				tok.unknownField = string(runes[i:j])
+			}
+		}
+
+		if i < len(runes) && runes[i] == '"' {
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, &QueryError{Position: i + 1, Message: "unterminated quoted phrase"}
+			}
+			tok.text, tok.quoted = string(runes[i+1:end]), true
+			i = end + 1
+			if i < len(runes) && runes[i] == '*' {
+				tok.prefix = true
+				i++
+			}
+		} else {
+			j = i
+			for j < len(runes) && isWord(runes[j]) {
+				j++
+			}
+			word := runes[i:j]
+			if len(word) > 0 && word[len(word)-1] == '*' {
+				tok.prefix = true
+				word = word[:len(word)-1]
+			}
+			for k, r := range word {
+				if r == '*' {
+					return nil, &QueryError{Position: i + k + 1, Message: "the * wildcard is only allowed at the end of a term"}
+				}
+			}
+			switch {
+			case len(word) > 0:
+			case tok.field != "":
+				return nil, &QueryError{Position: i + 1, Message: fmt.Sprintf("expected a value after %s:", tok.field)}
+			default:
+				return nil, &QueryError{Position: i + 1, Message: "the * wildcard needs a prefix"}
+			}
+			tok.text = string(word)
+			i = j
+
+			if tok.field == "" && !tok.prefix {
+				switch tok.text {
+				case "AND":
+					tok.kind = queryAnd
+				case "OR":
+					tok.kind = queryOr
+				case "NOT":
+					tok.kind = queryNot
+				}
+			}
+		}
+
+		if i < len(runes) && (isWord(runes[i]) || runes[i] == '"') {
+			return nil, &QueryError{Position: i + 1, Message: fmt.Sprintf("unexpected %q", runes[i])}
+		}
+		tokens = append(tokens, tok)
+	}
+	return append(tokens, queryToken{kind: queryEnd, pos: len(runes) + 1}), nil
+}
+
+// plainQuery reports whether a query uses none of the query language, so it
+// is searched as one piece of text as it always was. Names and phone numbers
+// typed with spaces keep matching as a whole.
+func plainQuery(tokens []queryToken) bool {
+	for _, tok := range tokens {
+		switch tok.kind {
+		case queryAnd, queryOr, queryNot:
+			return false
+		}
+	}
+	return !usesSyntax(tokens)
+}
+
+// usesSyntax reports whether a query uses the query language beyond its
+// operators: fields, quoted phrases, the * wildcard or parentheses
+func usesSyntax(tokens []queryToken) bool {
+	for _, tok := range tokens {
+		switch tok.kind {
+		case queryOpen, queryClose:
+			return true
+		case queryTerm:
+			if tok.field != "" || tok.quoted || tok.prefix {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// unknownFields returns an error for the first term of a query written in
+// the query language that looks scoped to a field that does not exist
+func unknownFields(tokens []queryToken) error {
+	for _, tok := range tokens {
+		if tok.unknownField != "" {
+			return &QueryError{Position: tok.pos, Message: fmt.Sprintf("unknown field %q", tok.unknownField)}
+		}
+	}
+	return nil
+}
+
+// queryCompiler turns parsed search terms into SQL conditions on contacts c.
+// Every value is passed as a placeholder, numbered in the order of args.
+type queryCompiler struct {
+	region string
+	args   []interface{}
+}
+
+func (qc *queryCompiler) arg(v interface{}) string {
+	qc.args = append(qc.args, v)
+	return fmt.Sprintf("$%d", len(qc.args))
+}
+
+// queryParser compiles tokens while parsing them by precedence: OR binds
+// loosest, then AND, which may be left out between terms, then NOT.
+type queryParser struct {
+	tokens []queryToken
+	next   int
+	qc     *queryCompiler
+}
+
+func (p *queryParser) peek() queryToken {
+	return p.tokens[p.next]
+}
+
+func (p *queryParser) take() queryToken {
+	tok := p.tokens[p.next]
+	if tok.kind != queryEnd {
+		p.next++
+	}
+	return tok
+}
+
+func (p *queryParser) parse() (string, error) {
+	filter, err := p.or()
+	if err != nil {
+		return "", err
+	}
+	if tok := p.peek(); tok.kind != queryEnd {
+		return "", &QueryError{Position: tok.pos, Message: fmt.Sprintf("unexpected %s", tok.text)}
+	}
+	return filter, nil
+}
+
+func (p *queryParser) or() (string, error) {
+	return p.join(" OR ", p.and, func() bool {
+		if p.peek().kind != queryOr {
+			return false
+		}
+		p.take()
+		return true
+	})
+}
+
+func (p *queryParser) and() (string, error) {
+	return p.join(" AND ", p.not, func() bool {
+		switch p.peek().kind {
+		case queryAnd:
+			p.take()
+			return true
+		case queryTerm, queryNot, queryOpen:
+			return true
+		}
+		return false
+	})
+}
+
+// join parses operands with operand for as long as more reports another one
+// follows, and joins their conditions with op
+func (p *queryParser) join(op string, operand func() (string, error), more func() bool) (string, error) {
+	first, err := operand()
+	if err != nil {
+		return "", err
+	}
+	filters := []string{first}
+	for more() {
+		filter, err := operand()
+		if err != nil {
+			return "", err
+		}
+		filters = append(filters, filter)
+	}
+	if len(filters) == 1 {
+		return first, nil
+	}
+	return "(" + strings.Join(filters, op) + ")", nil
+}
+
+func (p *queryParser) not() (string, error) {
+	if p.peek().kind != queryNot {
+		return p.primary()
+	}
+	p.take()
+	filter, err := p.not()
+	if err != nil {
+		return "", err
+	}
+	return "NOT (" + filter + ")", nil
+}
+
+func (p *queryParser) primary() (string, error) {
+	tok := p.take()
+	switch tok.kind {
+	case queryTerm:
+		return p.qc.term(tok)
+	case queryOpen:
+		filter, err := p.or()
+		if err != nil {
+			return "", err
+		}
+		if end := p.take(); end.kind != queryClose {
+			return "", &QueryError{Position: end.pos, Message: "expected )"}
+		}
+		return filter, nil
+	case queryEnd:
+		return "", &QueryError{Position: tok.pos, Message: "unexpected end of query"}
+	}
+	return "", &QueryError{Position: tok.pos, Message: fmt.Sprintf("unexpected %s", tok.text)}
+}
+
+// term compiles a single term. Unscoped terms match anywhere in a name,
+// number, email or city; scoped ones match the whole first or last name, a
+// full phone number or contains the digits of a partial one, and an ID.
+// The * wildcard matches values starting with the term instead.
+func (qc *queryCompiler) term(tok queryToken) (string, error) {
+	text := FoldText(tok.text)
+	switch tok.field {
+	case "first":
+		return qc.name("c.first_name_folded", text, tok.prefix), nil
+	case "last":
+		return qc.name("c.last_name_folded", text, tok.prefix), nil
+	case "phone":
+		return qc.phone(tok, text)
+	case "id":
+		id, err := strconv.Atoi(text)
+		if err != nil || id <= 0 || tok.prefix {
+			return "", &QueryError{Position: tok.pos, Message: "id: expects a contact ID"}
+		}
+		return "c.id = " + qc.arg(id), nil
+	}
+	if tok.prefix {
+		return qc.startsWith(text), nil
+	}
+	return qc.anywhere(text), nil
+}
+
+// anywhere matches text inside any name, number, email or city. When the
+// text looks like a phone number its digits are matched against stored E.164
+// numbers too, so the formatting the user typed does not matter.
+func (qc *queryCompiler) anywhere(text string) string {
+	v := qc.arg(text)
+	filter := fmt.Sprintf(`c.first_name_folded ILIKE '%%' || %[1]s || '%%'
+           OR c.last_name_folded ILIKE '%%' || %[1]s || '%%'
+           OR EXISTS (SELECT 1 FROM phone_numbers p WHERE p.contact_id = c.id AND p.number ILIKE '%%' || %[1]s || '%%')
+           OR EXISTS (SELECT 1 FROM emails e WHERE e.contact_id = c.id AND e.address ILIKE '%%' || %[1]s || '%%')
+           OR EXISTS (SELECT 1 FROM addresses a WHERE a.contact_id = c.id AND a.city_folded ILIKE '%%' || %[1]s || '%%')`, v)
+
+	if digits := phoneSearchDigits(text, qc.region); digits != "" {
+		filter += `
+           OR EXISTS (SELECT 1 FROM phone_numbers p WHERE p.contact_id = c.id AND p.e164 LIKE '%' || ` + qc.arg(digits) + ` || '%')`
+	}
+	return "(" + filter + ")"
+}
+
+// startsWith matches any name, number, email or city starting with text
+func (qc *queryCompiler) startsWith(text string) string {
+	v := qc.arg(text)
+	filter := fmt.Sprintf(`c.first_name_folded ILIKE %[1]s || '%%'
+           OR c.last_name_folded ILIKE %[1]s || '%%'
+           OR EXISTS (SELECT 1 FROM phone_numbers p WHERE p.contact_id = c.id AND p.number ILIKE %[1]s || '%%')
+           OR EXISTS (SELECT 1 FROM emails e WHERE e.contact_id = c.id AND e.address ILIKE %[1]s || '%%')
+           OR EXISTS (SELECT 1 FROM addresses a WHERE a.contact_id = c.id AND a.city_folded ILIKE %[1]s || '%%')`, v)
+
+	if prefix, err := phonePrefix(text, qc.region); err == nil {
+		filter += `
+           OR EXISTS (SELECT 1 FROM phone_numbers p WHERE p.contact_id = c.id AND p.e164 LIKE ` + qc.arg(prefix) + ` || '%')`
+	}
+	return "(" + filter + ")"
+}
+
+// name matches a folded name column regardless of case
+func (qc *queryCompiler) name(column, text string, prefix bool) string {
+	if prefix {
+		return column + " ILIKE " + qc.arg(text) + " || '%'"
+	}
+	return "lower(" + column + ") = lower(" + qc.arg(text) + ")"
+}
+
+func (qc *queryCompiler) phone(tok queryToken, text string) (string, error) {
+	const match = `EXISTS (SELECT 1 FROM phone_numbers p WHERE p.contact_id = c.id AND p.e164 `
+	if tok.prefix {
+		prefix, err := phonePrefix(text, qc.region)
+		if err != nil {
+			return "", &QueryError{Position: tok.pos, Message: "phone: expects the start of a phone number"}
+		}
+		return match + "LIKE " + qc.arg(prefix) + " || '%')", nil
+	}
+	if e164, err := NormalizePhone(text, qc.region); err == nil {
+		return match + "= " + qc.arg(e164) + ")", nil
+	}
+	if digits := phoneSearchDigits(text, qc.region); digits != "" {
+		return match + "LIKE '%' || " + qc.arg(digits) + " || '%')", nil
+	}
+	return "", &QueryError{Position: tok.pos, Message: "phone: expects a phone number"}
+}
@@ -2,49 +2,88 @@ package contacts
 
 import (
 	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Repository defines methods for contact management
 type IRepository interface {
 	CreateContact(contact *Contact) (int, error)
+	CreateContacts(batch []*Contact, dryRun bool) ([]error, error)
+	GetContact(id int) (*Contact, error)
 	UpdateContact(contact *Contact) error
-	SearchContacts(query string) ([]Contact, error)
+	DeleteContact(id int) error
+	RestoreContact(id int) error
+	PurgeContact(id int) error
+	PurgeTrash(before time.Time) (int64, error)
+	TrashContacts(opts ListOptions) (*Page, error)
+	ListContacts(opts ListOptions) (*Page, error)
+	SearchContacts(query SearchQuery, opts ListOptions) (*Page, error)
+	EachContact(query SearchQuery, opts ListOptions, fn func(Contact) error) error
+	Suggest(prefix string, limit int) ([]Suggestion, error)
+	Lookup(number string) (*LookupResult, error)
+	DuplicateCandidates() ([][2]int, error)
+	ContactsByID(ids []int) ([]Contact, error)
+	SimilarContacts(contact *Contact) ([]Contact, error)
+	MergeContacts(req MergeRequest) (*MergeResult, error)
+	History(id int) ([]Revision, error)
+	Revision(id, revision int) (*Revision, error)
+	RevertContact(id, revision int) (*Contact, error)
+	PatchContact(id, version int, apply func(Contact) (*Contact, error)) (*Contact, error)
+	Tags() ([]Tag, error)
+	GetTag(name string) (*Tag, error)
+	CreateTag(name string) (*Tag, error)
+	RenameTag(name, newName string) (*Tag, error)
+	DeleteTag(name string) error
+	TagContacts(name string, ids []int) (int64, error)
+	UntagContacts(name string, ids []int) (int64, error)
+	Groups() ([]Group, error)
+	GetGroup(name string) (*Group, error)
+	CreateGroup(group *Group) error
+	UpdateGroup(name string, group *Group) error
+	DeleteGroup(name string) error
+	GroupContacts(name string, opts ListOptions) (*Page, error)
+	EachGroupContact(name string, opts ListOptions, fn func(Contact) error) error
+	WithActor(actor string) IRepository
 }
 
 type Repository struct {
 	DB *sql.DB
+
+	// Region is used to normalize phone numbers typed without a country code
+	Region string
+
+	// Actor is who changes are recorded for in the contact history
+	Actor string
 }
 
 func NewRepository(db *sql.DB) IRepository {
 	return &Repository{
-		DB: db,
+		DB:     db,
+		Region: DefaultRegion,
 	}
 }
 
-// CreateContact stores a new contact with phone numbers
+// CreateContact stores a new contact with phone numbers, emails, addresses and websites
 func (r *Repository) CreateContact(contact *Contact) (int, error) {
+	if err := r.preparePhones(contact.Phones); err != nil {
+		return 0, err
+	}
+	prepareDetails(contact)
+
 	tx, err := r.DB.Begin()
 	if err != nil {
 		return 0, err
 	}
 	defer tx.Rollback()
 
-	// Insert into contacts table
-	var contactID int
-	err = tx.QueryRow(`INSERT INTO contacts (first_name, last_name) VALUES ($1, $2) RETURNING id`,
-		contact.FirstName, contact.LastName).Scan(&contactID)
+	contactID, err := r.insertContact(tx, contact)
 	if err != nil {
 		return 0, err
 	}
 
-	// Insert each phone number
-	for _, number := range contact.PhoneNumbers {
-		_, err = tx.Exec(`INSERT INTO phone_numbers (contact_id, number) VALUES ($1, $2)`, contactID, number)
-		if err != nil {
-			return 0, err
-		}
-	}
-
 	// Commit transaction
 	err = tx.Commit()
 	if err != nil {
@@ -53,20 +92,153 @@ func (r *Repository) CreateContact(contact *Contact) (int, error) {
 	return contactID, nil
 }
 
-// UpdateContact updates an existing contact and its phone numbers
+// CreateContacts stores a batch of contacts in a single transaction. Each
+// contact is inserted under its own savepoint, so a failing contact does not
+// abort the rest of the batch. Numbers that already belong to a contact or
+// to an earlier contact of the batch are reported before anything is written;
+// with dryRun nothing is written at all.
+// errs holds the outcome of each contact and err a failure of the whole batch.
+// The ID of every stored contact is set.
+func (r *Repository) CreateContacts(batch []*Contact, dryRun bool) (errs []error, err error) {
+	errs = make([]error, len(batch))
+	e164s := []string{}
+	for i, contact := range batch {
+		if errs[i] = r.preparePhones(contact.Phones); errs[i] != nil {
+			continue
+		}
+		prepareDetails(contact)
+		for _, p := range contact.Phones {
+			e164s = append(e164s, p.E164)
+		}
+	}
+
+	owners, err := phoneOwners(r.DB, e164s)
+	if err != nil {
+		return nil, err
+	}
+	// The database cannot tell who owns a number taken earlier in the same
+	// batch, so repeats within the batch are caught here
+	first := make(map[string]int)
+	for i, contact := range batch {
+		if errs[i] != nil {
+			continue
+		}
+		for _, p := range contact.Phones {
+			if owner, ok := owners[p.E164]; ok {
+				errs[i] = &DuplicatePhoneError{Number: p.Number, Owner: &Contact{ID: owner}}
+				break
+			}
+			if j, ok := first[p.E164]; ok {
+				errs[i] = &BatchDuplicatePhoneError{Number: p.Number, First: j, Index: i}
+				break
+			}
+		}
+		if errs[i] == nil {
+			for _, p := range contact.Phones {
+				first[p.E164] = i
+			}
+		}
+	}
+	if dryRun {
+		return errs, nil
+	}
+
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for i, contact := range batch {
+		if errs[i] != nil {
+			continue
+		}
+		if _, err := tx.Exec(`SAVEPOINT import_contact`); err != nil {
+			return nil, err
+		}
+		contact.ID, errs[i] = r.insertContact(tx, contact)
+		if errs[i] != nil {
+			if _, err := tx.Exec(`ROLLBACK TO SAVEPOINT import_contact`); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if _, err := tx.Exec(`RELEASE SAVEPOINT import_contact`); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return errs, nil
+}
+
+// GetContact fetches a single contact with its phone numbers and details.
+// It returns ErrNotFound when no contact has the given ID or it is in the trash.
+func (r *Repository) GetContact(id int) (*Contact, error) {
+	contact := &Contact{Phones: []Phone{}}
+	var details detailsRow
+	err := r.DB.QueryRow(`SELECT c.id, c.first_name, c.last_name, c.note, c.created_at, c.version, `+detailColumns+` FROM contacts c WHERE c.id = $1 AND c.deleted_at IS NULL`, id).
+		Scan(&contact.ID, &contact.FirstName, &contact.LastName, &contact.Note, &contact.CreatedAt, &contact.Version,
+			&details.emails, &details.addresses, &details.websites, &details.tags)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if err := details.apply(contact); err != nil {
+		return nil, err
+	}
+
+	rows, err := r.DB.Query(`SELECT number, e164, label, is_primary, extension FROM phone_numbers WHERE contact_id = $1 ORDER BY id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var phone phoneRow
+		if err := rows.Scan(&phone.number, &phone.e164, &phone.label, &phone.primary, &phone.extension); err != nil {
+			return nil, err
+		}
+		contact.Phones = append(contact.Phones, phone.phone())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return contact, nil
+}
+
+// UpdateContact updates an existing contact, replacing its phone numbers and
+// details. When contact.Version is set it must be the stored version, or a
+// *VersionMismatchError with the stored contact is returned. On success the
+// contact takes its new version.
 func (r *Repository) UpdateContact(contact *Contact) error {
+	if err := r.preparePhones(contact.Phones); err != nil {
+		return err
+	}
+	prepareDetails(contact)
+
 	tx, err := r.DB.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	// Update contact details
-	_, err = tx.Exec(`UPDATE contacts SET first_name = $1, last_name = $2 WHERE id = $3`,
-		contact.FirstName, contact.LastName, contact.ID)
+	locked, err := lockContacts(tx, false, contact.ID)
 	if err != nil {
 		return err
 	}
+	before := locked[contact.ID]
+	if contact.Version != 0 && contact.Version != before.Version {
+		return &VersionMismatchError{Current: &before}
+	}
+
+	// Update contact details
+	_, err = tx.Exec(`UPDATE contacts SET first_name = $1, last_name = $2, first_name_folded = $3, last_name_folded = $4, note = $5, version = version + 1 WHERE id = $6`,
+		contact.FirstName, contact.LastName, FoldText(contact.FirstName), FoldText(contact.LastName), contact.Note, contact.ID)
+	if err != nil {
+		return contactError(err)
+	}
 
 	// Delete existing phone numbers
 	_, err = tx.Exec(`DELETE FROM phone_numbers WHERE contact_id = $1`, contact.ID)
@@ -75,11 +247,21 @@ func (r *Repository) UpdateContact(contact *Contact) error {
 	}
 
 	// Insert updated phone numbers
-	for _, number := range contact.PhoneNumbers {
-		_, err = tx.Exec(`INSERT INTO phone_numbers (contact_id, number) VALUES ($1, $2)`, contact.ID, number)
-		if err != nil {
-			return err
-		}
+	if err := r.insertPhones(tx, contact.ID, contact.Phones); err != nil {
+		return err
+	}
+
+	// Replace emails, addresses and websites
+	if err := deleteDetails(tx, contact.ID); err != nil {
+		return err
+	}
+	if err := insertDetails(tx, contact.ID, contact); err != nil {
+		return err
+	}
+
+	contact.CreatedAt, contact.Version, contact.Tags = before.CreatedAt, before.Version+1, before.Tags
+	if err := r.recordRevision(tx, ActionUpdate, &before, contact); err != nil {
+		return err
 	}
 
 	// Commit transaction
@@ -87,48 +269,368 @@ func (r *Repository) UpdateContact(contact *Contact) error {
 	return err
 }
 
-// SearchContacts finds contacts based on partial matches across all fields
-func (r *Repository) SearchContacts(query string) ([]Contact, error) {
-	rows, err := r.DB.Query(`
-        SELECT c.id, c.first_name, c.last_name, p.number
-        FROM contacts c
-        LEFT JOIN phone_numbers p ON c.id = p.contact_id
-        WHERE c.first_name ILIKE '%' || $1 || '%'
-           OR c.last_name ILIKE '%' || $1 || '%'
-           OR p.number ILIKE '%' || $1 || '%'
-    `, query)
+// DeleteContact moves a contact to the trash, where it keeps its phone
+// numbers so it can be restored. It returns ErrNotFound when no contact has
+// the given ID or it is already in the trash.
+func (r *Repository) DeleteContact(id int) error {
+	return r.moveToTrash(id, true)
+}
+
+// RestoreContact moves a contact out of the trash. It returns ErrNotFound
+// when no contact in the trash has the given ID.
+func (r *Repository) RestoreContact(id int) error {
+	return r.moveToTrash(id, false)
+}
+
+// moveToTrash moves a contact into or out of the trash and records it
+func (r *Repository) moveToTrash(id int, trash bool) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	locked, err := lockContacts(tx, !trash, id)
+	if err != nil {
+		return err
+	}
+	before := locked[id]
+
+	after, action := before, ActionRestore
+	if trash {
+		after.DeletedAt, action = new(time.Time), ActionDelete
+		err = tx.QueryRow(`UPDATE contacts SET deleted_at = now() WHERE id = $1 RETURNING deleted_at`, id).Scan(after.DeletedAt)
+	} else {
+		after.DeletedAt = nil
+		_, err = tx.Exec(`UPDATE contacts SET deleted_at = NULL WHERE id = $1`, id)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := r.recordRevision(tx, action, &before, &after); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PurgeContact permanently removes a contact from the trash; its phone
+// numbers and details are removed by ON DELETE CASCADE. It returns
+// ErrNotFound when no contact in the trash has the given ID.
+func (r *Repository) PurgeContact(id int) error {
+	purged, err := r.purgeContacts("id = $1", id)
+	if err != nil {
+		return err
+	}
+	if purged == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// PurgeTrash permanently removes the contacts moved to the trash before
+// the given time and returns how many were removed
+func (r *Repository) PurgeTrash(before time.Time) (int64, error) {
+	return r.purgeContacts("deleted_at < $1", before)
+}
+
+// TrashContacts returns one page of the contacts in the trash
+func (r *Repository) TrashContacts(opts ListOptions) (*Page, error) {
+	return r.listContacts(listQuery{filter: "TRUE", trashed: true}, opts)
+}
+
+// ListContacts returns one page of all contacts in a stable order
+func (r *Repository) ListContacts(opts ListOptions) (*Page, error) {
+	return r.listContacts(listQuery{filter: "TRUE"}, opts)
+}
+
+// SearchContacts finds contacts matching the query, either by substring
+// across all fields or ranked by relevance in fuzzy mode
+func (r *Repository) SearchContacts(query SearchQuery, opts ListOptions) (*Page, error) {
+	q, err := query.compile(r.Region)
 	if err != nil {
 		return nil, err
 	}
+	return r.listContacts(q, opts)
+}
+
+// EachContact streams every contact matching query, or every contact when
+// query.Text is empty, limited to opts.Tag when it is set, to fn in the order
+// of opts.Sort and opts.Desc. Rows are read from the database cursor as they
+// arrive rather than collected first; it stops at the first error fn returns.
+func (r *Repository) EachContact(query SearchQuery, opts ListOptions, fn func(Contact) error) error {
+	q := listQuery{filter: "TRUE"}
+	if query.Text != "" {
+		var err error
+		if q, err = query.compile(r.Region); err != nil {
+			return err
+		}
+	}
+	return r.eachContact(q, opts, fn)
+}
+
+// eachContact streams the contacts matching q to fn as EachContact does
+func (r *Repository) eachContact(q listQuery, opts ListOptions, fn func(Contact) error) error {
+	q = q.withTag(opts.Tag)
+	opts = ListOptions{Sort: opts.Sort, Desc: opts.Desc}
+	if _, err := opts.normalize(q.score != ""); err != nil {
+		return err
+	}
+	_, order, args := opts.keyset(nil, q.args)
+
+	// OFFSET 0 keeps the planner from computing the details once per phone number
+	rows, err := r.DB.Query(selectContacts(q, "TRUE", order, "OFFSET 0"), args...)
+	if err != nil {
+		return err
+	}
 	defer rows.Close()
 
-	// Map contacts by ID for easier aggregation of phone numbers
-	contactsMap := make(map[int]*Contact)
+	return scanContacts(rows, fn)
+}
+
+// listContacts selects one page of the contacts matching q using keyset
+// pagination with their details, then joins their phone numbers. One extra
+// contact is fetched to find out whether another page follows.
+func (r *Repository) listContacts(q listQuery, opts ListOptions) (*Page, error) {
+	q = q.withTag(opts.Tag)
+	cur, err := opts.normalize(q.score != "")
+	if err != nil {
+		return nil, err
+	}
+
+	keyset, order, args := opts.keyset(cur, q.args)
+	args = append(args, opts.Limit+1)
+
+	rows, err := r.DB.Query(selectContacts(q, keyset, order, fmt.Sprintf("LIMIT $%d", len(args))), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contacts := []Contact{}
+	err = scanContacts(rows, func(contact Contact) error {
+		contacts = append(contacts, contact)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	page := &Page{Contacts: contacts}
+	if len(contacts) > opts.Limit {
+		page.Contacts = contacts[:opts.Limit]
+		page.NextCursor = opts.nextCursor(page.Contacts[opts.Limit-1])
+	}
+	return page, nil
+}
+
+// selectContacts builds the query selecting the contacts matching q and
+// keyset in order, with their details, and joining their phone numbers.
+// window bounds the contacts selected before the join, e.g. "LIMIT $3".
+func selectContacts(q listQuery, keyset, order, window string) string {
+	score := q.score
+	if score == "" {
+		score = "0::float8"
+	}
+	trashed := "NULL"
+	if q.trashed {
+		trashed = "NOT NULL"
+	}
+
+	return fmt.Sprintf(`
+        SELECT c.id, c.first_name, c.last_name, c.note, c.created_at, c.deleted_at, c.version, c.score,
+               c.emails, c.addresses, c.websites, c.tags,
+               p.number, p.e164, p.label, p.is_primary, p.extension
+        FROM (
+            SELECT c.id, c.first_name, c.last_name, c.note, c.created_at, c.deleted_at, c.version, c.score, %s
+            FROM (
+                SELECT c.id, c.first_name, c.last_name, c.note, c.created_at, c.deleted_at, c.version, %s AS score
+                FROM contacts c
+                WHERE %s AND c.deleted_at IS %s
+            ) c
+            WHERE %s
+            ORDER BY %s
+            %s
+        ) c
+        LEFT JOIN phone_numbers p ON c.id = p.contact_id
+        ORDER BY %s, p.id
+    `, detailColumns, score, q.filter, trashed, keyset, order, window, order)
+}
+
+// scanContacts reads the rows of a selectContacts query and calls fn with
+// each contact once all of its phone numbers have been read. Rows arrive
+// grouped by contact, so phone numbers belong to the contact before them.
+func scanContacts(rows *sql.Rows, fn func(Contact) error) error {
+	var current *Contact
 	for rows.Next() {
-		var contactID int
-		var firstName, lastName, phoneNumber string
-		err = rows.Scan(&contactID, &firstName, &lastName, &phoneNumber)
+		var contact Contact
+		var details detailsRow
+		var phone phoneRow
+		err := rows.Scan(&contact.ID, &contact.FirstName, &contact.LastName, &contact.Note, &contact.CreatedAt, &contact.DeletedAt, &contact.Version, &contact.Score,
+			&details.emails, &details.addresses, &details.websites, &details.tags,
+			&phone.number, &phone.e164, &phone.label, &phone.primary, &phone.extension)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		contact, exists := contactsMap[contactID]
-		if !exists {
-			contact = &Contact{
-				ID:           contactID,
-				FirstName:    firstName,
-				LastName:     lastName,
-				PhoneNumbers: []string{},
+		if current == nil || current.ID != contact.ID {
+			if current != nil {
+				if err := fn(*current); err != nil {
+					return err
+				}
+			}
+			if err := details.apply(&contact); err != nil {
+				return err
 			}
-			contactsMap[contactID] = contact
+			contact.Phones = []Phone{}
+			current = &contact
+		}
+		if phone.number.Valid {
+			current.Phones = append(current.Phones, phone.phone())
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if current != nil {
+		return fn(*current)
+	}
+	return nil
+}
+
+// preparePhones fills in the E.164 form of each phone, normalizes labels and
+// makes the first phone primary when none is. Numbers that cannot be parsed,
+// or that are the same number written twice, fail validation.
+func (r *Repository) preparePhones(phones []Phone) error {
+	verr := &ValidationError{}
+	seen := make(map[string]string, len(phones))
+	hasPrimary := false
+
+	for i := range phones {
+		p := &phones[i]
+		p.Label = normalizeLabel(p.Label, LabelMobile)
+		hasPrimary = hasPrimary || p.Primary
+
+		e164, err := NormalizePhone(p.Number, r.Region)
+		if err != nil {
+			verr.Add("phones", err.Error())
+			continue
+		}
+		if first, ok := seen[e164]; ok {
+			verr.Add("phones", "phone numbers "+first+" and "+p.Number+" are the same number")
+			continue
 		}
-		contact.PhoneNumbers = append(contact.PhoneNumbers, phoneNumber)
+		seen[e164] = p.Number
+		p.E164 = e164
+	}
+
+	if !hasPrimary && len(phones) > 0 {
+		phones[0].Primary = true
+	}
+	return verr.Err()
+}
+
+// insertContact stores a prepared contact with its phone numbers and details
+// inside tx and records its creation
+func (r *Repository) insertContact(tx *sql.Tx, contact *Contact) (int, error) {
+	// Insert into contacts table
+	var contactID, version int
+	var createdAt time.Time
+	err := tx.QueryRow(`INSERT INTO contacts (first_name, last_name, first_name_folded, last_name_folded, note) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at, version`,
+		contact.FirstName, contact.LastName, FoldText(contact.FirstName), FoldText(contact.LastName), contact.Note).Scan(&contactID, &createdAt, &version)
+	if err != nil {
+		return 0, contactError(err)
+	}
+
+	// Insert each phone number
+	if err := r.insertPhones(tx, contactID, contact.Phones); err != nil {
+		return 0, err
+	}
+
+	// Insert emails, addresses and websites
+	if err := insertDetails(tx, contactID, contact); err != nil {
+		return 0, err
 	}
 
-	// Convert map to slice
-	contacts := make([]Contact, 0, len(contactsMap))
-	for _, contact := range contactsMap {
-		contacts = append(contacts, *contact)
+	after := *contact
+	after.ID, after.CreatedAt, after.Version, after.Tags = contactID, createdAt, version, []string{}
+	if err := r.recordRevision(tx, ActionCreate, nil, &after); err != nil {
+		return 0, err
+	}
+	return contactID, nil
+}
+
+// phoneOwners returns the ID of the contact each of the E.164 numbers belongs to.
+// Numbers that belong to no contact are left out.
+func phoneOwners(db querier, e164s []string) (map[string]int, error) {
+	owners := make(map[string]int)
+	if len(e164s) == 0 {
+		return owners, nil
+	}
+
+	rows, err := db.Query(`SELECT e164, contact_id FROM phone_numbers WHERE e164 = ANY($1::text[])`, textArray(e164s))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e164 string
+		var owner int
+		if err := rows.Scan(&e164, &owner); err != nil {
+			return nil, err
+		}
+		owners[e164] = owner
+	}
+	return owners, rows.Err()
+}
+
+// insertPhones stores the prepared phones of a contact inside tx
+func (r *Repository) insertPhones(tx *sql.Tx, contactID int, phones []Phone) error {
+	for _, p := range phones {
+		_, err := tx.Exec(`INSERT INTO phone_numbers (contact_id, number, e164, label, is_primary, extension) VALUES ($1, $2, $3, $4, $5, $6)`,
+			contactID, p.Number, p.E164, p.Label, p.Primary, p.Extension)
+		if err != nil {
+			return r.phoneError(err, p.Number, p.E164)
+		}
+	}
+	return nil
+}
+
+// phoneRow scans a phone_numbers row that may be missing from a LEFT JOIN or
+// predate the e164 backfill
+type phoneRow struct {
+	number, e164, label, extension sql.NullString
+	primary                        sql.NullBool
+}
+
+func (p phoneRow) phone() Phone {
+	return Phone{
+		Number:    p.number.String,
+		E164:      p.e164.String,
+		Label:     p.label.String,
+		Primary:   p.primary.Bool,
+		Extension: p.extension.String,
+	}
+}
+
+var arrayEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// textArray formats values as a PostgreSQL array literal
+func textArray(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = `"` + arrayEscaper.Replace(v) + `"`
+	}
+	return "{" + strings.Join(quoted, ",") + "}"
+}
+
+// intArray formats ids as a PostgreSQL array literal
+func intArray(ids []int) string {
+	values := make([]string, len(ids))
+	for i, id := range ids {
+		values[i] = strconv.Itoa(id)
 	}
-	return contacts, nil
+	return "{" + strings.Join(values, ",") + "}"
 }
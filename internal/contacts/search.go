@@ -0,0 +1,94 @@
+package contacts
+
+// Search modes accepted by SearchQuery
+const (
+	// SearchModeSubstring matches the query anywhere in a name, number, email or city
+	SearchModeSubstring = "substring"
+	// SearchModeFuzzy ranks full-text and trigram matches by relevance and
+	// tolerates typos such as "Jon Deo" for "John Doe"
+	SearchModeFuzzy = "fuzzy"
+)
+
+// SearchQuery describes what SearchContacts looks for
+type SearchQuery struct {
+	Text string `json:"q"`
+	Mode string `json:"mode,omitempty"`
+}
+
+// listQuery is the part of a listing that varies between listing and the
+// search modes: a condition on contacts c, an optional relevance expression
+// and the arguments both refer to. Only live contacts are selected unless
+// trashed selects the contacts in the trash instead.
+type listQuery struct {
+	filter  string
+	score   string
+	args    []interface{}
+	trashed bool
+}
+
+// fullName is the expression indexed by contacts_full_name_folded_trgm_idx
+const fullName = `(c.first_name_folded || ' ' || c.last_name_folded)`
+
+// compile turns the search into a listQuery. In substring mode the text is
+// parsed as a query: terms may be scoped to a field as first:, last:,
+// phone: or id:, quoted as "a phrase", end in the * prefix wildcard and be
+// combined with AND, OR, NOT and parentheses; terms next to each other must
+// all match. Text using none of that, or only operators that do not make up
+// a query, is matched as a whole, as it always was. Fuzzy mode ranks the
+// text as a whole. Values are always passed as placeholders, never
+// interpolated.
+func (q SearchQuery) compile(region string) (listQuery, error) {
+	text := FoldText(q.Text)
+
+	switch q.Mode {
+	case "", SearchModeSubstring:
+		// Lex the text as typed so error positions match it; terms are folded
+		tokens, err := lexQuery(q.Text)
+		if err != nil {
+			return listQuery{}, err
+		}
+		if !plainQuery(tokens) {
+			qc := &queryCompiler{region: region}
+			filter, err := (&queryParser{tokens: tokens, qc: qc}).parse()
+			switch {
+			case err == nil:
+				if err := unknownFields(tokens); err != nil {
+					return listQuery{}, err
+				}
+				return listQuery{filter: "(" + filter + ")", args: qc.args}, nil
+			case usesSyntax(tokens):
+				return listQuery{}, err
+			}
+			// Operators that do not make up a query, such as a lone NOT or
+			// "Smith OR", are searched for as text
+		}
+		qc := &queryCompiler{region: region}
+		return listQuery{filter: qc.anywhere(text), args: qc.args}, nil
+	case SearchModeFuzzy:
+		lq := listQuery{
+			filter: `c.search_vector @@ plainto_tsquery('simple', $1)
+           OR ` + fullName + ` % $1
+           OR $1 <% ` + fullName + `
+           OR EXISTS (SELECT 1 FROM phone_numbers p WHERE p.contact_id = c.id AND p.number % $1)
+           OR EXISTS (SELECT 1 FROM emails e WHERE e.contact_id = c.id AND e.address % $1)
+           OR EXISTS (SELECT 1 FROM addresses a WHERE a.contact_id = c.id AND a.city_folded % $1)`,
+			score: `GREATEST(
+                ts_rank(c.search_vector, plainto_tsquery('simple', $1)),
+                similarity(` + fullName + `, $1),
+                word_similarity($1, ` + fullName + `),
+                COALESCE((SELECT MAX(similarity(p.number, $1)) FROM phone_numbers p WHERE p.contact_id = c.id), 0),
+                COALESCE((SELECT MAX(similarity(e.address, $1)) FROM emails e WHERE e.contact_id = c.id), 0),
+                COALESCE((SELECT MAX(similarity(a.city_folded, $1)) FROM addresses a WHERE a.contact_id = c.id), 0)
+            )::float8`,
+			args: []interface{}{text},
+		}
+		if digits := phoneSearchDigits(text, region); digits != "" {
+			lq.filter += `
+           OR EXISTS (SELECT 1 FROM phone_numbers p WHERE p.contact_id = c.id AND p.e164 LIKE '%' || $2 || '%')`
+			lq.args = append(lq.args, digits)
+		}
+		lq.filter = "(" + lq.filter + ")"
+		return lq, nil
+	}
+	return listQuery{}, &ValidationError{Fields: []FieldError{{Field: "mode", Message: "must be substring or fuzzy"}}}
+}
@@ -2,6 +2,8 @@ package contacts
 
 import (
 	"database/sql"
+	"io"
+	"time"
 )
 
 type Service struct {
@@ -14,14 +16,78 @@ func NewService(db *sql.DB) *Service {
 	}
 }
 
+// As returns a service whose changes are recorded in the contact history as
+// made by actor
+func (s *Service) As(actor string) *Service {
+	return &Service{repo: s.repo.WithActor(actor)}
+}
+
 func (s *Service) CreateContact(contact *Contact) (int, error) {
+	if err := contact.Validate(); err != nil {
+		return 0, err
+	}
 	return s.repo.CreateContact(contact)
 }
 
+func (s *Service) GetContact(id int) (*Contact, error) {
+	return s.repo.GetContact(id)
+}
+
 func (s *Service) UpdateContact(contact *Contact) error {
+	if err := contact.Validate(); err != nil {
+		return err
+	}
 	return s.repo.UpdateContact(contact)
 }
 
-func (s *Service) SearchContacts(query string) ([]Contact, error) {
-	return s.repo.SearchContacts(query)
+func (s *Service) DeleteContact(id int) error {
+	return s.repo.DeleteContact(id)
+}
+
+func (s *Service) RestoreContact(id int) error {
+	return s.repo.RestoreContact(id)
+}
+
+func (s *Service) PurgeContact(id int) error {
+	return s.repo.PurgeContact(id)
+}
+
+// PurgeTrash permanently removes the contacts that have been in the trash
+// for longer than retention and returns how many were removed
+func (s *Service) PurgeTrash(retention time.Duration) (int64, error) {
+	return s.repo.PurgeTrash(time.Now().Add(-retention))
+}
+
+func (s *Service) TrashContacts(opts ListOptions) (*Page, error) {
+	return s.repo.TrashContacts(opts)
+}
+
+func (s *Service) ListContacts(opts ListOptions) (*Page, error) {
+	return s.repo.ListContacts(opts)
+}
+
+func (s *Service) SearchContacts(query SearchQuery, opts ListOptions) (*Page, error) {
+	return s.repo.SearchContacts(query, opts)
+}
+
+// EachContact calls fn for every contact matching query, or for every contact
+// when query.Text is empty, limited to opts.Tag when it is set, in the order
+// of opts.Sort and opts.Desc. It stops at the first error fn returns.
+func (s *Service) EachContact(query SearchQuery, opts ListOptions, fn func(Contact) error) error {
+	return s.repo.EachContact(query, opts, fn)
+}
+
+// ImportVCards creates a contact for every card in r and reports the outcome
+// of each. A malformed card ends the import and is reported as failed.
+func (s *Service) ImportVCards(r io.Reader) *ImportReport {
+	cards, readErr := ReadVCards(r)
+
+	report := &ImportReport{Results: []ImportResult{}}
+	for i := range cards {
+		report.add(s.importContact(&cards[i]))
+	}
+	if readErr != nil {
+		report.add(ImportResult{Status: ImportFailed, Reason: readErr.Error()})
+	}
+	return report
 }
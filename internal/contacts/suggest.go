@@ -0,0 +1,97 @@
+package contacts
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Number of suggestions returned by default and at most
+const (
+	DefaultSuggestLimit = 10
+	MaxSuggestLimit     = 25
+)
+
+// Suggestion is a lightweight match for a name or number being typed, with
+// just enough to show it in a dropdown and fetch the contact when picked
+type Suggestion struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Number string `json:"number,omitempty"`
+}
+
+// Suggest returns up to limit live contacts whose first name, last name or
+// full name, or one of whose numbers, starts with prefix. An empty prefix
+// suggests nothing.
+func (s *Service) Suggest(prefix string, limit int) ([]Suggestion, error) {
+	switch {
+	case limit == 0:
+		limit = DefaultSuggestLimit
+	case limit < 0 || limit > MaxSuggestLimit:
+		return nil, &ValidationError{Fields: []FieldError{{Field: "limit", Message: fmt.Sprintf("must be between 1 and %d", MaxSuggestLimit)}}}
+	}
+	if strings.TrimSpace(prefix) == "" {
+		return []Suggestion{}, nil
+	}
+	return s.repo.Suggest(prefix, limit)
+}
+
+// selectSuggestions reads only the contacts row and one phone number, the
+// one matched or else the primary one, unlike listings, so suggestions stay
+// fast while typing. Every LIKE below is backed by a text_pattern_ops index.
+const selectSuggestions = `
+        SELECT c.id, c.first_name, c.last_name, COALESCE(p.number, '')
+        FROM contacts c
+        LEFT JOIN LATERAL (
+            SELECT number FROM phone_numbers WHERE contact_id = c.id ORDER BY e164 LIKE $1 DESC, is_primary DESC, id LIMIT 1
+        ) p ON TRUE
+        WHERE c.deleted_at IS NULL AND (%s)
+        ORDER BY %slower(c.first_name_folded), lower(c.last_name_folded), c.id
+        LIMIT $2`
+
+// Suggest finds contacts by the start of their names, ranked by first name,
+// then last name, then full name, or by the start of their numbers when the
+// prefix is typed as a phone number
+func (r *Repository) Suggest(prefix string, limit int) ([]Suggestion, error) {
+	var filter, rank, pattern string
+	if e164, err := phonePrefix(prefix, r.Region); err == nil && startsLikeNumber(prefix) {
+		pattern = likePrefix(e164)
+		filter = `EXISTS (SELECT 1 FROM phone_numbers pn WHERE pn.contact_id = c.id AND pn.e164 LIKE $1)`
+	} else {
+		pattern = likePrefix(strings.ToLower(FoldText(prefix)))
+		filter = `lower(c.first_name_folded) LIKE $1
+              OR lower(c.last_name_folded) LIKE $1
+              OR lower(c.first_name_folded || ' ' || c.last_name_folded) LIKE $1`
+		rank = `CASE WHEN lower(c.first_name_folded) LIKE $1 THEN 0 WHEN lower(c.last_name_folded) LIKE $1 THEN 1 ELSE 2 END, `
+	}
+
+	rows, err := r.DB.Query(fmt.Sprintf(selectSuggestions, filter, rank), pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []Suggestion{}
+	for rows.Next() {
+		var s Suggestion
+		var firstName, lastName string
+		if err := rows.Scan(&s.ID, &firstName, &lastName, &s.Number); err != nil {
+			return nil, err
+		}
+		s.Name = strings.TrimSpace(firstName + " " + lastName)
+		suggestions = append(suggestions, s)
+	}
+	return suggestions, rows.Err()
+}
+
+// startsLikeNumber reports whether a prefix is being typed as a phone number
+// rather than a name that happens to contain digits
+func startsLikeNumber(prefix string) bool {
+	s := strings.TrimSpace(FoldText(prefix))
+	return s != "" && (s[0] == '+' || s[0] == '(' || s[0] >= '0' && s[0] <= '9')
+}
+
+// likePrefix returns a LIKE pattern matching values that start with s, with
+// the LIKE wildcards in s escaped
+func likePrefix(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s) + "%"
+}
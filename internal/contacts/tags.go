@@ -0,0 +1,312 @@
+package contacts
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// maxTagLength is the size of the tags.name column
+const maxTagLength = 50
+
+var (
+	// ErrTagNotFound is returned when no tag has the requested name
+	ErrTagNotFound = errors.New("tag not found")
+
+	// ErrTagExists is returned when a tag is created or renamed to a name in use
+	ErrTagExists = errors.New("tag already exists")
+)
+
+// Tag groups contacts, such as a team, customers or suppliers. Names are
+// unique regardless of case. Contacts counts the live contacts carrying it.
+type Tag struct {
+	Name      string    `json:"name"`
+	Contacts  int       `json:"contacts"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ValidateTagName checks a tag name after trimming its surrounding spaces
+func ValidateTagName(name string) error {
+	verr := &ValidationError{}
+	checkName(verr, "name", name)
+	return verr.Err()
+}
+
+// checkName records why name cannot name a tag or group, if it cannot
+func checkName(verr *ValidationError, field, name string) {
+	switch {
+	case name == "":
+		verr.Add(field, "is required")
+	case utf8.RuneCountInString(name) > maxTagLength:
+		verr.Add(field, fmt.Sprintf("must be at most %d characters", maxTagLength))
+	case strings.Contains(name, "/"):
+		// Tags and groups are named in URL paths
+		verr.Add(field, "must not contain /")
+	}
+}
+
+// withTag limits q to the contacts carrying the named tag. The name is
+// passed as the next placeholder and matched regardless of case.
+func (q listQuery) withTag(tag string) listQuery {
+	if tag == "" {
+		return q
+	}
+	q.args = append(append([]interface{}{}, q.args...), tag)
+	q.filter = fmt.Sprintf(`%s
+           AND EXISTS (SELECT 1 FROM contact_tags ct JOIN tags t ON t.id = ct.tag_id WHERE ct.contact_id = c.id AND lower(t.name) = lower($%d))`,
+		q.filter, len(q.args))
+	return q
+}
+
+func (s *Service) Tags() ([]Tag, error) {
+	return s.repo.Tags()
+}
+
+func (s *Service) GetTag(name string) (*Tag, error) {
+	return s.repo.GetTag(strings.TrimSpace(name))
+}
+
+func (s *Service) CreateTag(name string) (*Tag, error) {
+	name = strings.TrimSpace(name)
+	if err := ValidateTagName(name); err != nil {
+		return nil, err
+	}
+	return s.repo.CreateTag(name)
+}
+
+// RenameTag renames a tag, keeping its contacts. A rename may change just
+// the case of the name.
+func (s *Service) RenameTag(name, newName string) (*Tag, error) {
+	newName = strings.TrimSpace(newName)
+	if err := ValidateTagName(newName); err != nil {
+		return nil, err
+	}
+	return s.repo.RenameTag(strings.TrimSpace(name), newName)
+}
+
+// DeleteTag removes a tag from every contact and deletes it
+func (s *Service) DeleteTag(name string) error {
+	return s.repo.DeleteTag(strings.TrimSpace(name))
+}
+
+// TagContacts adds the tag to the contacts, creating it when no tag has the
+// name yet, and returns how many contacts did not carry it before. It
+// returns ErrNotFound when any of the contacts does not exist.
+func (s *Service) TagContacts(name string, ids []int) (int64, error) {
+	name = strings.TrimSpace(name)
+	if err := ValidateTagName(name); err != nil {
+		return 0, err
+	}
+	if err := validateTagIDs(ids); err != nil {
+		return 0, err
+	}
+	return s.repo.TagContacts(name, ids)
+}
+
+// UntagContacts removes the tag from the contacts and returns how many
+// carried it. The tag itself is kept even when no contact carries it.
+func (s *Service) UntagContacts(name string, ids []int) (int64, error) {
+	if err := validateTagIDs(ids); err != nil {
+		return 0, err
+	}
+	return s.repo.UntagContacts(strings.TrimSpace(name), ids)
+}
+
+func validateTagIDs(ids []int) error {
+	if len(ids) == 0 {
+		return &ValidationError{Fields: []FieldError{{Field: "contact_ids", Message: "is required"}}}
+	}
+	return nil
+}
+
+// selectTags counts the live contacts of each tag selected by condition
+const selectTags = `
+        SELECT t.name, COUNT(c.id), t.created_at
+        FROM tags t
+        LEFT JOIN contact_tags ct ON ct.tag_id = t.id
+        LEFT JOIN contacts c ON c.id = ct.contact_id AND c.deleted_at IS NULL
+        WHERE %s
+        GROUP BY t.id
+        ORDER BY lower(t.name)`
+
+// Tags returns every tag in alphabetical order with its number of contacts
+func (r *Repository) Tags() ([]Tag, error) {
+	rows, err := r.DB.Query(fmt.Sprintf(selectTags, "TRUE"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []Tag{}
+	for rows.Next() {
+		var tag Tag
+		if err := rows.Scan(&tag.Name, &tag.Contacts, &tag.CreatedAt); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// GetTag returns the tag with the given name, or ErrTagNotFound
+func (r *Repository) GetTag(name string) (*Tag, error) {
+	var tag Tag
+	err := r.DB.QueryRow(fmt.Sprintf(selectTags, "lower(t.name) = lower($1)"), name).
+		Scan(&tag.Name, &tag.Contacts, &tag.CreatedAt)
+	if err != nil {
+		return nil, tagNotFound(err)
+	}
+	return &tag, nil
+}
+
+// CreateTag stores a new tag, or returns ErrTagExists
+func (r *Repository) CreateTag(name string) (*Tag, error) {
+	tag := &Tag{Name: name}
+	err := r.DB.QueryRow(`INSERT INTO tags (name) VALUES ($1) RETURNING created_at`, name).Scan(&tag.CreatedAt)
+	if err != nil {
+		return nil, tagError(err)
+	}
+	return tag, nil
+}
+
+// RenameTag renames the tag with the given name, and the groups limited to
+// it along with it, or returns ErrTagNotFound or ErrTagExists
+func (r *Repository) RenameTag(name, newName string) (*Tag, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE tags SET name = $2 WHERE lower(name) = lower($1)`, name, newName)
+	if err != nil {
+		return nil, tagError(err)
+	}
+	renamed, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if renamed == 0 {
+		return nil, ErrTagNotFound
+	}
+	if _, err := tx.Exec(`UPDATE contact_groups SET tag = $2 WHERE lower(tag) = lower($1)`, name, newName); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return r.GetTag(newName)
+}
+
+// DeleteTag deletes the tag with the given name; its contacts lose it by
+// ON DELETE CASCADE. It returns ErrTagNotFound when no tag has the name.
+func (r *Repository) DeleteTag(name string) error {
+	result, err := r.DB.Exec(`DELETE FROM tags WHERE lower(name) = lower($1)`, name)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrTagNotFound
+	}
+	return nil
+}
+
+// TagContacts adds the tag to the live contacts with the given IDs in one
+// transaction, creating the tag first when needed. Contacts that already
+// carry it are left as they are.
+func (r *Repository) TagContacts(name string, ids []int) (int64, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var tagID int
+	err = tx.QueryRow(`INSERT INTO tags (name) VALUES ($1) ON CONFLICT ((lower(name))) DO UPDATE SET name = tags.name RETURNING id`, name).Scan(&tagID)
+	if err != nil {
+		return 0, tagError(err)
+	}
+
+	// Lock the contacts so none of them moves to the trash while it is tagged
+	var found int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM (SELECT id FROM contacts WHERE id = ANY($1::int[]) AND deleted_at IS NULL FOR SHARE) c`, intArray(ids)).Scan(&found)
+	if err != nil {
+		return 0, err
+	}
+	if found != len(uniqueIDs(ids)) {
+		return 0, ErrNotFound
+	}
+
+	result, err := tx.Exec(`INSERT INTO contact_tags (contact_id, tag_id) SELECT DISTINCT unnest($1::int[]), $2 ON CONFLICT DO NOTHING`, intArray(ids), tagID)
+	if err != nil {
+		return 0, err
+	}
+	tagged, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return tagged, nil
+}
+
+// UntagContacts removes the tag from the contacts with the given IDs. It
+// returns ErrTagNotFound when no tag has the name.
+func (r *Repository) UntagContacts(name string, ids []int) (int64, error) {
+	var tagID int
+	if err := r.DB.QueryRow(`SELECT id FROM tags WHERE lower(name) = lower($1)`, name).Scan(&tagID); err != nil {
+		return 0, tagNotFound(err)
+	}
+
+	result, err := r.DB.Exec(`DELETE FROM contact_tags WHERE tag_id = $1 AND contact_id = ANY($2::int[])`, tagID, intArray(ids))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// uniqueIDs returns ids without repetitions, in order
+func uniqueIDs(ids []int) []int {
+	unique := make([]int, 0, len(ids))
+	seen := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// tagNotFound maps sql.ErrNoRows to ErrTagNotFound and returns other errors unchanged
+func tagNotFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrTagNotFound
+	}
+	return err
+}
+
+// tagError translates a failed tags row write into a domain error
+func tagError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case pgUniqueViolation:
+			return ErrTagExists
+		case pgStringDataRightTruncation:
+			return &ValidationError{Fields: []FieldError{{Field: "name", Message: "name is too long"}}}
+		}
+	}
+	return err
+}
@@ -0,0 +1,58 @@
+package contacts
+
+import (
+	"strings"
+	"unicode"
+)
+
+// folds maps Persian and Arabic-Indic digits to ASCII and Arabic letter
+// variants to the Persian letters users in Iran type
+var folds = map[rune]rune{
+	'۰': '0', '۱': '1', '۲': '2', '۳': '3', '۴': '4',
+	'۵': '5', '۶': '6', '۷': '7', '۸': '8', '۹': '9',
+	'٠': '0', '١': '1', '٢': '2', '٣': '3', '٤': '4',
+	'٥': '5', '٦': '6', '٧': '7', '٨': '8', '٩': '9',
+	'ي': 'ی', // Arabic yeh
+	'ى': 'ی', // Arabic alef maksura
+	'ك': 'ک', // Arabic kaf
+	'ة': 'ه', // teh marbuta
+	'ۀ': 'ه', // heh with yeh above
+	'أ': 'ا', // alef with hamza above
+	'إ': 'ا', // alef with hamza below
+	'ٱ': 'ا', // alef wasla
+}
+
+// FoldText returns the form of s used for matching: digit scripts and Arabic
+// letter variants are folded, zero-width characters, tatweel and harakat are
+// removed and runs of whitespace collapse to one space. The result is only
+// ever stored next to the original text, which is kept for display.
+func FoldText(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+
+	space := false
+	for _, r := range s {
+		if folded, ok := folds[r]; ok {
+			r = folded
+		}
+
+		switch {
+		case r == '\u200c', r == '\u200b', r == '\u200d', r == '\ufeff':
+			// zero-width non-joiner, space, joiner and BOM
+			continue
+		case r == '\u0640', r >= '\u064b' && r <= '\u0652':
+			// tatweel and harakat
+			continue
+		case unicode.IsSpace(r):
+			space = b.Len() > 0
+			continue
+		}
+
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,258 @@
+package contacts
+
+import (
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/emersion/go-vcard"
+)
+
+// vCard versions accepted by WriteVCards; both are read by ReadVCards
+const (
+	VCardVersion3 = "3.0"
+	VCardVersion4 = "4.0"
+)
+
+// VCardMIMEType is the content type of vCard files
+const VCardMIMEType = "text/vcard"
+
+// ErrInvalidVCardVersion is returned when a vCard version other than 3.0 or 4.0 is requested
+var ErrInvalidVCardVersion = errors.New("vCard version must be 3.0 or 4.0")
+
+// vCard TYPE values for the predefined phone labels
+var phoneTypes = map[string]string{
+	LabelMobile: vcard.TypeCell,
+	LabelHome:   vcard.TypeHome,
+	LabelWork:   vcard.TypeWork,
+	LabelFax:    vcard.TypeFax,
+	LabelOther:  vcard.TypeVoice,
+}
+
+// WriteVCards writes the contacts to w as vCards of the given version
+func WriteVCards(w io.Writer, version string, contacts ...Contact) error {
+	if version != VCardVersion3 && version != VCardVersion4 {
+		return ErrInvalidVCardVersion
+	}
+
+	enc := vcard.NewEncoder(w)
+	for _, contact := range contacts {
+		if err := enc.Encode(toVCard(contact, version)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadVCards reads every card in r. Cards read before a malformed card are
+// returned along with the error.
+func ReadVCards(r io.Reader) ([]Contact, error) {
+	dec := vcard.NewDecoder(r)
+
+	contacts := []Contact{}
+	for {
+		card, err := dec.Decode()
+		if err == io.EOF {
+			return contacts, nil
+		}
+		if err != nil {
+			return contacts, err
+		}
+		contacts = append(contacts, fromVCard(card))
+	}
+}
+
+func toVCard(contact Contact, version string) vcard.Card {
+	card := vcard.Card{}
+	card.SetValue(vcard.FieldVersion, version)
+	card.SetValue(vcard.FieldFormattedName, strings.TrimSpace(contact.FirstName+" "+contact.LastName))
+	card.SetName(&vcard.Name{FamilyName: vcardComponent(contact.LastName), GivenName: vcardComponent(contact.FirstName)})
+
+	for _, p := range contact.Phones {
+		field := &vcard.Field{Value: p.Number, Params: vcard.Params{}}
+		if p.E164 != "" {
+			field.Value = p.E164
+		}
+		if version == VCardVersion4 {
+			field.Params.Set(vcard.ParamValue, "uri")
+			field.Value = "tel:" + field.Value
+			if p.Extension != "" {
+				field.Value += ";ext=" + p.Extension
+			}
+		} else if p.Extension != "" {
+			field.Value += " x" + p.Extension
+		}
+
+		setVCardType(field, p.Label, phoneTypes)
+		if p.Primary {
+			setVCardPreferred(field, version)
+		}
+		card.Add(vcard.FieldTelephone, field)
+	}
+
+	for _, e := range contact.Emails {
+		field := &vcard.Field{Value: e.Address, Params: vcard.Params{}}
+		setVCardType(field, e.Label, nil)
+		card.Add(vcard.FieldEmail, field)
+	}
+
+	for _, a := range contact.Addresses {
+		address := &vcard.Address{
+			Field:         &vcard.Field{Params: vcard.Params{}},
+			StreetAddress: vcardComponent(a.Street),
+			Locality:      vcardComponent(a.City),
+			Region:        vcardComponent(a.Region),
+			PostalCode:    vcardComponent(a.PostalCode),
+			Country:       vcardComponent(a.Country),
+		}
+		setVCardType(address.Field, a.Label, nil)
+		card.AddAddress(address)
+	}
+
+	for _, w := range contact.Websites {
+		field := &vcard.Field{Value: w.URL, Params: vcard.Params{}}
+		setVCardType(field, w.Label, nil)
+		card.Add(vcard.FieldURL, field)
+	}
+
+	if contact.Note != "" {
+		card.SetValue(vcard.FieldNote, contact.Note)
+	}
+	return card
+}
+
+func fromVCard(card vcard.Card) Contact {
+	contact := Contact{Note: card.Value(vcard.FieldNote)}
+
+	if name := card.Name(); name != nil && (name.GivenName != "" || name.FamilyName != "") {
+		contact.FirstName = strings.TrimSpace(name.GivenName)
+		contact.LastName = strings.TrimSpace(name.FamilyName)
+	} else {
+		// Cards without N only have a formatted name; its last word is taken as the last name
+		switch fn := strings.Fields(card.Value(vcard.FieldFormattedName)); len(fn) {
+		case 0:
+		case 1:
+			contact.FirstName = fn[0]
+		default:
+			contact.FirstName = strings.Join(fn[:len(fn)-1], " ")
+			contact.LastName = fn[len(fn)-1]
+		}
+	}
+
+	for _, field := range card[vcard.FieldTelephone] {
+		number, ext := splitTel(field.Value)
+		contact.Phones = append(contact.Phones, Phone{
+			Number:    number,
+			Extension: ext,
+			Label:     vcardLabel(field, phoneTypes),
+			Primary:   vcardPreferred(field),
+		})
+	}
+	// A card may mark several numbers as preferred; only the first stays primary
+	primary := false
+	for i := range contact.Phones {
+		contact.Phones[i].Primary = contact.Phones[i].Primary && !primary
+		primary = primary || contact.Phones[i].Primary
+	}
+
+	for _, field := range card[vcard.FieldEmail] {
+		contact.Emails = append(contact.Emails, Email{Address: strings.TrimSpace(field.Value), Label: vcardLabel(field, nil)})
+	}
+
+	for _, a := range card.Addresses() {
+		street := a.StreetAddress
+		if a.ExtendedAddress != "" {
+			street = strings.TrimSpace(street + " " + a.ExtendedAddress)
+		}
+		contact.Addresses = append(contact.Addresses, Address{
+			Label:      vcardLabel(a.Field, nil),
+			Street:     street,
+			City:       a.Locality,
+			Region:     a.Region,
+			PostalCode: a.PostalCode,
+			Country:    a.Country,
+		})
+	}
+
+	for _, field := range card[vcard.FieldURL] {
+		contact.Websites = append(contact.Websites, Website{URL: strings.TrimSpace(field.Value), Label: vcardLabel(field, nil)})
+	}
+	return contact
+}
+
+// splitTel separates the number from its extension in "tel:+98...;ext=12",
+// "+98... x12" and "+98... ext. 12"
+func splitTel(value string) (number, ext string) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "tel:")
+	if number, params, ok := strings.Cut(value, ";"); ok {
+		for _, param := range strings.Split(params, ";") {
+			if v, ok := strings.CutPrefix(param, "ext="); ok {
+				ext = v
+			}
+		}
+		return number, ext
+	}
+
+	lower := strings.ToLower(value)
+	for _, sep := range []string{"ext.", "ext", "x"} {
+		if i := strings.LastIndex(lower, sep); i > 0 {
+			return strings.TrimSpace(value[:i]), strings.TrimSpace(value[i+len(sep):])
+		}
+	}
+	return value, ""
+}
+
+// setVCardType writes label as the TYPE parameter, mapped through types when
+// it is a predefined label
+func setVCardType(field *vcard.Field, label string, types map[string]string) {
+	if t, ok := types[label]; ok {
+		label = t
+	}
+	if label != "" && label != LabelOther {
+		field.Params.Add(vcard.ParamType, label)
+	}
+}
+
+func setVCardPreferred(field *vcard.Field, version string) {
+	if version == VCardVersion4 {
+		field.Params.Set(vcard.ParamPreferred, "1")
+	} else {
+		field.Params.Add(vcard.ParamType, "pref")
+	}
+}
+
+// vcardLabel returns the first TYPE of field that is not a generic vCard
+// type, mapped back through types to a predefined label. A phone that is
+// only typed as voice becomes an other number.
+func vcardLabel(field *vcard.Field, types map[string]string) string {
+	voice := false
+	for _, t := range field.Params.Types() {
+		switch t {
+		case "pref", "internet", "x400", "postal", "parcel", "dom", "intl":
+			continue
+		case vcard.TypeVoice:
+			voice = true
+			continue
+		}
+		for label, vt := range types {
+			if vt == t {
+				return label
+			}
+		}
+		return t
+	}
+	if voice && types != nil {
+		return LabelOther
+	}
+	return ""
+}
+
+func vcardPreferred(field *vcard.Field) bool {
+	return field.Params.HasType("pref") || field.Params.Get(vcard.ParamPreferred) != ""
+}
+
+// vcardComponent replaces semicolons, which separate the components of N and
+// ADR and are not escaped by the encoder
+func vcardComponent(s string) string {
+	return strings.ReplaceAll(s, ";", ",")
+}
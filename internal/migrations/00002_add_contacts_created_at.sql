@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE contacts ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+-- Keyset pagination compares (sort column, id) pairs, which requires non-null names
+UPDATE contacts SET first_name = '' WHERE first_name IS NULL;
+UPDATE contacts SET last_name = '' WHERE last_name IS NULL;
+ALTER TABLE contacts ALTER COLUMN first_name SET DEFAULT '', ALTER COLUMN first_name SET NOT NULL;
+ALTER TABLE contacts ALTER COLUMN last_name SET DEFAULT '', ALTER COLUMN last_name SET NOT NULL;
+
+CREATE INDEX contacts_first_name_id_idx ON contacts (first_name, id);
+CREATE INDEX contacts_last_name_id_idx ON contacts (last_name, id);
+CREATE INDEX contacts_created_at_id_idx ON contacts (created_at, id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX contacts_created_at_id_idx;
+DROP INDEX contacts_last_name_id_idx;
+DROP INDEX contacts_first_name_id_idx;
+ALTER TABLE contacts ALTER COLUMN last_name DROP NOT NULL, ALTER COLUMN last_name DROP DEFAULT;
+ALTER TABLE contacts ALTER COLUMN first_name DROP NOT NULL, ALTER COLUMN first_name DROP DEFAULT;
+ALTER TABLE contacts DROP COLUMN created_at;
+-- +goose StatementEnd
@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+ALTER TABLE contacts ADD COLUMN search_vector tsvector
+    GENERATED ALWAYS AS (to_tsvector('simple'::regconfig, first_name || ' ' || last_name)) STORED;
+
+CREATE INDEX contacts_search_vector_idx ON contacts USING GIN (search_vector);
+CREATE INDEX contacts_first_name_trgm_idx ON contacts USING GIN (first_name gin_trgm_ops);
+CREATE INDEX contacts_last_name_trgm_idx ON contacts USING GIN (last_name gin_trgm_ops);
+CREATE INDEX contacts_full_name_trgm_idx ON contacts USING GIN ((first_name || ' ' || last_name) gin_trgm_ops);
+CREATE INDEX phone_numbers_number_trgm_idx ON phone_numbers USING GIN (number gin_trgm_ops);
+CREATE INDEX phone_numbers_contact_id_idx ON phone_numbers (contact_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX phone_numbers_contact_id_idx;
+DROP INDEX phone_numbers_number_trgm_idx;
+DROP INDEX contacts_full_name_trgm_idx;
+DROP INDEX contacts_last_name_trgm_idx;
+DROP INDEX contacts_first_name_trgm_idx;
+DROP INDEX contacts_search_vector_idx;
+ALTER TABLE contacts DROP COLUMN search_vector;
+-- +goose StatementEnd
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+-- number keeps the form the user typed; e164 holds the canonical form and
+-- takes over the UNIQUE constraint once it is backfilled by 00005
+ALTER TABLE phone_numbers ALTER COLUMN number TYPE VARCHAR(32);
+ALTER TABLE phone_numbers ADD COLUMN e164 VARCHAR(16);
+ALTER TABLE phone_numbers DROP CONSTRAINT phone_numbers_number_key;
+
+CREATE INDEX phone_numbers_e164_trgm_idx ON phone_numbers USING GIN (e164 gin_trgm_ops);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX phone_numbers_e164_trgm_idx;
+ALTER TABLE phone_numbers ADD CONSTRAINT phone_numbers_number_key UNIQUE (number);
+ALTER TABLE phone_numbers DROP COLUMN e164;
+ALTER TABLE phone_numbers ALTER COLUMN number TYPE VARCHAR(15);
+-- +goose StatementEnd
@@ -0,0 +1,72 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"phonebook/internal/contacts"
+
+	"github.com/pressly/goose/v3"
+	"github.com/rs/zerolog/log"
+)
+
+func init() {
+	goose.AddMigrationContext(upBackfillPhoneNumbersE164, downBackfillPhoneNumbersE164)
+}
+
+// upBackfillPhoneNumbersE164 normalizes every stored number with the same
+// parser the repository uses, then makes e164 unique. Numbers that cannot be
+// parsed, and later duplicates of an already normalized number, keep a NULL
+// e164 and are logged so they can be fixed by hand.
+func upBackfillPhoneNumbersE164(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, `SELECT id, number FROM phone_numbers ORDER BY id`)
+	if err != nil {
+		return err
+	}
+
+	type phone struct {
+		id     int
+		number string
+	}
+	var phones []phone
+	for rows.Next() {
+		var p phone
+		if err := rows.Scan(&p.id, &p.number); err != nil {
+			rows.Close()
+			return err
+		}
+		phones = append(phones, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	seen := make(map[string]int, len(phones))
+	for _, p := range phones {
+		e164, err := contacts.NormalizePhone(p.number, contacts.DefaultRegion)
+		if err != nil {
+			log.Warn().Err(err).Int("phone_id", p.id).Msg("Leaving phone number without E.164 form")
+			continue
+		}
+		if firstID, ok := seen[e164]; ok {
+			log.Warn().Int("phone_id", p.id).Int("duplicate_of", firstID).Str("e164", e164).Msg("Leaving duplicate phone number without E.164 form")
+			continue
+		}
+		seen[e164] = p.id
+
+		if _, err := tx.ExecContext(ctx, `UPDATE phone_numbers SET e164 = $1 WHERE id = $2`, e164, p.id); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `ALTER TABLE phone_numbers ADD CONSTRAINT phone_numbers_e164_key UNIQUE (e164)`)
+	return err
+}
+
+func downBackfillPhoneNumbersE164(ctx context.Context, tx *sql.Tx) error {
+	if _, err := tx.ExecContext(ctx, `ALTER TABLE phone_numbers DROP CONSTRAINT phone_numbers_e164_key`); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `UPDATE phone_numbers SET e164 = NULL`)
+	return err
+}
@@ -0,0 +1,39 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Folded names are what searches match against; first_name and last_name keep
+-- the text as typed for display. Backfilled by 00007.
+ALTER TABLE contacts
+    ADD COLUMN first_name_folded VARCHAR(50) NOT NULL DEFAULT '',
+    ADD COLUMN last_name_folded VARCHAR(50) NOT NULL DEFAULT '';
+
+DROP INDEX contacts_full_name_trgm_idx;
+DROP INDEX contacts_last_name_trgm_idx;
+DROP INDEX contacts_first_name_trgm_idx;
+ALTER TABLE contacts DROP COLUMN search_vector;
+
+ALTER TABLE contacts ADD COLUMN search_vector tsvector
+    GENERATED ALWAYS AS (to_tsvector('simple'::regconfig, first_name_folded || ' ' || last_name_folded)) STORED;
+
+CREATE INDEX contacts_search_vector_idx ON contacts USING GIN (search_vector);
+CREATE INDEX contacts_first_name_folded_trgm_idx ON contacts USING GIN (first_name_folded gin_trgm_ops);
+CREATE INDEX contacts_last_name_folded_trgm_idx ON contacts USING GIN (last_name_folded gin_trgm_ops);
+CREATE INDEX contacts_full_name_folded_trgm_idx ON contacts USING GIN ((first_name_folded || ' ' || last_name_folded) gin_trgm_ops);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX contacts_full_name_folded_trgm_idx;
+DROP INDEX contacts_last_name_folded_trgm_idx;
+DROP INDEX contacts_first_name_folded_trgm_idx;
+ALTER TABLE contacts DROP COLUMN search_vector;
+
+ALTER TABLE contacts ADD COLUMN search_vector tsvector
+    GENERATED ALWAYS AS (to_tsvector('simple'::regconfig, first_name || ' ' || last_name)) STORED;
+
+CREATE INDEX contacts_search_vector_idx ON contacts USING GIN (search_vector);
+CREATE INDEX contacts_first_name_trgm_idx ON contacts USING GIN (first_name gin_trgm_ops);
+CREATE INDEX contacts_last_name_trgm_idx ON contacts USING GIN (last_name gin_trgm_ops);
+CREATE INDEX contacts_full_name_trgm_idx ON contacts USING GIN ((first_name || ' ' || last_name) gin_trgm_ops);
+
+ALTER TABLE contacts DROP COLUMN last_name_folded, DROP COLUMN first_name_folded;
+-- +goose StatementEnd
@@ -0,0 +1,54 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"phonebook/internal/contacts"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigrationContext(upBackfillContactsFoldedNames, downBackfillContactsFoldedNames)
+}
+
+// upBackfillContactsFoldedNames folds existing names with the same function
+// the repository applies on write
+func upBackfillContactsFoldedNames(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, `SELECT id, first_name, last_name FROM contacts ORDER BY id`)
+	if err != nil {
+		return err
+	}
+
+	type name struct {
+		id          int
+		first, last string
+	}
+	var names []name
+	for rows.Next() {
+		var n name
+		if err := rows.Scan(&n.id, &n.first, &n.last); err != nil {
+			rows.Close()
+			return err
+		}
+		names = append(names, n)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, n := range names {
+		_, err := tx.ExecContext(ctx, `UPDATE contacts SET first_name_folded = $1, last_name_folded = $2 WHERE id = $3`,
+			contacts.FoldText(n.first), contacts.FoldText(n.last), n.id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func downBackfillContactsFoldedNames(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `UPDATE contacts SET first_name_folded = '', last_name_folded = ''`)
+	return err
+}
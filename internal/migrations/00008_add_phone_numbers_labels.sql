@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE phone_numbers
+    ADD COLUMN label VARCHAR(32) NOT NULL DEFAULT 'mobile',
+    ADD COLUMN is_primary BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN extension VARCHAR(10) NOT NULL DEFAULT '';
+
+-- Existing numbers become mobile numbers and each contact's oldest one is primary
+UPDATE phone_numbers p SET is_primary = TRUE
+WHERE p.id = (SELECT MIN(id) FROM phone_numbers WHERE contact_id = p.contact_id);
+
+CREATE UNIQUE INDEX phone_numbers_one_primary_idx ON phone_numbers (contact_id) WHERE is_primary;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX phone_numbers_one_primary_idx;
+ALTER TABLE phone_numbers DROP COLUMN extension, DROP COLUMN is_primary, DROP COLUMN label;
+-- +goose StatementEnd
@@ -0,0 +1,41 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE emails (
+    id SERIAL PRIMARY KEY,
+    contact_id INT NOT NULL REFERENCES contacts(id) ON DELETE CASCADE,
+    address VARCHAR(254) NOT NULL,
+    label VARCHAR(32) NOT NULL DEFAULT 'other'
+);
+
+CREATE TABLE addresses (
+    id SERIAL PRIMARY KEY,
+    contact_id INT NOT NULL REFERENCES contacts(id) ON DELETE CASCADE,
+    label VARCHAR(32) NOT NULL DEFAULT 'other',
+    street VARCHAR(200) NOT NULL DEFAULT '',
+    city VARCHAR(100) NOT NULL DEFAULT '',
+    city_folded VARCHAR(100) NOT NULL DEFAULT '',
+    region VARCHAR(100) NOT NULL DEFAULT '',
+    postal_code VARCHAR(20) NOT NULL DEFAULT '',
+    country VARCHAR(100) NOT NULL DEFAULT ''
+);
+
+CREATE TABLE websites (
+    id SERIAL PRIMARY KEY,
+    contact_id INT NOT NULL REFERENCES contacts(id) ON DELETE CASCADE,
+    url VARCHAR(2048) NOT NULL,
+    label VARCHAR(32) NOT NULL DEFAULT 'other'
+);
+
+CREATE INDEX emails_contact_id_idx ON emails (contact_id);
+CREATE INDEX emails_address_trgm_idx ON emails USING GIN (address gin_trgm_ops);
+CREATE INDEX addresses_contact_id_idx ON addresses (contact_id);
+CREATE INDEX addresses_city_folded_trgm_idx ON addresses USING GIN (city_folded gin_trgm_ops);
+CREATE INDEX websites_contact_id_idx ON websites (contact_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE websites;
+DROP TABLE addresses;
+DROP TABLE emails;
+-- +goose StatementEnd
@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Duplicate detection matches numbers on their last seven digits
+CREATE INDEX phone_numbers_e164_suffix_idx ON phone_numbers (right(e164, 7));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX phone_numbers_e164_suffix_idx;
+-- +goose StatementEnd
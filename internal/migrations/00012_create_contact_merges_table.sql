@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Every merge keeps the contacts as they were before it so it can be audited.
+-- survivor_id has no foreign key: the record outlives the surviving contact.
+CREATE TABLE contact_merges (
+    id SERIAL PRIMARY KEY,
+    survivor_id INT NOT NULL,
+    merged_ids INT[] NOT NULL,
+    rules JSONB NOT NULL,
+    contacts JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX contact_merges_survivor_id_idx ON contact_merges (survivor_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE contact_merges;
+-- +goose StatementEnd
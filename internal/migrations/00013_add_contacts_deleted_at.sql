@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Deleted contacts stay in the trash, keeping their phone numbers, until purged
+ALTER TABLE contacts ADD COLUMN deleted_at TIMESTAMPTZ;
+CREATE INDEX contacts_deleted_at_idx ON contacts (deleted_at) WHERE deleted_at IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX contacts_deleted_at_idx;
+ALTER TABLE contacts DROP COLUMN deleted_at;
+-- +goose StatementEnd
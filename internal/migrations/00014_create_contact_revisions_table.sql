@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Revisions have no foreign key to contacts so the history of purged and
+-- merged contacts is kept. They are written once and never changed.
+CREATE TABLE contact_revisions (
+    id BIGSERIAL PRIMARY KEY,
+    contact_id INT NOT NULL,
+    revision INT NOT NULL,
+    action TEXT NOT NULL,
+    actor TEXT NOT NULL,
+    before JSONB,
+    after JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (contact_id, revision)
+);
+
+CREATE FUNCTION contact_revisions_immutable() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'contact revisions cannot be changed';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER contact_revisions_immutable
+    BEFORE UPDATE OR DELETE ON contact_revisions
+    FOR EACH ROW EXECUTE FUNCTION contact_revisions_immutable();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE contact_revisions;
+DROP FUNCTION contact_revisions_immutable();
+-- +goose StatementEnd
@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- The version of a contact is incremented on every change, so concurrent edits can be detected
+ALTER TABLE contacts ADD COLUMN version INT NOT NULL DEFAULT 1;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE contacts DROP COLUMN version;
+-- +goose StatementEnd
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Tag names are unique regardless of case and keep the case they were created with
+CREATE TABLE tags (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(50) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE UNIQUE INDEX tags_name_lower_idx ON tags (lower(name));
+
+CREATE TABLE contact_tags (
+    contact_id INT NOT NULL REFERENCES contacts(id) ON DELETE CASCADE,
+    tag_id INT NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    PRIMARY KEY (contact_id, tag_id)
+);
+CREATE INDEX contact_tags_tag_id_idx ON contact_tags (tag_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE contact_tags;
+DROP TABLE tags;
+-- +goose StatementEnd
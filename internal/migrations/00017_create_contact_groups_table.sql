@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+-- A group is a saved search whose contacts are found again every time it is
+-- read. query holds the search and tag the name of a tag its contacts carry.
+CREATE TABLE contact_groups (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(50) NOT NULL,
+    query JSONB NOT NULL,
+    tag VARCHAR(50) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE UNIQUE INDEX contact_groups_name_lower_idx ON contact_groups (lower(name));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE contact_groups;
+-- +goose StatementEnd
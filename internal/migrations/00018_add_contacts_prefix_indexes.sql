@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Suggestions match the start of folded names and of E.164 numbers while the
+-- user types. text_pattern_ops lets LIKE 'prefix%' use a btree whatever the
+-- database collation; trashed contacts are never suggested.
+CREATE INDEX contacts_first_name_prefix_idx ON contacts (lower(first_name_folded) text_pattern_ops) WHERE deleted_at IS NULL;
+CREATE INDEX contacts_last_name_prefix_idx ON contacts (lower(last_name_folded) text_pattern_ops) WHERE deleted_at IS NULL;
+CREATE INDEX contacts_full_name_prefix_idx ON contacts (lower(first_name_folded || ' ' || last_name_folded) text_pattern_ops) WHERE deleted_at IS NULL;
+CREATE INDEX phone_numbers_e164_prefix_idx ON phone_numbers (e164 text_pattern_ops);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX phone_numbers_e164_prefix_idx;
+DROP INDEX contacts_full_name_prefix_idx;
+DROP INDEX contacts_last_name_prefix_idx;
+DROP INDEX contacts_first_name_prefix_idx;
+-- +goose StatementEnd
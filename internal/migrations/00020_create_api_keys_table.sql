@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+-- API keys let scripts and services call the API without logging in.
+-- key_hash is a SHA-256 hash of the key, which is shown once when created;
+-- prefix holds its first characters so it can be recognized in listings.
+CREATE TABLE api_keys (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(50) NOT NULL,
+    prefix VARCHAR(16) NOT NULL,
+    key_hash CHAR(64) NOT NULL UNIQUE,
+    scope VARCHAR(20) NOT NULL CHECK (scope IN ('read-only', 'read-write', 'import')),
+    created_by VARCHAR(50) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+);
+-- Names are recorded as the actor of changes, so live keys cannot share one
+CREATE UNIQUE INDEX api_keys_name_lower_idx ON api_keys (lower(name)) WHERE revoked_at IS NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE api_keys;
+-- +goose StatementEnd
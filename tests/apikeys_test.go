@@ -0,0 +1,190 @@
+package tests
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"phonebook/internal/auth"
+	"phonebook/internal/contacts"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var apiKeyColumns = []string{"id", "name", "prefix", "scope", "created_by", "created_at", "last_used_at", "revoked_at"}
+
+// expectAPIKey expects a live API key to be looked up by its hash, found
+// with scope or not found when scope is empty. A key that was just used is
+// not marked as used again, so it is read instead.
+func expectAPIKey(key, scope string) {
+	sum := sha256.Sum256([]byte(key))
+	rows := sqlmock.NewRows(apiKeyColumns)
+	if scope != "" {
+		rows.AddRow(5, "crm", key[:10], scope, "alice", time.Now(), time.Now(), nil)
+	}
+	mock.ExpectQuery(`UPDATE api_keys SET last_used_at = now\(\) WHERE key_hash = \$1 AND revoked_at IS NULL AND \(last_used_at IS NULL OR last_used_at < now\(\) - interval '1 minute'\) RETURNING id, name, prefix, scope, created_by, created_at, last_used_at, revoked_at`).
+		WithArgs(hex.EncodeToString(sum[:])).
+		WillReturnRows(sqlmock.NewRows(apiKeyColumns))
+	mock.ExpectQuery(`SELECT id, name, prefix, scope, created_by, created_at, last_used_at, revoked_at FROM api_keys WHERE key_hash = \$1 AND revoked_at IS NULL`).
+		WithArgs(hex.EncodeToString(sum[:])).
+		WillReturnRows(rows)
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	const key = "pbk_0123456789abcdefghijklmnopqrstuvwxyzABCDE"
+
+	tests := []struct {
+		name    string
+		scope   string
+		method  string
+		path    string
+		headers map[string]string
+		status  int
+	}{
+		{"read-only reads", auth.ScopeReadOnly, http.MethodGet, "/contacts/42", map[string]string{"X-API-Key": key}, http.StatusNotFound},
+		{"as a bearer token", auth.ScopeReadOnly, http.MethodGet, "/contacts/42", map[string]string{"Authorization": "Bearer " + key}, http.StatusNotFound},
+		{"read-only writes", auth.ScopeReadOnly, http.MethodDelete, "/contacts/42", map[string]string{"X-API-Key": key}, http.StatusForbidden},
+		{"import reads", auth.ScopeImport, http.MethodGet, "/contacts/42", map[string]string{"X-API-Key": key}, http.StatusForbidden},
+		{"read-write manages keys", auth.ScopeReadWrite, http.MethodGet, "/api-keys", map[string]string{"X-API-Key": key}, http.StatusForbidden},
+		{"revoked", "", http.MethodGet, "/contacts/42", map[string]string{"X-API-Key": key}, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		expectAPIKey(key, tt.scope)
+		if tt.status == http.StatusNotFound {
+			mock.ExpectQuery(`FROM contacts c WHERE c\.id = \$1`).
+				WithArgs(42).
+				WillReturnRows(sqlmock.NewRows(contactColumns))
+		}
+
+		// The API key replaces the access token of testUser
+		headers := map[string]string{"Authorization": ""}
+		for name, value := range tt.headers {
+			headers[name] = value
+		}
+		w := serveRequestWithHeaders(t, tt.method, tt.path, "", headers)
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.name, tt.status, w.Code, w.Body.String())
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestAPIKeyRecordsActor(t *testing.T) {
+	const key = "pbk_0123456789abcdefghijklmnopqrstuvwxyzABCDE"
+
+	expectAPIKey(key, auth.ScopeReadWrite)
+	mock.ExpectBegin()
+	expectLock(1, false)
+	mock.ExpectQuery(`UPDATE contacts SET deleted_at = now\(\) WHERE id = \$1 RETURNING deleted_at`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"deleted_at"}).AddRow(time.Now()))
+	expectRevisionBy(1, contacts.ActionDelete, "api-key:crm")
+	mock.ExpectCommit()
+
+	w := serveRequestWithHeaders(t, http.MethodDelete, "/contacts/1", "", map[string]string{"Authorization": "", "X-API-Key": key})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestAPIKeyMarkedAsUsed(t *testing.T) {
+	const key = "pbk_0123456789abcdefghijklmnopqrstuvwxyzABCDE"
+	sum := sha256.Sum256([]byte(key))
+
+	// A key not used in the last minute is found by marking it as used
+	mock.ExpectQuery(`UPDATE api_keys SET last_used_at = now\(\) WHERE key_hash = \$1 .+ RETURNING`).
+		WithArgs(hex.EncodeToString(sum[:])).
+		WillReturnRows(sqlmock.NewRows(apiKeyColumns).AddRow(5, "crm", key[:10], auth.ScopeReadOnly, "alice", time.Now(), time.Now(), nil))
+	mock.ExpectQuery(`FROM contacts c WHERE c\.id = \$1`).
+		WithArgs(42).
+		WillReturnRows(sqlmock.NewRows(contactColumns))
+
+	w := serveRequestWithHeaders(t, http.MethodGet, "/contacts/42", "", map[string]string{"Authorization": "", "X-API-Key": key})
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCreateAPIKeyHandler(t *testing.T) {
+	admin := map[string]string{"Authorization": bearer(t, &auth.User{ID: 2, Username: "alice", Admin: true})}
+
+	// Only the hash of the key is stored
+	mock.ExpectQuery(`INSERT INTO api_keys \(name, prefix, key_hash, scope, created_by\) VALUES \(\$1, \$2, \$3, \$4, \$5\) RETURNING id, created_at`).
+		WithArgs("crm", sqlmock.AnyArg(), sqlmock.AnyArg(), auth.ScopeReadOnly, "alice").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(5, time.Now()))
+
+	w := serveRequestWithHeaders(t, http.MethodPost, "/api-keys", `{"name":"crm","scope":"read-only"}`, admin)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Key    string      `json:"key"`
+		APIKey auth.APIKey `json:"api_key"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if !strings.HasPrefix(resp.Key, auth.APIKeyPrefix) || !strings.HasPrefix(resp.Key, resp.APIKey.Prefix) || resp.APIKey.ID != 5 {
+		t.Fatalf("expected the new key and its prefix, got %+v", resp)
+	}
+
+	w = serveRequestWithHeaders(t, http.MethodPost, "/api-keys", `{"name":"crm","scope":"admin"}`, admin)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status 422 for an unknown scope, got %d", w.Code)
+	}
+
+	w = serveRequest(t, http.MethodPost, "/api-keys", `{"name":"crm","scope":"read-only"}`)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected status 403 for a user, got %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRevokeAPIKeyHandler(t *testing.T) {
+	admin := map[string]string{"Authorization": bearer(t, &auth.User{ID: 2, Username: "alice", Admin: true})}
+
+	now := time.Now()
+	mock.ExpectQuery(`UPDATE api_keys SET revoked_at = COALESCE\(revoked_at, now\(\)\) WHERE id = \$1 RETURNING`).
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows(apiKeyColumns).AddRow(5, "crm", "pbk_012345", auth.ScopeReadOnly, "alice", now, now, now))
+
+	w := serveRequestWithHeaders(t, http.MethodDelete, "/api-keys/5", "", admin)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var key auth.APIKey
+	if err := json.NewDecoder(w.Body).Decode(&key); err != nil || key.RevokedAt == nil {
+		t.Fatalf("expected the revoked key, got %+v (%v)", key, err)
+	}
+
+	mock.ExpectQuery(`UPDATE api_keys SET revoked_at`).
+		WithArgs(6).
+		WillReturnRows(sqlmock.NewRows(apiKeyColumns))
+
+	w = serveRequestWithHeaders(t, http.MethodDelete, "/api-keys/6", "", admin)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
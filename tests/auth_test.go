@@ -0,0 +1,208 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"phonebook/internal/auth"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgx/v5/pgconn"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var userColumns = []string{"id", "username", "password_hash", "is_admin", "created_at"}
+
+func TestLoginHandler(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		found    bool
+		status   int
+	}{
+		{"valid", "correct horse", true, http.StatusOK},
+		{"wrong password", "battery staple", true, http.StatusUnauthorized},
+		{"unknown user", "correct horse", false, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		rows := sqlmock.NewRows(userColumns)
+		if tt.found {
+			rows.AddRow(2, "alice", string(hash), false, time.Now())
+		}
+		mock.ExpectQuery(`SELECT id, username, password_hash, is_admin, created_at FROM users WHERE lower\(username\) = lower\(\$1\)`).
+			WithArgs("Alice").
+			WillReturnRows(rows)
+
+		body := `{"username":"Alice","password":"` + tt.password + `"}`
+		w := serveRequestWithHeaders(t, http.MethodPost, "/auth/login", body, map[string]string{"Authorization": ""})
+		if w.Code != tt.status {
+			t.Fatalf("%s: expected status %d, got %d: %s", tt.name, tt.status, w.Code, w.Body.String())
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+
+		var tokens auth.TokenPair
+		if err := json.NewDecoder(w.Body).Decode(&tokens); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+		claims, err := testSigner.Verify(tokens.AccessToken, auth.AccessToken)
+		if err != nil || claims.Subject != "alice" || claims.UserID != 2 {
+			t.Fatalf("expected an access token for alice, got %+v (%v)", claims, err)
+		}
+		if _, err := testSigner.Verify(tokens.RefreshToken, auth.RefreshToken); err != nil {
+			t.Fatalf("expected a refresh token, got %v", err)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRefreshHandler(t *testing.T) {
+	tokens, err := testSigner.Issue(&auth.User{ID: 2, Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Admin rights are read again from the user
+	mock.ExpectQuery(`SELECT id, username, is_admin, created_at FROM users WHERE id = \$1`).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "is_admin", "created_at"}).AddRow(2, "alice", true, time.Now()))
+
+	w := serveRequest(t, http.MethodPost, "/auth/refresh", `{"refresh_token":"`+tokens.RefreshToken+`"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var refreshed auth.TokenPair
+	if err := json.NewDecoder(w.Body).Decode(&refreshed); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if claims, err := testSigner.Verify(refreshed.AccessToken, auth.AccessToken); err != nil || !claims.Admin {
+		t.Fatalf("expected an admin access token, got %+v (%v)", claims, err)
+	}
+
+	// An access token cannot be used to refresh
+	w = serveRequest(t, http.MethodPost, "/auth/refresh", `{"refresh_token":"`+tokens.AccessToken+`"}`)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 for an access token, got %d", w.Code)
+	}
+
+	// Nor can the token of a deleted user
+	mock.ExpectQuery(`FROM users WHERE id = \$1`).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "is_admin", "created_at"}))
+
+	w = serveRequest(t, http.MethodPost, "/auth/refresh", `{"refresh_token":"`+tokens.RefreshToken+`"}`)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 for a deleted user, got %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestAuthenticateRejectsRequests(t *testing.T) {
+	forged := auth.Signer{Secret: []byte("another-secret-another-secret-another"), AccessTTL: time.Minute}
+	expired := testSigner
+	expired.AccessTTL = -time.Minute
+	refresh, err := testSigner.Issue(testUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		code          string
+	}{
+		{"missing", "", "unauthorized"},
+		{"other scheme", "Basic dGVzdGVyOnNlY3JldA==", "unauthorized"},
+		{"malformed", "Bearer not-a-token", "invalid_token"},
+		{"forged", bearerFrom(t, forged), "invalid_token"},
+		{"expired", bearerFrom(t, expired), "invalid_token"},
+		{"refresh token", "Bearer " + refresh.RefreshToken, "invalid_token"},
+	}
+
+	for _, tt := range tests {
+		w := serveRequestWithHeaders(t, http.MethodGet, "/contacts/1", "", map[string]string{"Authorization": tt.authorization})
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected status 401, got %d", tt.name, w.Code)
+			continue
+		}
+		if w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: expected a WWW-Authenticate header", tt.name)
+		}
+
+		var resp struct {
+			Code string `json:"code"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp.Code != tt.code {
+			t.Errorf("%s: expected code %s, got %q (%v)", tt.name, tt.code, resp.Code, err)
+		}
+	}
+
+	// No contact is read for a rejected request
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+// bearerFrom returns an Authorization header value for testUser signed by signer
+func bearerFrom(t *testing.T, signer auth.Signer) string {
+	tokens, err := signer.Issue(testUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "Bearer " + tokens.AccessToken
+}
+
+func TestCreateUserHandler(t *testing.T) {
+	admin := map[string]string{"Authorization": bearer(t, &auth.User{ID: 2, Username: "alice", Admin: true})}
+
+	// Only admins may create users
+	w := serveRequest(t, http.MethodPost, "/users", `{"username":"bob","password":"correct horse"}`)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected status 403 for a user, got %d", w.Code)
+	}
+
+	mock.ExpectQuery(`INSERT INTO users \(username, password_hash, is_admin\) VALUES \(\$1, \$2, \$3\) RETURNING id, created_at`).
+		WithArgs("bob", sqlmock.AnyArg(), false).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(3, time.Now()))
+
+	w = serveRequestWithHeaders(t, http.MethodPost, "/users", `{"username":"bob","password":"correct horse"}`, admin)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var user auth.User
+	if err := json.NewDecoder(w.Body).Decode(&user); err != nil || user.ID != 3 || user.Username != "bob" {
+		t.Fatalf("expected the created user, got %+v (%v)", user, err)
+	}
+
+	w = serveRequestWithHeaders(t, http.MethodPost, "/users", `{"username":"bob","password":"short"}`, admin)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status 422 for a short password, got %d", w.Code)
+	}
+
+	mock.ExpectQuery(`INSERT INTO users`).
+		WithArgs("Bob", sqlmock.AnyArg(), false).
+		WillReturnError(&pgconn.PgError{Code: "23505"})
+
+	w = serveRequestWithHeaders(t, http.MethodPost, "/users", `{"username":"Bob","password":"correct horse"}`, admin)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected status 409 for a taken username, got %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
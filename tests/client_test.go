@@ -2,9 +2,11 @@ package tests
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"phonebook/internal/api-gateway/http/client"
+	"phonebook/internal/auth"
 	"phonebook/internal/contacts"
 	"testing"
 )
@@ -36,7 +38,7 @@ func TestClientAddContact(t *testing.T) {
 	})
 
 	// Prepare test contact and make request
-	contact := contacts.Contact{FirstName: "John", LastName: "Doe", PhoneNumbers: []string{"1234567890"}}
+	contact := contacts.Contact{FirstName: "John", LastName: "Doe", Phones: []contacts.Phone{{Number: "1234567890"}}}
 	id, err := c.AddContact(&contact)
 	if err != nil {
 		t.Fatalf("AddContact failed: expected no error, got %v", err)
@@ -66,13 +68,66 @@ func TestClientUpdateContact(t *testing.T) {
 	})
 
 	// Prepare test contact and make request
-	contact := contacts.Contact{ID: 1, FirstName: "Jane", LastName: "Doe", PhoneNumbers: []string{"0987654321"}}
+	contact := contacts.Contact{ID: 1, FirstName: "Jane", LastName: "Doe", Phones: []contacts.Phone{{Number: "0987654321"}}}
 	err := c.UpdateContact(&contact)
 	if err != nil {
 		t.Fatalf("UpdateContact failed: expected no error, got %v", err)
 	}
 }
 
+func TestClientUpdateContactVersionMismatch(t *testing.T) {
+	_, c := setupMockServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-Match") != `"2"` {
+			http.Error(w, "precondition required", http.StatusPreconditionRequired)
+			return
+		}
+
+		// Respond with the copy someone else saved in the meantime
+		w.WriteHeader(http.StatusPreconditionFailed)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Contact was changed by someone else",
+			"details": map[string]interface{}{"contact": contacts.Contact{ID: 1, FirstName: "Janet", Version: 3}},
+		})
+	})
+
+	contact := contacts.Contact{ID: 1, FirstName: "Jane", LastName: "Doe", Version: 2}
+	err := c.UpdateContact(&contact)
+
+	var mismatch *contacts.VersionMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("UpdateContact failed: expected a version mismatch, got %v", err)
+	}
+	if mismatch.Current.FirstName != "Janet" || mismatch.Current.Version != 3 {
+		t.Fatalf("UpdateContact failed: expected the latest copy, got %+v", mismatch.Current)
+	}
+}
+
+func TestClientPatchContact(t *testing.T) {
+	_, c := setupMockServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch || r.URL.Path != "/contacts/1" || r.Header.Get("Content-Type") != contacts.MergePatchType {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		var patch map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil || patch["last_name"] != "Smith" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(contacts.Contact{ID: 1, FirstName: "John", LastName: "Smith", Version: 2})
+	})
+
+	contact, err := c.PatchContact(1, contacts.MergePatch{"last_name": "Smith"}, 0)
+	if err != nil {
+		t.Fatalf("PatchContact failed: expected no error, got %v", err)
+	}
+	if contact.LastName != "Smith" || contact.Version != 2 {
+		t.Fatalf("PatchContact failed: unexpected contact %+v", contact)
+	}
+}
+
 func TestClientSearchContacts(t *testing.T) {
 	_, c := setupMockServer(t, func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet || r.URL.Path != "/contacts/search" {
@@ -91,8 +146,8 @@ func TestClientSearchContacts(t *testing.T) {
 			Contacts []contacts.Contact `json:"contacts"`
 		}{
 			Contacts: []contacts.Contact{
-				{ID: 1, FirstName: "John", LastName: "Doe", PhoneNumbers: []string{"1234567890"}},
-				{ID: 2, FirstName: "Jane", LastName: "Doe", PhoneNumbers: []string{"0987654321"}},
+				{ID: 1, FirstName: "John", LastName: "Doe", Phones: []contacts.Phone{{Number: "1234567890"}}},
+				{ID: 2, FirstName: "Jane", LastName: "Doe", Phones: []contacts.Phone{{Number: "0987654321"}}},
 			},
 		}
 
@@ -115,3 +170,308 @@ func TestClientSearchContacts(t *testing.T) {
 		t.Fatalf("SearchContacts failed: unexpected contacts returned")
 	}
 }
+
+func TestClientGetContact(t *testing.T) {
+	_, c := setupMockServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/contacts/1" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(contacts.Contact{ID: 1, FirstName: "John", LastName: "Doe", Phones: []contacts.Phone{{Number: "1234567890"}}})
+	})
+
+	contact, err := c.GetContact(1)
+	if err != nil {
+		t.Fatalf("GetContact failed: expected no error, got %v", err)
+	}
+
+	if contact.ID != 1 || contact.FirstName != "John" {
+		t.Fatalf("GetContact failed: unexpected contact returned, got %+v", contact)
+	}
+}
+
+func TestClientDeleteContact(t *testing.T) {
+	_, c := setupMockServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete || r.URL.Path != "/contacts/1" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Contact deleted successfully"})
+	})
+
+	if err := c.DeleteContact(1); err != nil {
+		t.Fatalf("DeleteContact failed: expected no error, got %v", err)
+	}
+
+	if err := c.DeleteContact(2); err == nil {
+		t.Fatalf("DeleteContact failed: expected an error for a missing contact")
+	}
+}
+
+func TestClientTagContacts(t *testing.T) {
+	_, c := setupMockServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() != "/tags/Key%20customers/contacts" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		var req struct {
+			ContactIDs []int `json:"contact_ids"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.ContactIDs) != 2 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodPost {
+			json.NewEncoder(w).Encode(map[string]int{"tagged": 2})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]int{"untagged": 1})
+	})
+
+	tagged, err := c.TagContacts("Key customers", []int{1, 2})
+	if err != nil || tagged != 2 {
+		t.Fatalf("TagContacts failed: expected 2 tagged contacts, got %d, %v", tagged, err)
+	}
+	untagged, err := c.UntagContacts("Key customers", []int{1, 2})
+	if err != nil || untagged != 1 {
+		t.Fatalf("UntagContacts failed: expected 1 untagged contact, got %d, %v", untagged, err)
+	}
+	if _, err := c.UntagContacts("other", []int{1, 2}); !errors.Is(err, contacts.ErrTagNotFound) {
+		t.Fatalf("UntagContacts failed: expected ErrTagNotFound, got %v", err)
+	}
+}
+
+func TestClientIterateContacts(t *testing.T) {
+	_, c := setupMockServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/contacts" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		// Serve two pages linked by a cursor
+		page := contacts.Page{Contacts: []contacts.Contact{{ID: 1, FirstName: "Jane"}}, NextCursor: "page-2"}
+		if r.URL.Query().Get("cursor") == "page-2" {
+			page = contacts.Page{Contacts: []contacts.Contact{{ID: 2, FirstName: "John"}}}
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(page)
+	})
+
+	var ids []int
+	it := c.IterateContacts(contacts.SearchQuery{}, contacts.ListOptions{Limit: 1})
+	for it.Next() {
+		ids = append(ids, it.Contact().ID)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("IterateContacts failed: expected no error, got %v", err)
+	}
+
+	if len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+		t.Fatalf("IterateContacts failed: expected contacts 1 and 2, got %v", ids)
+	}
+}
+
+func TestClientIterateGroup(t *testing.T) {
+	_, c := setupMockServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.EscapedPath() != "/groups/Doe%20family/contacts" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(contacts.Page{Contacts: []contacts.Contact{{ID: 1, LastName: "Doe"}}})
+	})
+
+	it := c.IterateGroup("Doe family", contacts.ListOptions{})
+	if !it.Next() || it.Contact().ID != 1 || it.Next() {
+		t.Fatalf("IterateGroup failed: expected contact 1 alone, got %+v", it.Contact())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("IterateGroup failed: expected no error, got %v", err)
+	}
+
+	if it := c.IterateGroup("missing", contacts.ListOptions{}); it.Next() || it.Err() == nil {
+		t.Fatal("IterateGroup failed: expected an error for a missing group")
+	}
+}
+
+func TestClientSearchContactsInvalidQuery(t *testing.T) {
+	_, c := setupMockServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Invalid search query",
+			"code":    "invalid_query",
+			"details": map[string]interface{}{"position": 4, "message": "unexpected )"},
+		})
+	})
+
+	_, err := c.SearchContacts("Doe )")
+	var queryErr *contacts.QueryError
+	if !errors.As(err, &queryErr) || queryErr.Position != 4 {
+		t.Fatalf("SearchContacts failed: expected a QueryError at position 4, got %v", err)
+	}
+}
+
+func TestClientSuggest(t *testing.T) {
+	_, c := setupMockServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/contacts/suggest" || r.URL.Query().Get("prefix") != "jo d" || r.URL.Query().Get("limit") != "3" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string][]contacts.Suggestion{
+			"suggestions": {{ID: 1, Name: "John Doe", Number: "0912 123 4567"}},
+		})
+	})
+
+	suggestions, err := c.Suggest("jo d", 3)
+	if err != nil || len(suggestions) != 1 || suggestions[0].Name != "John Doe" {
+		t.Fatalf("Suggest failed: expected John Doe, got %+v, %v", suggestions, err)
+	}
+}
+
+func TestClientLookup(t *testing.T) {
+	_, c := setupMockServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path != "/lookup":
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		case r.URL.Query().Get("number") == "1234567":
+			http.Error(w, "ambiguous", http.StatusConflict)
+			return
+		case r.URL.Query().Get("number") != "+98 912 123 4567":
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(contacts.LookupResult{
+			Contact: contacts.Contact{ID: 1, FirstName: "John"},
+			Number:  "0912 123 4567",
+			Label:   "mobile",
+			Match:   contacts.MatchExact,
+		})
+	})
+
+	result, err := c.Lookup("+98 912 123 4567")
+	if err != nil || result.Contact.ID != 1 || result.Match != contacts.MatchExact {
+		t.Fatalf("Lookup failed: expected contact 1, got %+v, %v", result, err)
+	}
+	if _, err := c.Lookup("0000000"); !errors.Is(err, contacts.ErrNotFound) {
+		t.Fatalf("Lookup failed: expected ErrNotFound, got %v", err)
+	}
+	if _, err := c.Lookup("1234567"); !errors.Is(err, contacts.ErrAmbiguousMatch) {
+		t.Fatalf("Lookup failed: expected ErrAmbiguousMatch, got %v", err)
+	}
+}
+
+func TestClientLogin(t *testing.T) {
+	_, c := setupMockServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth/login":
+			var req map[string]string
+			json.NewDecoder(r.Body).Decode(&req)
+			if req["username"] != "alice" || req["password"] != "correct horse" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			json.NewEncoder(w).Encode(auth.TokenPair{AccessToken: "access-1", RefreshToken: "refresh-1", TokenType: "Bearer"})
+		case "/contacts/1":
+			if r.Header.Get("Authorization") != "Bearer access-1" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			json.NewEncoder(w).Encode(contacts.Contact{ID: 1, FirstName: "John"})
+		}
+	})
+
+	if _, err := c.GetContact(1); !errors.Is(err, auth.ErrUnauthorized) {
+		t.Fatalf("GetContact failed: expected ErrUnauthorized before logging in, got %v", err)
+	}
+	if _, err := c.Login("alice", "battery staple"); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Fatalf("Login failed: expected ErrInvalidCredentials, got %v", err)
+	}
+
+	tokens, err := c.Login("alice", "correct horse")
+	if err != nil || tokens.AccessToken != "access-1" {
+		t.Fatalf("Login failed: expected tokens, got %+v, %v", tokens, err)
+	}
+	if contact, err := c.GetContact(1); err != nil || contact.ID != 1 {
+		t.Fatalf("GetContact failed: expected contact 1, got %+v, %v", contact, err)
+	}
+}
+
+func TestClientRefreshesRejectedToken(t *testing.T) {
+	_, c := setupMockServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth/refresh":
+			var req map[string]string
+			json.NewDecoder(r.Body).Decode(&req)
+			if req["refresh_token"] != "refresh-1" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			json.NewEncoder(w).Encode(auth.TokenPair{AccessToken: "access-2", RefreshToken: "refresh-2", TokenType: "Bearer"})
+		case "/tags":
+			var tag contacts.Tag
+			if err := json.NewDecoder(r.Body).Decode(&tag); err != nil || tag.Name != "family" {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+			if r.Header.Get("Authorization") != "Bearer access-2" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(tag)
+		}
+	})
+
+	var saved *auth.TokenPair
+	c.SetTokens(&auth.TokenPair{AccessToken: "access-1", RefreshToken: "refresh-1"})
+	c.OnRefresh(func(tokens *auth.TokenPair) { saved = tokens })
+
+	// The request is sent again, body and all, with the refreshed token
+	tag, err := c.CreateTag("family")
+	if err != nil || tag.Name != "family" {
+		t.Fatalf("CreateTag failed: expected the tag, got %+v, %v", tag, err)
+	}
+	if saved == nil || saved.RefreshToken != "refresh-2" || c.Tokens().AccessToken != "access-2" {
+		t.Fatalf("expected the refreshed tokens to be kept and reported, got %+v", saved)
+	}
+
+	// Once the refresh token is refused too, the session is over
+	c.SetTokens(&auth.TokenPair{AccessToken: "access-1", RefreshToken: "refresh-0"})
+	if _, err := c.CreateTag("family"); !errors.Is(err, auth.ErrUnauthorized) {
+		t.Fatalf("CreateTag failed: expected ErrUnauthorized, got %v", err)
+	}
+}
+
+func TestClientAPIKey(t *testing.T) {
+	_, c := setupMockServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "pbk_secret" || r.Header.Get("Authorization") != "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(contacts.Contact{ID: 1, FirstName: "John"})
+	})
+
+	c.SetAPIKey("pbk_secret")
+	if contact, err := c.GetContact(1); err != nil || contact.ID != 1 {
+		t.Fatalf("GetContact failed: expected contact 1, got %+v, %v", contact, err)
+	}
+
+	c.SetAPIKey("pbk_revoked")
+	if _, err := c.GetContact(1); !errors.Is(err, auth.ErrUnauthorized) {
+		t.Fatalf("GetContact failed: expected ErrUnauthorized, got %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"phonebook/internal/contacts"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func decodeImportReport(t *testing.T, code int, body []byte) contacts.ImportReport {
+	t.Helper()
+	if code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", code, body)
+	}
+	var report contacts.ImportReport
+	if err := json.Unmarshal(body, &report); err != nil {
+		t.Fatalf("could not decode import report: %v", err)
+	}
+	return report
+}
+
+func TestImportCSVGoogleDryRun(t *testing.T) {
+	data := "Name,Given Name,Family Name,Phone 1 - Type,Phone 1 - Value,E-mail 1 - Type,E-mail 1 - Value\n" +
+		"John Doe,John,Doe,* Mobile,0912 123 4567 ::: 021 8888 1234,Work,john@example.com\n" +
+		",,,Mobile,0912 000 0000,,\n" +
+		"Jane Doe,Jane,Doe,Mobile,+98 912 123 4567,,\n" +
+		"Ali Karimi,Ali,Karimi,Home,021 2222 3333,,\n"
+
+	// Only Ali's number already belongs to a contact; nothing is written
+	mock.ExpectQuery(`SELECT e164, contact_id FROM phone_numbers WHERE e164 = ANY\(\$1::text\[\]\)`).
+		WithArgs(`{"+989121234567","+982188881234","+989121234567","+982122223333"}`).
+		WillReturnRows(sqlmock.NewRows([]string{"e164", "contact_id"}).AddRow("+982122223333", 9))
+
+	w := serveRequest(t, http.MethodPost, "/contacts/import/csv?dry_run=true", data)
+	report := decodeImportReport(t, w.Code, w.Body.Bytes())
+
+	if !report.DryRun || report.Created != 1 || report.Skipped != 2 || report.Failed != 1 {
+		t.Fatalf("unexpected import report, got %+v", report)
+	}
+
+	// The invalid row is reported as soon as it is read, the batch after it
+	byLine := map[int]contacts.ImportResult{}
+	for _, r := range report.Results {
+		byLine[r.Line] = r
+	}
+	if byLine[2].Status != contacts.ImportCreated || byLine[3].Status != contacts.ImportFailed ||
+		byLine[4].Status != contacts.ImportSkipped || byLine[5].Status != contacts.ImportSkipped {
+		t.Fatalf("unexpected import results, got %+v", report.Results)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestImportCSVOutlookBatches(t *testing.T) {
+	data := "First Name,Last Name,Mobile Phone,Business Phone,Home City\n" +
+		"John,Doe,0912 123 4567,,Tehran\n" +
+		"Jane,Doe,,021 8888 1234,\n" +
+		"Ali,Karimi,0912 555 6666,,\n"
+
+	insertContact := func(id int, first, last, e164, label string) {
+		mock.ExpectExec(`SAVEPOINT import_contact`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`INSERT INTO contacts`).
+			WithArgs(first, last, first, last, "").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "version"}).AddRow(id, time.Now(), 1))
+		mock.ExpectExec(`INSERT INTO phone_numbers`).
+			WithArgs(id, sqlmock.AnyArg(), e164, label, true, "").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+
+	// First batch of two contacts
+	mock.ExpectQuery(`SELECT e164, contact_id FROM phone_numbers`).
+		WillReturnRows(sqlmock.NewRows([]string{"e164", "contact_id"}))
+	mock.ExpectBegin()
+	insertContact(1, "John", "Doe", "+989121234567", "mobile")
+	mock.ExpectExec(`INSERT INTO addresses`).
+		WithArgs(1, "home", "", "Tehran", "Tehran", "", "", "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectRevisionBy(1, contacts.ActionCreate, testUser.Username)
+	mock.ExpectExec(`RELEASE SAVEPOINT import_contact`).WillReturnResult(sqlmock.NewResult(0, 0))
+	insertContact(2, "Jane", "Doe", "+982188881234", "work")
+	expectRevisionBy(2, contacts.ActionCreate, testUser.Username)
+	mock.ExpectExec(`RELEASE SAVEPOINT import_contact`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	// Second batch, whose only contact fails without aborting the transaction
+	mock.ExpectQuery(`SELECT e164, contact_id FROM phone_numbers`).
+		WillReturnRows(sqlmock.NewRows([]string{"e164", "contact_id"}))
+	mock.ExpectBegin()
+	mock.ExpectExec(`SAVEPOINT import_contact`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`INSERT INTO contacts`).WillReturnError(errors.New("connection reset"))
+	mock.ExpectExec(`ROLLBACK TO SAVEPOINT import_contact`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	w := serveRequest(t, http.MethodPost, "/contacts/import/csv?format=outlook&batch_size=2", data)
+	report := decodeImportReport(t, w.Code, w.Body.Bytes())
+
+	if report.DryRun || report.Created != 2 || report.Failed != 1 || len(report.Results) != 3 {
+		t.Fatalf("unexpected import report, got %+v", report)
+	}
+	if report.Results[0].ID != 1 || report.Results[1].ID != 2 || report.Results[2].Line != 4 {
+		t.Fatalf("unexpected import results, got %+v", report.Results)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestImportCSVDuplicateWithinBatch(t *testing.T) {
+	data := "First Name,Last Name,Mobile Phone\n" +
+		"John,Doe,0912 123 4567\n" +
+		"Jane,Doe,+98 912 123 4567\n"
+
+	// Jane repeats John's number, so only John reaches the database
+	mock.ExpectQuery(`SELECT e164, contact_id FROM phone_numbers`).
+		WillReturnRows(sqlmock.NewRows([]string{"e164", "contact_id"}))
+	mock.ExpectBegin()
+	mock.ExpectExec(`SAVEPOINT import_contact`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`INSERT INTO contacts`).
+		WithArgs("John", "Doe", "John", "Doe", "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "version"}).AddRow(1, time.Now(), 1))
+	mock.ExpectExec(`INSERT INTO phone_numbers`).
+		WithArgs(1, sqlmock.AnyArg(), "+989121234567", "mobile", true, "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectRevisionBy(1, contacts.ActionCreate, testUser.Username)
+	mock.ExpectExec(`RELEASE SAVEPOINT import_contact`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	w := serveRequest(t, http.MethodPost, "/contacts/import/csv?format=outlook", data)
+	report := decodeImportReport(t, w.Code, w.Body.Bytes())
+
+	if report.Created != 1 || report.Skipped != 1 || len(report.Results) != 2 {
+		t.Fatalf("unexpected import report, got %+v", report)
+	}
+	if want := "phone number +98 912 123 4567 is already on line 2"; report.Results[1].Reason != want {
+		t.Fatalf("expected reason %q, got %q", want, report.Results[1].Reason)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestImportCSVInvalidMapping(t *testing.T) {
+	w := serveRequest(t, http.MethodPost, `/contacts/import/csv?mapping={"Phone":"phones.1.colour"}`, "Phone\n0912 123 4567\n")
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status 422, got %d", w.Code)
+	}
+}
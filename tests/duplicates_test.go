@@ -0,0 +1,123 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"phonebook/internal/contacts"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestScoreDuplicateSamePhoneSwappedName(t *testing.T) {
+	a := contacts.Contact{FirstName: "John", LastName: "Doe", Phones: []contacts.Phone{{Number: "0912 123 4567", E164: "+989121234567"}}}
+	b := contacts.Contact{FirstName: "Doe", LastName: "John", Phones: []contacts.Phone{{Number: "+98 912 123 4567", E164: "+989121234567"}}}
+
+	score, reasons := contacts.ScoreDuplicate(a, b)
+	if score != 1 {
+		t.Fatalf("expected score 1, got %v", score)
+	}
+	if len(reasons) != 2 || reasons[0].Reason != contacts.ReasonSamePhone || reasons[1].Reason != contacts.ReasonSwappedName {
+		t.Fatalf("expected same phone and swapped name, got %+v", reasons)
+	}
+}
+
+func TestScoreDuplicatePhoneSuffix(t *testing.T) {
+	a := contacts.Contact{FirstName: "Ali", LastName: "Rezaei", Phones: []contacts.Phone{{E164: "+989121234567"}}}
+	b := contacts.Contact{FirstName: "Sara", LastName: "Karimi", Phones: []contacts.Phone{{E164: "+442071234567"}}}
+
+	score, reasons := contacts.ScoreDuplicate(a, b)
+	if score >= contacts.DefaultDuplicateScore {
+		t.Fatalf("expected a shared suffix alone to stay below the threshold, got %v", score)
+	}
+	if len(reasons) != 1 || reasons[0].Reason != contacts.ReasonPhoneSuffix {
+		t.Fatalf("expected a phone suffix reason, got %+v", reasons)
+	}
+
+	b.FirstName, b.LastName = "Ali", "Rezai"
+	if score, _ := contacts.ScoreDuplicate(a, b); score < contacts.DefaultDuplicateScore {
+		t.Fatalf("expected a shared suffix and similar name to be a duplicate, got %v", score)
+	}
+}
+
+func TestScoreDuplicateUnrelated(t *testing.T) {
+	a := contacts.Contact{FirstName: "John", LastName: "Doe", Phones: []contacts.Phone{{E164: "+989121234567"}}}
+	b := contacts.Contact{FirstName: "Maryam", LastName: "Ahmadi", Phones: []contacts.Phone{{E164: "+989357654321"}}}
+
+	if score, reasons := contacts.ScoreDuplicate(a, b); score != 0 || len(reasons) != 0 {
+		t.Fatalf("expected no match, got %v %+v", score, reasons)
+	}
+}
+
+func TestFindDuplicatesHandler(t *testing.T) {
+	now := time.Now()
+	mock.ExpectQuery(`SELECT a\.contact_id, b\.contact_id FROM phone_numbers a JOIN phone_numbers b ON right\(a\.e164, 7\) = right\(b\.e164, 7\) .+ UNION`).
+		WillReturnRows(sqlmock.NewRows([]string{"a", "b"}).AddRow(1, 2).AddRow(2, 3))
+	mock.ExpectQuery(`WHERE c\.id = ANY\(\$1::int\[\]\) AND c\.deleted_at IS NULL \) c WHERE TRUE ORDER BY c\.id ASC OFFSET 0`).
+		WithArgs("{1,2,3}").
+		WillReturnRows(sqlmock.NewRows(listColumns).
+			AddRow(1, "John", "Doe", "", now, nil, 1, 0, "[]", "[]", "[]", "[]", "0912 123 4567", "+989121234567", "mobile", true, "").
+			AddRow(2, "Jon", "Doe", "", now, nil, 1, 0, "[]", "[]", "[]", "[]", "+98 912 123 4567", "+989121234567", "work", true, "").
+			AddRow(3, "Jane", "Smith", "", now, nil, 1, 0, "[]", "[]", "[]", "[]", "021 3123 4567", "+982131234567", "home", true, ""))
+
+	w := serveRequest(t, http.MethodGet, "/contacts/duplicates", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Clusters []contacts.DuplicateCluster `json:"clusters"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if len(resp.Clusters) != 1 {
+		t.Fatalf("expected 1 cluster, got %+v", resp.Clusters)
+	}
+	cluster := resp.Clusters[0]
+	if len(cluster.Contacts) != 2 || cluster.Contacts[0].ID != 1 || cluster.Contacts[1].ID != 2 || len(cluster.Pairs) != 1 {
+		t.Fatalf("expected contacts 1 and 2 to be clustered, got %+v", cluster)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCreateContactHandlerChecksDuplicates(t *testing.T) {
+	now := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO contacts`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "version"}).AddRow(5, time.Now(), 1))
+	mock.ExpectExec(`INSERT INTO phone_numbers`).
+		WithArgs(5, "09121234567", "+989121234567", "mobile", true, "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectRevisionBy(5, contacts.ActionCreate, testUser.Username)
+	mock.ExpectCommit()
+	mock.ExpectQuery(`WHERE \(c\.id IN \(SELECT contact_id FROM phone_numbers WHERE right\(e164, 7\) = ANY\(\$1::text\[\]\)\)`).
+		WithArgs(`{"1234567"}`, "John Doe").
+		WillReturnRows(sqlmock.NewRows(listColumns).
+			AddRow(1, "John", "Doe", "", now, nil, 1, 0, "[]", "[]", "[]", "[]", "0912 123 4567", "+989121234567", "mobile", true, "").
+			AddRow(5, "John", "Doe", "", now, nil, 1, 0, "[]", "[]", "[]", "[]", "09121234567", "+989121234567", "mobile", true, ""))
+
+	w := serveRequest(t, http.MethodPost, "/contacts?check_duplicates=true", `{"first_name": "John", "last_name": "Doe", "phones": [{"number": "09121234567"}]}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		ContactID  int                       `json:"contact_id"`
+		Duplicates []contacts.DuplicateMatch `json:"duplicates"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if resp.ContactID != 5 || len(resp.Duplicates) != 1 || resp.Duplicates[0].Contact.ID != 1 {
+		t.Fatalf("expected contact 1 as the only duplicate, got %+v", resp)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,116 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"phonebook/internal/contacts"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestExporterCSV(t *testing.T) {
+	var buf bytes.Buffer
+	exporter, err := contacts.NewExporter(&buf, contacts.ExportCSV, []string{"id", "first_name", "phones", "emails"})
+	if err != nil {
+		t.Fatalf("NewExporter failed, expected no error, got %v", err)
+	}
+
+	contact := contacts.Contact{
+		ID:        1,
+		FirstName: "John, Jr.",
+		Phones:    []contacts.Phone{{Number: "0912 123 4567", Label: "mobile"}, {Number: "021 8888 1234", Label: "work", Extension: "12"}},
+	}
+	if err := exporter.Write(contact); err != nil {
+		t.Fatalf("Write failed, expected no error, got %v", err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatalf("Close failed, expected no error, got %v", err)
+	}
+
+	expected := "id,first_name,phones,emails\n1,\"John, Jr.\",mobile:0912 123 4567; work:021 8888 1234x12,\n"
+	if buf.String() != expected {
+		t.Fatalf("unexpected CSV export, got %q", buf.String())
+	}
+}
+
+func TestExporterEmptyJSON(t *testing.T) {
+	var buf bytes.Buffer
+	exporter, err := contacts.NewExporter(&buf, contacts.ExportJSON, nil)
+	if err != nil {
+		t.Fatalf("NewExporter failed, expected no error, got %v", err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatalf("Close failed, expected no error, got %v", err)
+	}
+	if buf.String() != "[]\n" {
+		t.Fatalf("expected an empty JSON array, got %q", buf.String())
+	}
+}
+
+func TestExporterRejectsUnknownFields(t *testing.T) {
+	_, err := contacts.NewExporter(&bytes.Buffer{}, "xml", []string{"id", "password"})
+
+	var verr *contacts.ValidationError
+	if !errors.As(err, &verr) || len(verr.Fields) != 2 {
+		t.Fatalf("expected format and fields errors, got %v", err)
+	}
+}
+
+func TestExportContactsHandlerNDJSON(t *testing.T) {
+	now := time.Now()
+	mock.ExpectQuery(`FROM contacts c WHERE \(c\.first_name_folded ILIKE .+\) AND c\.deleted_at IS NULL \) c WHERE TRUE ORDER BY c\.first_name ASC, c\.id ASC OFFSET 0 \) c LEFT JOIN phone_numbers p`).
+		WithArgs("Doe").
+		WillReturnRows(sqlmock.NewRows(listColumns).
+			AddRow(2, "Jane", "Doe", "", now, nil, 1, 0, "[]", "[]", "[]", "[]", "0987654321", nil, "mobile", true, "").
+			AddRow(2, "Jane", "Doe", "", now, nil, 1, 0, "[]", "[]", "[]", "[]", "0911111111", nil, "home", false, "").
+			AddRow(1, "John", "Doe", "", now, nil, 1, 0, "[]", "[]", "[]", "[]", nil, nil, nil, nil, nil))
+
+	w := serveRequest(t, http.MethodGet, "/contacts/export?format=ndjson&fields=id,phones&q=Doe", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/x-ndjson") {
+		t.Fatalf("expected an NDJSON content type, got %q", ct)
+	}
+
+	expected := `{"id":2,"phones":[{"number":"0987654321","label":"mobile","primary":true},{"number":"0911111111","label":"home","primary":false}]}` + "\n" +
+		`{"id":1,"phones":[]}` + "\n"
+	if w.Body.String() != expected {
+		t.Fatalf("unexpected NDJSON export, got %s", w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestExportHandlersAbortFailedStream(t *testing.T) {
+	now := time.Now()
+	for _, path := range []string{"/contacts/export?format=json", "/contacts/export.vcf"} {
+		// The first contact is written before the rows fail
+		mock.ExpectQuery(`FROM contacts c WHERE TRUE AND c\.deleted_at IS NULL`).
+			WillReturnRows(sqlmock.NewRows(listColumns).
+				AddRow(2, "Jane", "Doe", "", now, nil, 1, 0, "[]", "[]", "[]", "[]", "0987654321", nil, "mobile", true, "").
+				AddRow(1, "John", "Doe", "", now, nil, 1, 0, "[]", "[]", "[]", "[]", nil, nil, nil, nil, nil).
+				AddRow(3, "Mary", "Doe", "", now, nil, 1, 0, "[]", "[]", "[]", "[]", nil, nil, nil, nil, nil).
+				RowError(2, errors.New("connection reset")))
+
+		func() {
+			defer func() {
+				if r := recover(); r != http.ErrAbortHandler {
+					t.Errorf("%s: expected the response to be aborted, got %v", path, r)
+				}
+			}()
+			w := serveRequest(t, http.MethodGet, path, "")
+			t.Errorf("%s: expected the response to be aborted, got %d: %s", path, w.Code, w.Body.String())
+		}()
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"phonebook/internal/contacts"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var groupColumns = []string{"name", "query", "tag", "created_at", "updated_at"}
+
+func TestCreateGroupHandler(t *testing.T) {
+	now := time.Now()
+	mock.ExpectQuery(`INSERT INTO contact_groups \(name, query, tag\) VALUES \(\$1, \$2, \$3\) RETURNING created_at, updated_at`).
+		WithArgs("Doe family", sqlmock.AnyArg(), "Customers").
+		WillReturnRows(sqlmock.NewRows([]string{"created_at", "updated_at"}).AddRow(now, now))
+
+	w := serveRequest(t, http.MethodPost, "/groups", `{"name": " Doe family ", "query": {"q": "Doe"}, "tag": "Customers"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var group contacts.Group
+	if err := json.NewDecoder(w.Body).Decode(&group); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if group.Name != "Doe family" || group.Query.Text != "Doe" || group.Tag != "Customers" {
+		t.Fatalf("unexpected group, got %+v", group)
+	}
+
+	mock.ExpectQuery(`INSERT INTO contact_groups`).
+		WillReturnError(&pgconn.PgError{Code: "23505"})
+	if w := serveRequest(t, http.MethodPost, "/groups", `{"name": "doe family", "query": {"q": "Doe"}}`); w.Code != http.StatusConflict {
+		t.Fatalf("expected status 409 for a name in use, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := serveRequest(t, http.MethodPost, "/groups", `{"name": "Empty"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status 422 without a query or tag, got %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestListGroupsHandler(t *testing.T) {
+	now := time.Now()
+	mock.ExpectQuery(`SELECT name, query, tag, created_at, updated_at FROM contact_groups ORDER BY lower\(name\)`).
+		WillReturnRows(sqlmock.NewRows(groupColumns).
+			AddRow("Doe family", `{"q":"Doe"}`, "", now, now).
+			AddRow("Team", `{"q":""}`, "team", now, now))
+
+	w := serveRequest(t, http.MethodGet, "/groups", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Groups []contacts.Group `json:"groups"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if len(resp.Groups) != 2 || resp.Groups[0].Query.Text != "Doe" || resp.Groups[1].Tag != "team" {
+		t.Fatalf("unexpected groups, got %+v", resp.Groups)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGroupContactsHandler(t *testing.T) {
+	now := time.Now()
+	mock.ExpectQuery(`FROM contact_groups WHERE lower\(name\) = lower\(\$1\)`).
+		WithArgs("doe family").
+		WillReturnRows(sqlmock.NewRows(groupColumns).AddRow("Doe family", `{"q":"Doe"}`, "Customers", now, now))
+	mock.ExpectQuery(`WHERE \(c\.first_name_folded ILIKE .+\) AND EXISTS \(SELECT 1 FROM contact_tags ct JOIN tags t ON t\.id = ct\.tag_id WHERE ct\.contact_id = c\.id AND lower\(t\.name\) = lower\(\$2\)\) AND c\.deleted_at IS NULL \) c WHERE TRUE ORDER BY c\.first_name ASC, c\.id ASC LIMIT \$3`).
+		WithArgs("Doe", "Customers", contacts.DefaultPageSize+1).
+		WillReturnRows(sqlmock.NewRows(listColumns).
+			AddRow(1, "John", "Doe", "", now, nil, 1, 0, "[]", "[]", "[]", `["Customers"]`, "09121234567", "+989121234567", "mobile", true, ""))
+
+	w := serveRequest(t, http.MethodGet, "/groups/doe%20family/contacts", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var page contacts.Page
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if len(page.Contacts) != 1 || page.Contacts[0].ID != 1 {
+		t.Fatalf("expected the contact found by the group, got %+v", page.Contacts)
+	}
+
+	mock.ExpectQuery(`FROM contact_groups WHERE lower\(name\) = lower\(\$1\)`).
+		WithArgs("missing").
+		WillReturnRows(sqlmock.NewRows(groupColumns))
+	if w := serveRequest(t, http.MethodGet, "/groups/missing/contacts", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404 for a missing group, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestExportContactsHandlerGroup(t *testing.T) {
+	now := time.Now()
+	mock.ExpectQuery(`FROM contact_groups WHERE lower\(name\) = lower\(\$1\)`).
+		WithArgs("team").
+		WillReturnRows(sqlmock.NewRows(groupColumns).AddRow("Team", `{"q":""}`, "team", now, now))
+	mock.ExpectQuery(`FROM contacts c WHERE TRUE AND EXISTS \(SELECT 1 FROM contact_tags .+ lower\(\$1\)\) AND c\.deleted_at IS NULL`).
+		WithArgs("team").
+		WillReturnRows(sqlmock.NewRows(listColumns).
+			AddRow(1, "John", "Doe", "", now, nil, 1, 0, "[]", "[]", "[]", `["team"]`, nil, nil, nil, nil, nil))
+
+	w := serveRequest(t, http.MethodGet, "/contacts/export?format=ndjson&fields=id,last_name&group=team", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w.Body.String() != `{"id":1,"last_name":"Doe"}`+"\n" {
+		t.Fatalf("unexpected NDJSON export, got %s", w.Body.String())
+	}
+
+	if w := serveRequest(t, http.MethodGet, "/contacts/export?group=team&q=Doe", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 with both q and group, got %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,163 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	pbhttp "phonebook/internal/api-gateway/http"
+	"phonebook/internal/auth"
+	"phonebook/internal/contacts"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+// testSigner signs the tokens of test requests
+var testSigner = auth.Signer{
+	Secret:     []byte("test-secret-test-secret-test-secret"),
+	AccessTTL:  15 * time.Minute,
+	RefreshTTL: time.Hour,
+}
+
+// testUser is who test requests are made by unless they say otherwise
+var testUser = &auth.User{ID: 1, Username: "tester"}
+
+// bearer returns an Authorization header value signed in as user
+func bearer(t *testing.T, user *auth.User) string {
+	tokens, err := testSigner.Issue(user)
+	if err != nil {
+		t.Fatalf("could not issue tokens: %v", err)
+	}
+	return "Bearer " + tokens.AccessToken
+}
+
+// Helper function to send a request through the real router backed by the mock database
+func serveRequest(t *testing.T, method, path, body string) *httptest.ResponseRecorder {
+	return serveRequestWithHeaders(t, method, path, body, nil)
+}
+
+// Helper function to send a request with extra headers through the real
+// router; an Authorization header replaces the one of testUser
+func serveRequestWithHeaders(t *testing.T, method, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	router := pbhttp.NewRouter(mockDB, testSigner)
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", bearer(t, testUser))
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestGetContactHandlerNotFound(t *testing.T) {
+	mock.ExpectQuery(`SELECT c\.id, c\.first_name, c\.last_name, c\.note, c\.created_at, .+ FROM contacts c WHERE c\.id = \$1`).
+		WithArgs(42).
+		WillReturnRows(sqlmock.NewRows(contactColumns))
+
+	w := serveRequest(t, http.MethodGet, "/contacts/42", "")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", w.Code)
+	}
+
+	var resp pbhttp.ErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("could not decode error response: %v", err)
+	}
+	if resp.Code != "not_found" {
+		t.Fatalf("expected error code not_found, got %q", resp.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCreateContactHandlerValidation(t *testing.T) {
+	w := serveRequest(t, http.MethodPost, "/contacts", `{"first_name": "", "last_name": "", "phone_numbers": ["1", "1"]}`)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status 422, got %d", w.Code)
+	}
+
+	var resp struct {
+		Code    string `json:"code"`
+		Details struct {
+			Fields []struct {
+				Field string `json:"field"`
+			} `json:"fields"`
+		} `json:"details"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("could not decode error response: %v", err)
+	}
+	if resp.Code != "validation_failed" || len(resp.Details.Fields) != 2 {
+		t.Fatalf("expected 2 invalid fields, got %+v", resp)
+	}
+}
+
+func TestGetContactHandlerETag(t *testing.T) {
+	mock.ExpectQuery(`SELECT c\.id, c\.first_name, c\.last_name, c\.note, c\.created_at, c\.version, .+ FROM contacts c WHERE c\.id = \$1`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(contactColumns).AddRow(1, "John", "Doe", "", time.Now(), 4, "[]", "[]", "[]", "[]"))
+	mock.ExpectQuery(`SELECT number, e164, label, is_primary, extension FROM phone_numbers WHERE contact_id = \$1`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(phoneColumns))
+
+	w := serveRequest(t, http.MethodGet, "/contacts/1", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if etag := w.Header().Get("ETag"); etag != `"4"` {
+		t.Fatalf("expected ETag \"4\", got %q", etag)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestUpdateContactHandlerRequiresVersion(t *testing.T) {
+	body := `{"first_name": "Jane", "last_name": "Doe", "phones": [{"number": "09121234567"}]}`
+	if w := serveRequest(t, http.MethodPut, "/contacts/1", body); w.Code != http.StatusPreconditionRequired {
+		t.Fatalf("expected status 428 without a version, got %d", w.Code)
+	}
+	w := serveRequestWithHeaders(t, http.MethodPut, "/contacts/1", body, map[string]string{"If-Match": "4"})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for an unquoted If-Match, got %d", w.Code)
+	}
+}
+
+func TestUpdateContactHandlerVersionMismatch(t *testing.T) {
+	mock.ExpectBegin()
+	expectLock(1, false)
+	mock.ExpectRollback()
+
+	body := `{"first_name": "Jane", "last_name": "Doe", "phones": [{"number": "09121234567"}]}`
+	w := serveRequestWithHeaders(t, http.MethodPut, "/contacts/1", body, map[string]string{"If-Match": `"2"`})
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected status 412, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Code    string `json:"code"`
+		Details struct {
+			Contact contacts.Contact `json:"contact"`
+		} `json:"details"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("could not decode error response: %v", err)
+	}
+	if resp.Code != "version_mismatch" || resp.Details.Contact.ID != 1 || resp.Details.Contact.Version != 1 {
+		t.Fatalf("expected the current contact at version 1, got %+v", resp)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
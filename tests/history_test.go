@@ -0,0 +1,229 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	pbhttp "phonebook/internal/api-gateway/http"
+	"phonebook/internal/auth"
+	"phonebook/internal/contacts"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var revisionColumns = []string{"contact_id", "revision", "action", "actor", "before", "after", "created_at"}
+
+func TestDiffContacts(t *testing.T) {
+	from := &contacts.Contact{FirstName: "John", LastName: "Doe",
+		Phones: []contacts.Phone{{Number: "09121234567", Label: "mobile", Primary: true}}}
+	to := &contacts.Contact{FirstName: "John", LastName: "Smith",
+		Phones: []contacts.Phone{{Number: "09351234567", Label: "work", Primary: true}}}
+
+	changes, err := contacts.DiffContacts(from, to)
+	if err != nil {
+		t.Fatalf("DiffContacts failed, expected no error, got %v", err)
+	}
+	if len(changes) != 2 || changes[0].Field != "last_name" || changes[1].Field != "phones" {
+		t.Fatalf("expected last_name and phones to change, got %+v", changes)
+	}
+	if len(changes[1].Added) != 1 || len(changes[1].Removed) != 1 {
+		t.Fatalf("expected one phone added and one removed, got %+v", changes[1])
+	}
+
+	if changes, _ := contacts.DiffContacts(from, from); len(changes) != 0 {
+		t.Fatalf("expected no changes between equal contacts, got %+v", changes)
+	}
+}
+
+func TestHistoryHandler(t *testing.T) {
+	now := time.Now()
+	mock.ExpectQuery(`SELECT contact_id, revision, action, actor, before, after, created_at FROM contact_revisions WHERE contact_id = \$1 ORDER BY revision`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(revisionColumns).
+			AddRow(1, 1, "create", "alice", nil, `{"id":1,"first_name":"John","last_name":"Doe"}`, now).
+			AddRow(1, 2, "delete", "bob", `{"id":1,"first_name":"John","last_name":"Doe"}`, `{"id":1,"first_name":"John","last_name":"Doe","deleted_at":"2024-01-01T00:00:00Z"}`, now))
+
+	w := serveRequest(t, http.MethodGet, "/contacts/1/history", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Revisions []contacts.Revision `json:"revisions"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if len(resp.Revisions) != 2 || resp.Revisions[0].Before != nil || resp.Revisions[1].Actor != "bob" || resp.Revisions[1].After.DeletedAt == nil {
+		t.Fatalf("unexpected revisions, got %+v", resp.Revisions)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestHistoryHandlerNotFound(t *testing.T) {
+	mock.ExpectQuery(`FROM contact_revisions WHERE contact_id = \$1 ORDER BY revision`).
+		WithArgs(42).
+		WillReturnRows(sqlmock.NewRows(revisionColumns))
+
+	if w := serveRequest(t, http.MethodGet, "/contacts/42/history", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDiffRevisionsHandler(t *testing.T) {
+	now := time.Now()
+	mock.ExpectQuery(`FROM contact_revisions WHERE contact_id = \$1 AND revision = \$2`).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows(revisionColumns).
+			AddRow(1, 1, "create", "alice", nil, `{"id":1,"first_name":"John","phones":[{"number":"09121234567","label":"mobile"}]}`, now))
+	mock.ExpectQuery(`FROM contact_revisions WHERE contact_id = \$1 AND revision = \$2`).
+		WithArgs(1, 3).
+		WillReturnRows(sqlmock.NewRows(revisionColumns).
+			AddRow(1, 3, "update", "bob", nil, `{"id":1,"first_name":"John","phones":[{"number":"09351234567","label":"work"}]}`, now))
+
+	w := serveRequest(t, http.MethodGet, "/contacts/1/history/diff?from=1&to=3", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var diff contacts.RevisionDiff
+	if err := json.NewDecoder(w.Body).Decode(&diff); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if diff.From != 1 || diff.To != 3 || len(diff.Changes) != 1 || diff.Changes[0].Field != "phones" {
+		t.Fatalf("expected only phones to change, got %+v", diff)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDiffRevisionsHandlerRevisionNotFound(t *testing.T) {
+	mock.ExpectQuery(`FROM contact_revisions WHERE contact_id = \$1 AND revision = \$2`).
+		WithArgs(1, 9).
+		WillReturnRows(sqlmock.NewRows(revisionColumns))
+
+	w := serveRequest(t, http.MethodGet, "/contacts/1/history/diff?from=9&to=1", "")
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "Revision not found") {
+		t.Fatalf("expected status 404 for a missing revision, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := serveRequest(t, http.MethodGet, "/contacts/1/history/diff?from=1", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 without a to revision, got %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDeleteContactHandlerRecordsActor(t *testing.T) {
+	mock.ExpectBegin()
+	expectLock(1, false)
+	mock.ExpectQuery(`UPDATE contacts SET deleted_at = now\(\) WHERE id = \$1 RETURNING deleted_at`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"deleted_at"}).AddRow(time.Now()))
+	mock.ExpectExec(`INSERT INTO contact_revisions`).
+		WithArgs(1, contacts.ActionDelete, "alice", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	w := serveRequestWithHeaders(t, http.MethodDelete, "/contacts/1", "", map[string]string{"Authorization": bearer(t, &auth.User{ID: 2, Username: "alice"})})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRevertContactHandler(t *testing.T) {
+	now := time.Now()
+	mock.ExpectQuery(`FROM contact_revisions WHERE contact_id = \$1 AND revision = \$2`).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows(revisionColumns).
+			AddRow(1, 1, "create", "alice", nil, `{"id":1,"first_name":"Jon","last_name":"Doe","phones":[{"number":"0935 123 4567","label":"work"}]}`, now))
+	mock.ExpectBegin()
+	expectLock(1, false)
+	mock.ExpectQuery(`SELECT e164, contact_id FROM phone_numbers WHERE e164 = ANY\(\$1::text\[\]\)`).
+		WithArgs(`{"+989351234567"}`).
+		WillReturnRows(sqlmock.NewRows([]string{"e164", "contact_id"}))
+	mock.ExpectExec(`UPDATE contacts SET first_name = \$1, last_name = \$2, first_name_folded = \$3, last_name_folded = \$4, version = version \+ 1 WHERE id = \$5`).
+		WithArgs("Jon", "Doe", "Jon", "Doe", 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM phone_numbers WHERE contact_id = \$1`).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO phone_numbers`).
+		WithArgs(1, "0935 123 4567", "+989351234567", "work", true, "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectRevisionBy(1, contacts.ActionRevert, testUser.Username)
+	mock.ExpectCommit()
+
+	w := serveRequest(t, http.MethodPost, "/contacts/1/revert?revision=1", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var contact contacts.Contact
+	if err := json.NewDecoder(w.Body).Decode(&contact); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if contact.FirstName != "Jon" || len(contact.Phones) != 1 || contact.Phones[0].E164 != "+989351234567" {
+		t.Fatalf("expected the names and phones of revision 1, got %+v", contact)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRevertContactHandlerPhoneConflict(t *testing.T) {
+	now := time.Now()
+	mock.ExpectQuery(`FROM contact_revisions WHERE contact_id = \$1 AND revision = \$2`).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows(revisionColumns).
+			AddRow(1, 1, "create", "alice", nil, `{"id":1,"first_name":"Jon","last_name":"Doe","phones":[{"number":"0935 123 4567","label":"work"}]}`, now))
+	mock.ExpectBegin()
+	expectLock(1, false)
+	mock.ExpectQuery(`SELECT e164, contact_id FROM phone_numbers WHERE e164 = ANY\(\$1::text\[\]\)`).
+		WithArgs(`{"+989351234567"}`).
+		WillReturnRows(sqlmock.NewRows([]string{"e164", "contact_id"}).AddRow("+989351234567", 8))
+	mock.ExpectQuery(`WHERE c\.id = ANY\(\$1::int\[\]\) AND c\.deleted_at IS NULL \) c WHERE TRUE ORDER BY c\.id ASC OFFSET 0`).
+		WithArgs("{8}").
+		WillReturnRows(sqlmock.NewRows(listColumns).
+			AddRow(8, "Sara", "Karimi", "", now, nil, 1, 0, "[]", "[]", "[]", "[]", "09351234567", "+989351234567", "mobile", true, ""))
+	mock.ExpectRollback()
+
+	w := serveRequest(t, http.MethodPost, "/contacts/1/revert?revision=1", "")
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected status 409, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Code    string `json:"code"`
+		Details struct {
+			Conflicts []pbhttp.DuplicatePhoneDetails `json:"conflicts"`
+		} `json:"details"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("could not decode error response: %v", err)
+	}
+	if resp.Code != "phone_conflict" || len(resp.Details.Conflicts) != 1 || resp.Details.Conflicts[0].Owner.FirstName != "Sara" {
+		t.Fatalf("expected contact 8 as the blocking contact, got %+v", resp)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,143 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"phonebook/internal/contacts"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var (
+	lookupColumns         = []string{"contact_id", "number", "label"}
+	lookupFallbackColumns = []string{"contact_id", "number", "label", "national"}
+)
+
+// expectExactLookup expects a number to be looked up by its E.164 form,
+// found in rows
+func expectExactLookup(e164 string, rows *sqlmock.Rows) {
+	mock.ExpectQuery(`SELECT p\.contact_id, p\.number, p\.label FROM phone_numbers p JOIN contacts c ON c\.id = p\.contact_id AND c\.deleted_at IS NULL WHERE p\.e164 = \$1$`).
+		WithArgs(e164).
+		WillReturnRows(rows)
+}
+
+// expectFallbackLookup expects a number to be looked up by its last digits,
+// found in rows
+func expectFallbackLookup(suffix, nsn string, rows *sqlmock.Rows) {
+	mock.ExpectQuery(`SELECT p\.contact_id, p\.number, p\.label, .+ AS national FROM phone_numbers p JOIN contacts c ON c\.id = p\.contact_id AND c\.deleted_at IS NULL WHERE right\(p\.e164, 7\) = \$1 ORDER BY national DESC, p\.is_primary DESC, p\.contact_id`).
+		WithArgs(suffix, nsn).
+		WillReturnRows(rows)
+}
+
+func expectLookupContact(id int) {
+	mock.ExpectQuery(`SELECT c\.id, c\.first_name, c\.last_name, .+ FROM contacts c WHERE c\.id = \$1`).
+		WithArgs(id).
+		WillReturnRows(sqlmock.NewRows(contactColumns).AddRow(id, "John", "Doe", "", time.Now(), 1, "[]", "[]", "[]", "[]"))
+	mock.ExpectQuery(`SELECT number, e164, label, is_primary, extension FROM phone_numbers WHERE contact_id = \$1`).
+		WithArgs(id).
+		WillReturnRows(sqlmock.NewRows(phoneColumns).AddRow("0912 123 4567", "+989121234567", "mobile", true, ""))
+}
+
+func TestLookupHandler(t *testing.T) {
+	expectExactLookup("+989121234567", sqlmock.NewRows(lookupColumns).AddRow(1, "0912 123 4567", "mobile"))
+	expectLookupContact(1)
+
+	w := serveRequest(t, http.MethodGet, "/lookup?number="+url.QueryEscape("+98 912 123 4567"), "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var result contacts.LookupResult
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if result.Contact.ID != 1 || result.Label != "mobile" || result.Match != contacts.MatchExact {
+		t.Fatalf("unexpected lookup result, got %+v", result)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestLookupHandlerFallbacks(t *testing.T) {
+	// Caller IDs sent without the "+" are read in international form
+	expectExactLookup("+989121234567", sqlmock.NewRows(lookupColumns))
+	expectFallbackLookup("1234567", "9121234567", sqlmock.NewRows(lookupFallbackColumns).
+		AddRow(1, "912-123-4567", "work", true).
+		AddRow(2, "+1 555 123 4567", "mobile", false))
+	expectLookupContact(1)
+
+	w := serveRequest(t, http.MethodGet, "/lookup?number=989121234567", "")
+	var result contacts.LookupResult
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil || result.Match != contacts.MatchNational {
+		t.Fatalf("expected a national match, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// A number under an unknown country code still matches by its last digits
+	expectExactLookup("+999121234567", sqlmock.NewRows(lookupColumns))
+	expectFallbackLookup("1234567", "", sqlmock.NewRows(lookupFallbackColumns).
+		AddRow(1, "0912 123 4567", "mobile", false).
+		AddRow(1, "021 8123 4567", "home", false))
+	expectLookupContact(1)
+
+	w = serveRequest(t, http.MethodGet, "/lookup?number=%2B999121234567", "")
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil || result.Match != contacts.MatchSuffix || result.Label != "mobile" {
+		t.Fatalf("expected a suffix match, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestLookupHandlerNotFound(t *testing.T) {
+	expectExactLookup("+982188881234", sqlmock.NewRows(lookupColumns))
+	expectFallbackLookup("8881234", "2188881234", sqlmock.NewRows(lookupFallbackColumns))
+
+	if w := serveRequest(t, http.MethodGet, "/lookup?number=02188881234", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := serveRequest(t, http.MethodGet, "/lookup?number=anonymous", ""); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status 422 for a caller ID that is not a number, got %d", w.Code)
+	}
+	if w := serveRequest(t, http.MethodGet, "/lookup", ""); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status 422 without a number, got %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestLookupHandlerAmbiguous(t *testing.T) {
+	// Several contacts ending in the same digits are not told apart by
+	// which of them is primary
+	expectExactLookup("+999121234567", sqlmock.NewRows(lookupColumns))
+	expectFallbackLookup("1234567", "", sqlmock.NewRows(lookupFallbackColumns).
+		AddRow(3, "0912 123 4567", "mobile", false).
+		AddRow(2, "+44 20 7123 4567", "work", false))
+
+	w := serveRequest(t, http.MethodGet, "/lookup?number=%2B999121234567", "")
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected status 409, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Code    string                       `json:"code"`
+		Details contacts.AmbiguousMatchError `json:"details"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if resp.Code != "ambiguous_match" || resp.Details.Match != contacts.MatchSuffix || !reflect.DeepEqual(resp.Details.ContactIDs, []int{2, 3}) {
+		t.Fatalf("expected an ambiguous suffix match of contacts 2 and 3, got %+v", resp)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
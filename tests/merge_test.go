@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"phonebook/internal/contacts"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestMergeContactsHandler(t *testing.T) {
+	now := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id FROM contacts WHERE id = ANY\(\$1::int\[\]\) AND deleted_at IS NULL ORDER BY id FOR UPDATE`).
+		WithArgs("{1,2}").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	mock.ExpectQuery(`WHERE c\.id = ANY\(\$1::int\[\]\) AND c\.deleted_at IS NULL \) c WHERE TRUE ORDER BY c\.id ASC OFFSET 0`).
+		WithArgs("{1,2}").
+		WillReturnRows(sqlmock.NewRows(listColumns).
+			AddRow(1, "John", "Doe", "", now, nil, 1, 0, `[{"address":"john@example.com","label":"home"}]`, "[]", "[]", `["team"]`, "09121234567", "+989121234567", "mobile", true, "").
+			AddRow(2, "Johnny", "Doe", "Met at work", now, nil, 1, 0, `[{"address":"JOHN@example.com","label":"work"},{"address":"jd@example.com","label":"work"}]`, "[]", "[]", `["Customers","team"]`, "09351234567", "+989351234567", "work", true, ""))
+	mock.ExpectExec(`INSERT INTO contact_tags \(contact_id, tag_id\) SELECT DISTINCT \$1::int, tag_id FROM contact_tags WHERE contact_id = ANY\(\$2::int\[\]\) ON CONFLICT DO NOTHING`).
+		WithArgs(1, "{2}").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM contacts WHERE id = ANY\(\$1::int\[\]\)`).
+		WithArgs("{2}").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE contacts SET first_name = \$1, last_name = \$2, first_name_folded = \$3, last_name_folded = \$4, note = \$5, version = version \+ 1 WHERE id = \$6`).
+		WithArgs("Johnny", "Doe", "Johnny", "Doe", "", 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM phone_numbers WHERE contact_id = \$1`).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO phone_numbers`).
+		WithArgs(1, "09121234567", "+989121234567", "mobile", true, "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`INSERT INTO phone_numbers`).
+		WithArgs(1, "09351234567", "+989351234567", "work", false, "").
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	for _, table := range []string{"emails", "addresses", "websites"} {
+		mock.ExpectExec(`DELETE FROM ` + table + ` WHERE contact_id = \$1`).
+			WithArgs(1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectExec(`INSERT INTO emails`).
+		WithArgs(1, "john@example.com", "home").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`INSERT INTO emails`).
+		WithArgs(1, "jd@example.com", "work").
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	expectRevisionBy(1, contacts.ActionMerge, testUser.Username)
+	expectRevisionBy(2, contacts.ActionMerge, testUser.Username)
+	mock.ExpectQuery(`INSERT INTO contact_merges \(survivor_id, merged_ids, rules, contacts\) VALUES \(\$1, \$2::int\[\], \$3, \$4\) RETURNING id, created_at`).
+		WithArgs(1, "{2}", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(7, now))
+	mock.ExpectCommit()
+
+	w := serveRequest(t, http.MethodPost, "/contacts/merge", `{"survivor_id": 1, "contact_ids": [1, 2], "rules": {"first_name": "keep_longest"}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var result contacts.MergeResult
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if result.MergeID != 7 || result.Contact.ID != 1 || result.Contact.FirstName != "Johnny" || result.Contact.Note != "" {
+		t.Fatalf("unexpected merge result, got %+v", result)
+	}
+	if len(result.Contact.Phones) != 2 || len(result.Contact.Emails) != 2 {
+		t.Fatalf("expected phones and emails to be combined, got %+v", result.Contact)
+	}
+	if len(result.Contact.Tags) != 2 || result.Contact.Tags[0] != "Customers" || result.Contact.Tags[1] != "team" {
+		t.Fatalf("expected the tags of both contacts, got %v", result.Contact.Tags)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMergeContactsHandlerValidation(t *testing.T) {
+	w := serveRequest(t, http.MethodPost, "/contacts/merge", `{"survivor_id": 1, "contact_ids": [1], "rules": {"phones": "keep_longest", "avatar": "union"}}`)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status 422, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Details struct {
+			Fields []struct {
+				Field string `json:"field"`
+			} `json:"fields"`
+		} `json:"details"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("could not decode error response: %v", err)
+	}
+	if len(resp.Details.Fields) != 3 {
+		t.Fatalf("expected 3 invalid fields, got %+v", resp)
+	}
+}
+
+func TestMergeContactsHandlerNotFound(t *testing.T) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id FROM contacts WHERE id = ANY\(\$1::int\[\]\) AND deleted_at IS NULL ORDER BY id FOR UPDATE`).
+		WithArgs("{1,9}").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectRollback()
+
+	w := serveRequest(t, http.MethodPost, "/contacts/merge", `{"survivor_id": 1, "contact_ids": [9]}`)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"phonebook/internal/contacts"
+	"testing"
+)
+
+func TestContactUnmarshalLegacyPhoneNumbers(t *testing.T) {
+	var contact contacts.Contact
+	if err := json.Unmarshal([]byte(`{"first_name": "John", "phone_numbers": ["0912 123 4567", {"number": "021 8888 1234", "label": "work", "extension": "12"}]}`), &contact); err != nil {
+		t.Fatalf("Unmarshal failed, expected no error, got %v", err)
+	}
+
+	if len(contact.Phones) != 2 || contact.Phones[0].Number != "0912 123 4567" || contact.Phones[1].Label != "work" || contact.Phones[1].Extension != "12" {
+		t.Fatalf("unexpected phones, got %+v", contact.Phones)
+	}
+}
+
+func TestContactUnmarshalPhones(t *testing.T) {
+	var contact contacts.Contact
+	if err := json.Unmarshal([]byte(`{"first_name": "John", "phones": [{"number": "0912 123 4567", "label": "home", "primary": true}]}`), &contact); err != nil {
+		t.Fatalf("Unmarshal failed, expected no error, got %v", err)
+	}
+
+	if len(contact.Phones) != 1 || contact.Phones[0].Label != "home" || !contact.Phones[0].Primary {
+		t.Fatalf("unexpected phones, got %+v", contact.Phones)
+	}
+}
+
+func TestContactMarshalKeepsLegacyPhoneNumbers(t *testing.T) {
+	contact := contacts.Contact{ID: 1, FirstName: "John", Phones: []contacts.Phone{{Number: "0912 123 4567", Label: "mobile", Primary: true}}}
+	data, err := json.Marshal(contact)
+	if err != nil {
+		t.Fatalf("Marshal failed, expected no error, got %v", err)
+	}
+
+	// Older clients decode phone_numbers as a string array
+	var legacy struct {
+		ID           int      `json:"id"`
+		PhoneNumbers []string `json:"phone_numbers"`
+	}
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		t.Fatalf("legacy Unmarshal failed, expected no error, got %v", err)
+	}
+	if legacy.ID != 1 || len(legacy.PhoneNumbers) != 1 || legacy.PhoneNumbers[0] != "0912 123 4567" {
+		t.Fatalf("unexpected legacy contact, got %+v", legacy)
+	}
+}
+
+func TestContactValidateDetails(t *testing.T) {
+	contact := contacts.Contact{
+		FirstName: "John",
+		Emails:    []contacts.Email{{Address: "john@example.com"}, {Address: "not an email"}},
+		Addresses: []contacts.Address{{City: "Tehran"}, {}},
+		Websites:  []contacts.Website{{URL: "example.com/john"}, {URL: "ftp://example.com"}},
+	}
+
+	err := contact.Validate()
+	var verr *contacts.ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected a ValidationError, got %v", err)
+	}
+
+	fields := map[string]int{}
+	for _, f := range verr.Fields {
+		fields[f.Field]++
+	}
+	if len(verr.Fields) != 3 || fields["emails"] != 1 || fields["addresses"] != 1 || fields["websites"] != 1 {
+		t.Fatalf("expected one error for each invalid detail, got %+v", verr.Fields)
+	}
+}
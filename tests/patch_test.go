@@ -0,0 +1,296 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"phonebook/internal/contacts"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func decodeDoc(t *testing.T, data string) interface{} {
+	t.Helper()
+	var doc interface{}
+	if err := json.Unmarshal([]byte(data), &doc); err != nil {
+		t.Fatalf("could not decode document: %v", err)
+	}
+	return doc
+}
+
+func TestJSONPatchApply(t *testing.T) {
+	var patch contacts.JSONPatch
+	err := json.Unmarshal([]byte(`[
+		{"op": "test", "path": "/first_name", "value": "John"},
+		{"op": "add", "path": "/phones/-", "value": {"number": "0935 123 4567"}},
+		{"op": "remove", "path": "/phones/0"},
+		{"op": "replace", "path": "/last_name", "value": "Smith"},
+		{"op": "copy", "from": "/last_name", "path": "/note"},
+		{"op": "move", "from": "/a~1b", "path": "/c"}
+	]`), &patch)
+	if err != nil {
+		t.Fatalf("could not decode patch: %v", err)
+	}
+
+	doc := decodeDoc(t, `{"first_name": "John", "last_name": "Doe", "note": "", "a/b": 1, "phones": [{"number": "0912 123 4567"}]}`)
+	patched, err := patch.Apply(doc)
+	if err != nil {
+		t.Fatalf("Apply failed, expected no error, got %v", err)
+	}
+
+	expected := decodeDoc(t, `{"first_name": "John", "last_name": "Smith", "note": "Smith", "c": 1, "phones": [{"number": "0935 123 4567"}]}`)
+	if !reflect.DeepEqual(patched, expected) {
+		t.Fatalf("expected %v, got %v", expected, patched)
+	}
+}
+
+func TestJSONPatchApplyErrors(t *testing.T) {
+	for _, ops := range []string{
+		`[{"op": "test", "path": "/first_name", "value": "Jane"}]`,
+		`[{"op": "remove", "path": "/phones/1"}]`,
+		`[{"op": "add", "path": "/phones/01", "value": "1"}]`,
+		`[{"op": "replace", "path": "/middle_name", "value": "J"}]`,
+		`[{"op": "add", "path": "/note"}]`,
+		`[{"op": "rename", "path": "/note"}]`,
+	} {
+		var patch contacts.JSONPatch
+		if err := json.Unmarshal([]byte(ops), &patch); err != nil {
+			t.Fatalf("could not decode patch: %v", err)
+		}
+		doc := decodeDoc(t, `{"first_name": "John", "note": "", "phones": [{"number": "0912 123 4567"}]}`)
+		if _, err := patch.Apply(doc); !errors.Is(err, contacts.ErrValidation) {
+			t.Fatalf("expected a validation error for %s, got %v", ops, err)
+		}
+	}
+}
+
+func TestMergePatchApply(t *testing.T) {
+	patch := contacts.MergePatch{"last_name": "Smith", "note": nil, "phones": []interface{}{}}
+
+	doc := decodeDoc(t, `{"first_name": "John", "last_name": "Doe", "note": "old", "phones": [{"number": "0912 123 4567"}]}`)
+	patched, err := patch.Apply(doc)
+	if err != nil {
+		t.Fatalf("Apply failed, expected no error, got %v", err)
+	}
+
+	expected := decodeDoc(t, `{"first_name": "John", "last_name": "Smith", "phones": []}`)
+	if !reflect.DeepEqual(patched, expected) {
+		t.Fatalf("expected %v, got %v", expected, patched)
+	}
+}
+
+func TestPatchContactHandlerAddsPhone(t *testing.T) {
+	mock.ExpectBegin()
+	expectLock(1, false)
+	mock.ExpectExec(`INSERT INTO phone_numbers`).
+		WithArgs(1, "0935 123 4567", "+989351234567", "work", false, "").
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectExec(`UPDATE contacts SET first_name = \$1, last_name = \$2, first_name_folded = \$3, last_name_folded = \$4, note = \$5, version = version \+ 1 WHERE id = \$6`).
+		WithArgs("John", "Doe", "John", "Doe", "", 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectRevisionBy(1, contacts.ActionUpdate, testUser.Username)
+	mock.ExpectCommit()
+
+	w := serveRequestWithHeaders(t, http.MethodPatch, "/contacts/1", `[{"op": "add", "path": "/phones/-", "value": {"number": "0935 123 4567", "label": "work"}}]`,
+		map[string]string{"Content-Type": contacts.JSONPatchType, "If-Match": `"1"`})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if etag := w.Header().Get("ETag"); etag != `"2"` {
+		t.Fatalf("expected ETag \"2\", got %q", etag)
+	}
+
+	var contact contacts.Contact
+	if err := json.NewDecoder(w.Body).Decode(&contact); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if len(contact.Phones) != 2 || !contact.Phones[0].Primary || contact.Version != 2 {
+		t.Fatalf("expected the new phone next to the primary one, got %+v", contact)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPatchContactHandlerMergePatch(t *testing.T) {
+	mock.ExpectBegin()
+	expectLock(1, false)
+	mock.ExpectExec(`DELETE FROM phone_numbers WHERE contact_id = \$1 AND e164 = \$2`).
+		WithArgs(1, "+989121234567").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE contacts SET first_name = \$1, last_name = \$2, first_name_folded = \$3, last_name_folded = \$4, note = \$5, version = version \+ 1 WHERE id = \$6`).
+		WithArgs("John", "Smith", "John", "Smith", "", 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectRevisionBy(1, contacts.ActionUpdate, testUser.Username)
+	mock.ExpectCommit()
+
+	w := serveRequestWithHeaders(t, http.MethodPatch, "/contacts/1", `{"last_name": "Smith", "phones": null}`,
+		map[string]string{"Content-Type": contacts.MergePatchType})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+// expectLockWith expects contact id to be locked and read with emails and
+// one row for each of phones, given as number, e164, label and primary
+func expectLockWith(id int, emails string, phones ...[]interface{}) {
+	mock.ExpectQuery(`SELECT id FROM contacts WHERE id = ANY\(\$1::int\[\]\) AND deleted_at IS NULL ORDER BY id FOR UPDATE`).
+		WithArgs("{" + strconv.Itoa(id) + "}").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(id))
+	rows := sqlmock.NewRows(listColumns)
+	for _, p := range phones {
+		rows.AddRow(id, "John", "Doe", "", time.Now(), nil, 1, 0, emails, "[]", "[]", "[]", p[0], p[1], p[2], p[3], "")
+	}
+	mock.ExpectQuery(`WHERE c\.id = ANY\(\$1::int\[\]\) AND c\.deleted_at IS NULL \) c WHERE TRUE ORDER BY c\.id ASC OFFSET 0`).
+		WithArgs("{" + strconv.Itoa(id) + "}").
+		WillReturnRows(rows)
+}
+
+func TestPatchContactHandlerRemovesPhoneWithoutE164(t *testing.T) {
+	// The e164 backfill leaves numbers it cannot normalize without one
+	mock.ExpectBegin()
+	expectLockWith(1, "[]",
+		[]interface{}{"09121234567", "+989121234567", "mobile", true},
+		[]interface{}{"12", nil, "other", false})
+	mock.ExpectExec(`DELETE FROM phone_numbers WHERE contact_id = \$1 AND e164 IS NULL AND number = \$2`).
+		WithArgs(1, "12").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE contacts SET first_name = \$1`).
+		WithArgs("John", "Doe", "John", "Doe", "", 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectRevisionBy(1, contacts.ActionUpdate, testUser.Username)
+	mock.ExpectCommit()
+
+	w := serveRequestWithHeaders(t, http.MethodPatch, "/contacts/1", `[{"op": "remove", "path": "/phones/1"}]`,
+		map[string]string{"Content-Type": contacts.JSONPatchType})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPatchContactHandlerEmails(t *testing.T) {
+	const stored = `[{"address": "a@example.com", "label": "work"}, {"address": "b@example.com", "label": "home"}]`
+	phone := []interface{}{"09121234567", "+989121234567", "mobile", true}
+
+	tests := []struct {
+		patch  string
+		expect func()
+	}{
+		{
+			// The row of a replaced email is updated in place
+			`{"emails": [{"address": "b@example.com", "label": "home"}, {"address": "c@example.com", "label": "work"}]}`,
+			func() {
+				mock.ExpectExec(`UPDATE emails SET address = \$1, label = \$2 WHERE id = \$3`).
+					WithArgs("c@example.com", "work", 7).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			`{"emails": [{"address": "b@example.com", "label": "home"}]}`,
+			func() {
+				mock.ExpectExec(`DELETE FROM emails WHERE id = \$1`).
+					WithArgs(7).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			`{"emails": [{"address": "a@example.com", "label": "work"}, {"address": "b@example.com", "label": "home"}, {"address": "c@example.com"}]}`,
+			func() {
+				mock.ExpectExec(`INSERT INTO emails \(contact_id, address, label\) VALUES \(\$1, \$2, \$3\)`).
+					WithArgs(1, "c@example.com", "other").
+					WillReturnResult(sqlmock.NewResult(9, 1))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		mock.ExpectBegin()
+		expectLockWith(1, stored, phone)
+		mock.ExpectQuery(`SELECT id, address, label FROM emails WHERE contact_id = \$1 ORDER BY id`).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "address", "label"}).
+				AddRow(7, "a@example.com", "work").
+				AddRow(8, "b@example.com", "home"))
+		tt.expect()
+		mock.ExpectExec(`UPDATE contacts SET first_name = \$1`).
+			WithArgs("John", "Doe", "John", "Doe", "", 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectRevisionBy(1, contacts.ActionUpdate, testUser.Username)
+		mock.ExpectCommit()
+
+		w := serveRequestWithHeaders(t, http.MethodPatch, "/contacts/1", tt.patch,
+			map[string]string{"Content-Type": contacts.MergePatchType})
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d: %s", tt.patch, w.Code, w.Body.String())
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPatchContactHandlerNoChanges(t *testing.T) {
+	mock.ExpectBegin()
+	expectLock(1, false)
+	mock.ExpectRollback()
+
+	w := serveRequestWithHeaders(t, http.MethodPatch, "/contacts/1", `{"first_name": "John"}`,
+		map[string]string{"Content-Type": contacts.MergePatchType})
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"1"` {
+		t.Fatalf("expected status 200 at version 1, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPatchContactHandlerRejectsPatches(t *testing.T) {
+	w := serveRequestWithHeaders(t, http.MethodPatch, "/contacts/1", `first_name=Jane`, map[string]string{"Content-Type": "text/plain"})
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected status 415, got %d", w.Code)
+	}
+	w = serveRequestWithHeaders(t, http.MethodPatch, "/contacts/1", `{"op": "add"}`, map[string]string{"Content-Type": contacts.JSONPatchType})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for a patch that is not a list, got %d", w.Code)
+	}
+
+	// A version member in a merge patch is a precondition
+	mock.ExpectBegin()
+	expectLock(1, false)
+	mock.ExpectRollback()
+	w = serveRequestWithHeaders(t, http.MethodPatch, "/contacts/1", `{"last_name": "Smith", "version": 5}`,
+		map[string]string{"Content-Type": contacts.MergePatchType})
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected status 412, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Read-only and unknown fields cannot be patched
+	for _, patch := range []string{`[{"op": "replace", "path": "/id", "value": 2}]`, `[{"op": "add", "path": "/avatar", "value": "x"}]`} {
+		mock.ExpectBegin()
+		expectLock(1, false)
+		mock.ExpectRollback()
+		w = serveRequestWithHeaders(t, http.MethodPatch, "/contacts/1", patch, map[string]string{"Content-Type": contacts.JSONPatchType})
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("expected status 422 for %s, got %d: %s", patch, w.Code, w.Body.String())
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
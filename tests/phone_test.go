@@ -0,0 +1,57 @@
+package tests
+
+import (
+	"errors"
+	"phonebook/internal/contacts"
+	"testing"
+)
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		raw    string
+		region string
+		want   string
+	}{
+		{"0912 123 4567", "IR", "+989121234567"},
+		{"+98 912 1234567", "IR", "+989121234567"},
+		{"09121234567", "IR", "+989121234567"},
+		{"0098-912-123-4567", "IR", "+989121234567"},
+		{"(021) 8888 1234", "IR", "+982188881234"},
+		{"(212) 555-1234", "US", "+12125551234"},
+		{"1 212 555 1234", "us", "+12125551234"},
+		{"+44 20 7946 0958", "IR", "+442079460958"},
+		{"030 1234567", "DE", "+49301234567"},
+	}
+
+	for _, tt := range tests {
+		got, err := contacts.NormalizePhone(tt.raw, tt.region)
+		if err != nil {
+			t.Errorf("NormalizePhone(%q, %q) failed, expected no error, got %v", tt.raw, tt.region, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("NormalizePhone(%q, %q) = %q, expected %q", tt.raw, tt.region, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizePhoneInvalid(t *testing.T) {
+	tests := []struct {
+		raw    string
+		region string
+	}{
+		{"", "IR"},
+		{"call me", "IR"},
+		{"0912", "IR"},
+		{"0912 123 45678", "IR"},
+		{"+98 912 123", "IR"},
+		{"2125551234", "XX"},
+		{"+1234567890123456", "IR"},
+	}
+
+	for _, tt := range tests {
+		if _, err := contacts.NormalizePhone(tt.raw, tt.region); !errors.Is(err, contacts.ErrInvalidPhone) {
+			t.Errorf("NormalizePhone(%q, %q): expected ErrInvalidPhone, got %v", tt.raw, tt.region, err)
+		}
+	}
+}
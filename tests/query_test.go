@@ -0,0 +1,136 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"phonebook/internal/contacts"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestSearchContactsQueryLanguage(t *testing.T) {
+	repo := contacts.NewRepository(mockDB)
+
+	// Every value is a placeholder, numbered in the order the terms appear
+	mock.ExpectQuery(`FROM contacts c WHERE \(\(c\.last_name_folded ILIKE \$1 \|\| '%' AND \(lower\(c\.first_name_folded\) = lower\(\$2\) OR NOT \(EXISTS \(SELECT 1 FROM phone_numbers p WHERE p\.contact_id = c\.id AND p\.e164 LIKE \$3 \|\| '%'\)\)\) AND c\.id = \$4\)\) AND c\.deleted_at IS NULL`).
+		WithArgs("Doe", "Mary Ann", "+98912", 7, contacts.DefaultPageSize+1).
+		WillReturnRows(sqlmock.NewRows(listColumns).
+			AddRow(7, "Mary Ann", "Doe", "", time.Now(), nil, 1, 0, "[]", "[]", "[]", "[]", "02188881234", "+982188881234", "home", true, ""))
+
+	page, err := repo.SearchContacts(contacts.SearchQuery{Text: `last:Doe* AND (first:"Mary Ann" OR NOT phone:0912*) id:7`}, contacts.ListOptions{})
+	if err != nil {
+		t.Fatalf("SearchContacts failed, expected no error, got %v", err)
+	}
+	if len(page.Contacts) != 1 || page.Contacts[0].ID != 7 {
+		t.Fatalf("unexpected contacts returned, got %+v", page.Contacts)
+	}
+
+	// A full number is matched exactly, and an unscoped phrase anywhere
+	mock.ExpectQuery(`FROM contacts c WHERE \(\(EXISTS \(SELECT 1 FROM phone_numbers p WHERE p\.contact_id = c\.id AND p\.e164 = \$1\) AND \(c\.first_name_folded ILIKE '%' \|\| \$2 \|\| '%' .+\)\)\) AND c\.deleted_at IS NULL`).
+		WithArgs("+989121234567", "van der", contacts.DefaultPageSize+1).
+		WillReturnRows(sqlmock.NewRows(listColumns))
+
+	if _, err := repo.SearchContacts(contacts.SearchQuery{Text: `phone:"0912 123 4567" "van der"`}, contacts.ListOptions{}); err != nil {
+		t.Fatalf("SearchContacts failed, expected no error, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSearchContactsPlainQuery(t *testing.T) {
+	repo := contacts.NewRepository(mockDB)
+
+	// Text without any of the query language is still matched as a whole
+	mock.ExpectQuery(`FROM contacts c WHERE \(c\.first_name_folded ILIKE '%' \|\| \$1 \|\| '%' .+\) AND c\.deleted_at IS NULL`).
+		WithArgs("John Doe", contacts.DefaultPageSize+1).
+		WillReturnRows(sqlmock.NewRows(listColumns))
+
+	if _, err := repo.SearchContacts(contacts.SearchQuery{Text: "John  Doe"}, contacts.ListOptions{}); err != nil {
+		t.Fatalf("SearchContacts failed, expected no error, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSearchContactsLenientQuery(t *testing.T) {
+	repo := contacts.NewRepository(mockDB)
+
+	// Colons that do not follow a field name and operators that do not make
+	// up a query are searched for as plain text
+	for _, query := range []string{"12:30", "http://example.com", "NOT", "Smith OR", "AND Doe"} {
+		mock.ExpectQuery(`FROM contacts c WHERE \(c\.first_name_folded ILIKE '%' \|\| \$1 \|\| '%' .+\) AND c\.deleted_at IS NULL`).
+			WithArgs(query, contacts.DefaultPageSize+1).
+			WillReturnRows(sqlmock.NewRows(listColumns))
+
+		if _, err := repo.SearchContacts(contacts.SearchQuery{Text: query}, contacts.ListOptions{}); err != nil {
+			t.Errorf("SearchContacts(%q) failed, expected no error, got %v", query, err)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSearchContactsInvalidQuery(t *testing.T) {
+	repo := contacts.NewRepository(mockDB)
+
+	tests := []struct {
+		query    string
+		position int
+	}{
+		{"nick:Jo AND last:Doe", 1},
+		{"last:Doe nick:Jo", 10},
+		{"first:Jo*hn", 9},
+		{"last:", 6},
+		{"(Doe", 5},
+		{"last:Doe OR", 12},
+		{"Doe )", 5},
+		{`first:"Mary`, 7},
+		{"id:abc", 1},
+		{"AND last:Doe", 1},
+		{"phone:abc*", 1},
+	}
+
+	for _, tt := range tests {
+		_, err := repo.SearchContacts(contacts.SearchQuery{Text: tt.query}, contacts.ListOptions{})
+		var queryErr *contacts.QueryError
+		if !errors.As(err, &queryErr) {
+			t.Errorf("SearchContacts(%q): expected a QueryError, got %v", tt.query, err)
+			continue
+		}
+		if queryErr.Position != tt.position {
+			t.Errorf("SearchContacts(%q): expected position %d, got %d (%s)", tt.query, tt.position, queryErr.Position, queryErr.Message)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSearchContactsHandlerInvalidQuery(t *testing.T) {
+	w := serveRequest(t, http.MethodGet, "/contacts/search?q="+url.QueryEscape("last:Doe OR"), "")
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Code    string              `json:"code"`
+		Details contacts.QueryError `json:"details"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if resp.Code != "invalid_query" || resp.Details.Position != 12 {
+		t.Fatalf("expected the position of the missing operand, got %+v", resp)
+	}
+}
@@ -1,25 +1,66 @@
 package tests
 
 import (
+	"errors"
 	"phonebook/internal/contacts"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
+// Columns returned by listing and search queries, and by phone number lookups
+var (
+	contactColumns = []string{"id", "first_name", "last_name", "note", "created_at", "version", "emails", "addresses", "websites", "tags"}
+	listColumns    = []string{"id", "first_name", "last_name", "note", "created_at", "deleted_at", "version", "score", "emails", "addresses", "websites", "tags", "number", "e164", "label", "is_primary", "extension"}
+	phoneColumns   = []string{"number", "e164", "label", "is_primary", "extension"}
+)
+
+// expectRevision expects a revision of the contact to be recorded for the anonymous actor
+func expectRevision(contactID int, action string) {
+	expectRevisionBy(contactID, action, contacts.AnonymousActor)
+}
+
+// expectRevisionBy expects a revision recorded as made by actor, such as the
+// user a request was authenticated as
+func expectRevisionBy(contactID int, action, actor string) {
+	mock.ExpectExec(`INSERT INTO contact_revisions \(contact_id, revision, action, actor, before, after\)`).
+		WithArgs(contactID, action, actor, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+// expectLock expects a contact to be locked and loaded at the start of a
+// transaction, from the trash with trashed
+func expectLock(id int, trashed bool) {
+	state, deletedAt := "NULL", interface{}(nil)
+	if trashed {
+		state, deletedAt = "NOT NULL", time.Now()
+	}
+	mock.ExpectQuery(`SELECT id FROM contacts WHERE id = ANY\(\$1::int\[\]\) AND deleted_at IS ` + state + ` ORDER BY id FOR UPDATE`).
+		WithArgs("{" + strconv.Itoa(id) + "}").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(id))
+	mock.ExpectQuery(`WHERE c\.id = ANY\(\$1::int\[\]\) AND c\.deleted_at IS ` + state + ` \) c WHERE TRUE ORDER BY c\.id ASC OFFSET 0`).
+		WithArgs("{" + strconv.Itoa(id) + "}").
+		WillReturnRows(sqlmock.NewRows(listColumns).
+			AddRow(id, "John", "Doe", "", time.Now(), deletedAt, 1, 0, "[]", "[]", "[]", "[]", "09121234567", "+989121234567", "mobile", true, ""))
+}
+
 func TestCreateContactWithTransaction(t *testing.T) {
 	repo := contacts.NewRepository(mockDB)
 
-	contact := &contacts.Contact{FirstName: "John", LastName: "Doe", PhoneNumbers: []string{"1234567890"}}
+	contact := &contacts.Contact{FirstName: "John", LastName: "Doe", Phones: []contacts.Phone{{Number: "1234567890"}}}
 
 	// Set expectations for the mocked transaction
 	mock.ExpectBegin()
-	mock.ExpectQuery(`INSERT INTO contacts \(first_name, last_name\) VALUES \(\$1, \$2\) RETURNING id`).
-		WithArgs(contact.FirstName, contact.LastName).
-		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1)) // Return ID 1
-	mock.ExpectExec(`INSERT INTO phone_numbers \(contact_id, number\) VALUES \(\$1, \$2\)`).
-		WithArgs(1, "1234567890").
+	mock.ExpectQuery(`INSERT INTO contacts \(first_name, last_name, first_name_folded, last_name_folded, note\) VALUES \(\$1, \$2, \$3, \$4, \$5\) RETURNING id`).
+		WithArgs(contact.FirstName, contact.LastName, contact.FirstName, contact.LastName, contact.Note).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "version"}).AddRow(1, time.Now(), 1)) // Return ID 1
+	mock.ExpectExec(`INSERT INTO phone_numbers \(contact_id, number, e164, label, is_primary, extension\) VALUES \(\$1, \$2, \$3, \$4, \$5, \$6\)`).
+		WithArgs(1, "1234567890", "+981234567890", "mobile", true, "").
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectRevision(1, contacts.ActionCreate)
 	mock.ExpectCommit()
 
 	// Test CreateContact method
@@ -41,19 +82,40 @@ func TestCreateContactWithTransaction(t *testing.T) {
 func TestUpdateContactWithTransaction(t *testing.T) {
 	repo := contacts.NewRepository(mockDB)
 
-	contact := &contacts.Contact{ID: 1, FirstName: "Jane", LastName: "Doe", PhoneNumbers: []string{"0987654321"}}
+	contact := &contacts.Contact{ID: 1, FirstName: "Jane", LastName: "Doe",
+		Phones:    []contacts.Phone{{Number: "0912 123 4567", Label: "Work", Extension: "12"}},
+		Emails:    []contacts.Email{{Address: "jane@example.com", Label: "work"}},
+		Addresses: []contacts.Address{{City: "كرج", Country: "Iran"}},
+		Websites:  []contacts.Website{{URL: "example.com"}},
+	}
 
 	// Set expectations for the mocked transaction
 	mock.ExpectBegin()
-	mock.ExpectExec(`UPDATE contacts SET first_name = \$1, last_name = \$2 WHERE id = \$3`).
-		WithArgs(contact.FirstName, contact.LastName, contact.ID).
+	expectLock(contact.ID, false)
+	mock.ExpectExec(`UPDATE contacts SET first_name = \$1, last_name = \$2, first_name_folded = \$3, last_name_folded = \$4, note = \$5, version = version \+ 1 WHERE id = \$6`).
+		WithArgs(contact.FirstName, contact.LastName, contact.FirstName, contact.LastName, contact.Note, contact.ID).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 	mock.ExpectExec(`DELETE FROM phone_numbers WHERE contact_id = \$1`).
 		WithArgs(contact.ID).
 		WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectExec(`INSERT INTO phone_numbers \(contact_id, number\) VALUES \(\$1, \$2\)`).
-		WithArgs(contact.ID, "0987654321").
+	mock.ExpectExec(`INSERT INTO phone_numbers \(contact_id, number, e164, label, is_primary, extension\) VALUES \(\$1, \$2, \$3, \$4, \$5, \$6\)`).
+		WithArgs(contact.ID, "0912 123 4567", "+989121234567", "work", true, "12").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	for _, table := range []string{"emails", "addresses", "websites"} {
+		mock.ExpectExec(`DELETE FROM ` + table + ` WHERE contact_id = \$1`).
+			WithArgs(contact.ID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectExec(`INSERT INTO emails \(contact_id, address, label\) VALUES \(\$1, \$2, \$3\)`).
+		WithArgs(contact.ID, "jane@example.com", "work").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`INSERT INTO addresses \(contact_id, label, street, city, city_folded, region, postal_code, country\) VALUES \(.+\)`).
+		WithArgs(contact.ID, "other", "", "كرج", "کرج", "", "", "Iran").
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`INSERT INTO websites \(contact_id, url, label\) VALUES \(\$1, \$2, \$3\)`).
+		WithArgs(contact.ID, "https://example.com", "other").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectRevision(contact.ID, contacts.ActionUpdate)
 	mock.ExpectCommit()
 
 	// Test UpdateContact method
@@ -71,28 +133,36 @@ func TestUpdateContactWithTransaction(t *testing.T) {
 func TestSearchContactsWithTransaction(t *testing.T) {
 	repo := contacts.NewRepository(mockDB)
 
-	// Define expected mock behavior and rows to return
-	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "number"}).
-		AddRow(1, "John", "Doe", "1234567890").
-		AddRow(2, "Jane", "Doe", "0987654321")
+	// Define expected mock behavior and rows to return, grouped by contact in sort order
+	now := time.Now()
+	rows := sqlmock.NewRows(listColumns).
+		AddRow(2, "Jane", "Doe", "", now, nil, 1, 0, "[]", "[]", "[]", "[]", "0987654321", nil, "mobile", true, "").
+		AddRow(2, "Jane", "Doe", "", now, nil, 1, 0, "[]", "[]", "[]", "[]", "0911111111", nil, "mobile", true, "").
+		AddRow(1, "John", "Doe", "", now, nil, 1, 0, "[]", "[]", "[]", "[]", "1234567890", nil, "mobile", true, "")
 
 	// Set expectations for the mocked transaction
-	mock.ExpectQuery(`SELECT c\.id, c\.first_name, c\.last_name, p\.number FROM contacts c LEFT JOIN phone_numbers p ON c\.id = p\.contact_id WHERE c\.first_name ILIKE '%' \|\| \$1 \|\| '%' OR c\.last_name ILIKE '%' \|\| \$1 \|\| '%' OR p\.number ILIKE '%' \|\| \$1 \|\| '%'`).
-		WithArgs("Doe").
+	mock.ExpectQuery(`SELECT c\.id, c\.first_name, c\.last_name, c\.note, c\.created_at, c\.deleted_at, c\.version, c\.score, c\.emails, c\.addresses, c\.websites, c\.tags, p\.number, p\.e164, p\.label, p\.is_primary, p\.extension FROM \( .+ 0::float8 AS score FROM contacts c WHERE \(c\.first_name_folded ILIKE '%' \|\| \$1 \|\| '%' OR c\.last_name_folded ILIKE '%' \|\| \$1 \|\| '%' OR EXISTS \(.+\)\) AND c\.deleted_at IS NULL \) c WHERE TRUE ORDER BY c\.first_name ASC, c\.id ASC LIMIT \$2 \) c LEFT JOIN phone_numbers p ON c\.id = p\.contact_id ORDER BY c\.first_name ASC, c\.id ASC, p\.id`).
+		WithArgs("Doe", contacts.DefaultPageSize+1).
 		WillReturnRows(rows)
 
 	// Test SearchContacts method
-	contacts, err := repo.SearchContacts("Doe")
+	page, err := repo.SearchContacts(contacts.SearchQuery{Text: "Doe"}, contacts.ListOptions{})
 	if err != nil {
 		t.Fatalf("SearchContacts failed, expected no error, got %v", err)
 	}
 
 	// Validate results
-	if len(contacts) != 2 {
-		t.Fatalf("expected 2 contacts, got %d", len(contacts))
+	if len(page.Contacts) != 2 {
+		t.Fatalf("expected 2 contacts, got %d", len(page.Contacts))
+	}
+	if page.Contacts[0].FirstName != "Jane" || page.Contacts[1].FirstName != "John" {
+		t.Fatalf("unexpected contacts returned, got %+v", page.Contacts)
 	}
-	if contacts[0].FirstName != "John" || contacts[1].FirstName != "Jane" {
-		t.Fatalf("unexpected contacts returned, got %+v", contacts)
+	if len(page.Contacts[0].Phones) != 2 {
+		t.Fatalf("expected 2 phone numbers for Jane, got %v", page.Contacts[0].Phones)
+	}
+	if page.NextCursor != "" {
+		t.Fatalf("expected no next cursor on the last page, got %q", page.NextCursor)
 	}
 
 	// Verify expectations
@@ -100,3 +170,406 @@ func TestSearchContactsWithTransaction(t *testing.T) {
 		t.Errorf("unfulfilled expectations: %s", err)
 	}
 }
+
+func TestListContactsPagination(t *testing.T) {
+	repo := contacts.NewRepository(mockDB)
+
+	now := time.Now()
+	mock.ExpectQuery(`FROM contacts c WHERE TRUE AND c\.deleted_at IS NULL \) c WHERE TRUE ORDER BY c\.last_name DESC, c\.id DESC LIMIT \$1`).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows(listColumns).
+			AddRow(3, "Ali", "Zand", "", now, nil, 1, 0, "[]", "[]", "[]", "[]", nil, nil, nil, nil, nil).
+			AddRow(1, "John", "Doe", "", now, nil, 1, 0, "[]", "[]", "[]", "[]", "1234567890", nil, "mobile", true, ""))
+
+	page, err := repo.ListContacts(contacts.ListOptions{Limit: 1, Sort: contacts.SortLastName, Desc: true})
+	if err != nil {
+		t.Fatalf("ListContacts failed, expected no error, got %v", err)
+	}
+	if len(page.Contacts) != 1 || page.Contacts[0].ID != 3 || len(page.Contacts[0].Phones) != 0 {
+		t.Fatalf("unexpected first page, got %+v", page.Contacts)
+	}
+	if page.NextCursor == "" {
+		t.Fatalf("expected a next cursor")
+	}
+
+	// The cursor resumes strictly after the last contact of the previous page
+	mock.ExpectQuery(`WHERE \(c\.last_name, c\.id\) < \(\$1, \$2\) ORDER BY c\.last_name DESC, c\.id DESC LIMIT \$3`).
+		WithArgs("Zand", 3, 2).
+		WillReturnRows(sqlmock.NewRows(listColumns).
+			AddRow(1, "John", "Doe", "", now, nil, 1, 0, "[]", "[]", "[]", "[]", "1234567890", nil, "mobile", true, ""))
+
+	page, err = repo.ListContacts(contacts.ListOptions{Limit: 1, Sort: contacts.SortLastName, Desc: true, Cursor: page.NextCursor})
+	if err != nil {
+		t.Fatalf("ListContacts failed, expected no error, got %v", err)
+	}
+	if len(page.Contacts) != 1 || page.Contacts[0].ID != 1 || page.NextCursor != "" {
+		t.Fatalf("unexpected last page, got %+v", page)
+	}
+
+	// A cursor issued for one ordering is rejected for another
+	_, err = repo.ListContacts(contacts.ListOptions{Sort: contacts.SortFirstName, Cursor: "bm90LWEtY3Vyc29y"})
+	if !errors.Is(err, contacts.ErrValidation) {
+		t.Fatalf("expected ErrValidation for a bad cursor, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSearchContactsFuzzy(t *testing.T) {
+	repo := contacts.NewRepository(mockDB)
+
+	// Fuzzy searches are ranked by score, most relevant first
+	now := time.Now()
+	mock.ExpectQuery(`GREATEST\( ts_rank\(c\.search_vector, plainto_tsquery\('simple', \$1\)\), .+ WHERE \(c\.search_vector @@ plainto_tsquery\('simple', \$1\) .+ ORDER BY c\.score DESC, c\.id DESC LIMIT \$2`).
+		WithArgs("Jon Deo", 2).
+		WillReturnRows(sqlmock.NewRows(listColumns).
+			AddRow(1, "John", "Doe", "", now, nil, 1, 0.5, "[]", "[]", "[]", "[]", "1234567890", nil, "mobile", true, "").
+			AddRow(2, "Jane", "Doe", "", now, nil, 1, 0.25, "[]", "[]", "[]", "[]", "0987654321", nil, "mobile", true, ""))
+
+	page, err := repo.SearchContacts(contacts.SearchQuery{Text: "Jon Deo", Mode: contacts.SearchModeFuzzy}, contacts.ListOptions{Limit: 1})
+	if err != nil {
+		t.Fatalf("SearchContacts failed, expected no error, got %v", err)
+	}
+	if len(page.Contacts) != 1 || page.Contacts[0].ID != 1 || page.Contacts[0].Score != 0.5 {
+		t.Fatalf("unexpected contacts returned, got %+v", page.Contacts)
+	}
+
+	// The next page resumes after the score of the last contact
+	mock.ExpectQuery(`WHERE \(c\.score, c\.id\) < \(\$2::float8, \$3\) ORDER BY c\.score DESC, c\.id DESC LIMIT \$4`).
+		WithArgs("Jon Deo", "0.5", 1, 2).
+		WillReturnRows(sqlmock.NewRows(listColumns).
+			AddRow(2, "Jane", "Doe", "", now, nil, 1, 0.25, "[]", "[]", "[]", "[]", "0987654321", nil, "mobile", true, ""))
+
+	page, err = repo.SearchContacts(contacts.SearchQuery{Text: "Jon Deo", Mode: contacts.SearchModeFuzzy}, contacts.ListOptions{Limit: 1, Cursor: page.NextCursor})
+	if err != nil {
+		t.Fatalf("SearchContacts failed, expected no error, got %v", err)
+	}
+	if len(page.Contacts) != 1 || page.Contacts[0].ID != 2 {
+		t.Fatalf("unexpected contacts returned, got %+v", page.Contacts)
+	}
+
+	// Unknown modes are rejected before touching the database
+	_, err = repo.SearchContacts(contacts.SearchQuery{Text: "Doe", Mode: "regex"}, contacts.ListOptions{})
+	if !errors.Is(err, contacts.ErrValidation) {
+		t.Fatalf("expected ErrValidation for an unknown mode, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSearchContactsByFormattedNumber(t *testing.T) {
+	repo := contacts.NewRepository(mockDB)
+
+	// A number typed with a trunk prefix and spaces is matched by its digits against e164
+	mock.ExpectQuery(`OR EXISTS \(SELECT 1 FROM phone_numbers p WHERE p\.contact_id = c\.id AND p\.e164 LIKE '%' \|\| \$2 \|\| '%'\)\) AND c\.deleted_at IS NULL \) c WHERE TRUE`).
+		WithArgs("0912 123", "912123", contacts.DefaultPageSize+1).
+		WillReturnRows(sqlmock.NewRows(listColumns).
+			AddRow(1, "John", "Doe", "", time.Now(), nil, 1, 0, "[]", "[]", "[]", "[]", "+98 912 123 4567", nil, "mobile", true, ""))
+
+	page, err := repo.SearchContacts(contacts.SearchQuery{Text: "0912 123"}, contacts.ListOptions{})
+	if err != nil {
+		t.Fatalf("SearchContacts failed, expected no error, got %v", err)
+	}
+	if len(page.Contacts) != 1 {
+		t.Fatalf("expected 1 contact, got %d", len(page.Contacts))
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCreateContactInvalidPhone(t *testing.T) {
+	repo := contacts.NewRepository(mockDB)
+
+	// Invalid and repeated numbers are rejected before a transaction is opened
+	contact := &contacts.Contact{FirstName: "John", Phones: []contacts.Phone{{Number: "call me"}, {Number: "09121234567"}, {Number: "+98 912 123 4567"}}}
+	_, err := repo.CreateContact(contact)
+
+	var verr *contacts.ValidationError
+	if !errors.As(err, &verr) || len(verr.Fields) != 2 {
+		t.Fatalf("expected 2 invalid phone numbers, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCreateContactFoldsPersianText(t *testing.T) {
+	repo := contacts.NewRepository(mockDB)
+
+	// Arabic letters and Persian digits are folded for search but stored as typed for display
+	contact := &contacts.Contact{FirstName: "علي", LastName: "كريمي", Phones: []contacts.Phone{{Number: "۰۹۱۲ ۱۲۳ ۴۵۶۷"}}}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO contacts`).
+		WithArgs("علي", "كريمي", "علی", "کریمی", "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "version"}).AddRow(1, time.Now(), 1))
+	mock.ExpectExec(`INSERT INTO phone_numbers`).
+		WithArgs(1, "۰۹۱۲ ۱۲۳ ۴۵۶۷", "+989121234567", "mobile", true, "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectRevision(1, contacts.ActionCreate)
+	mock.ExpectCommit()
+
+	if _, err := repo.CreateContact(contact); err != nil {
+		t.Fatalf("CreateContact failed, expected no error, got %v", err)
+	}
+
+	// The query is folded the same way, so a Persian spelling finds the Arabic one
+	mock.ExpectQuery(`c\.first_name_folded ILIKE '%' \|\| \$1 \|\| '%'`).
+		WithArgs("علی", contacts.DefaultPageSize+1).
+		WillReturnRows(sqlmock.NewRows(listColumns).
+			AddRow(1, "علي", "كريمي", "", time.Now(), nil, 1, 0, "[]", "[]", "[]", "[]", "۰۹۱۲ ۱۲۳ ۴۵۶۷", nil, "mobile", true, ""))
+
+	page, err := repo.SearchContacts(contacts.SearchQuery{Text: "عل\u200cی"}, contacts.ListOptions{})
+	if err != nil {
+		t.Fatalf("SearchContacts failed, expected no error, got %v", err)
+	}
+	if len(page.Contacts) != 1 || page.Contacts[0].FirstName != "علي" {
+		t.Fatalf("expected the contact as typed, got %+v", page.Contacts)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSearchContactsMatchesEmailAndCity(t *testing.T) {
+	repo := contacts.NewRepository(mockDB)
+
+	mock.ExpectQuery(`FROM contacts c WHERE \(.+ OR EXISTS \(SELECT 1 FROM emails e WHERE e\.contact_id = c\.id AND e\.address ILIKE '%' \|\| \$1 \|\| '%'\) OR EXISTS \(SELECT 1 FROM addresses a WHERE a\.contact_id = c\.id AND a\.city_folded ILIKE '%' \|\| \$1 \|\| '%'\)\)`).
+		WithArgs("کرج", 21).
+		WillReturnRows(sqlmock.NewRows(listColumns).
+			AddRow(1, "John", "Doe", "", time.Now(), nil, 1, 0, "[]", `[{"label": "home", "city": "كرج"}]`, "[]", "[]", "1234567890", nil, "mobile", true, ""))
+
+	page, err := repo.SearchContacts(contacts.SearchQuery{Text: "كرج"}, contacts.ListOptions{})
+	if err != nil {
+		t.Fatalf("SearchContacts failed, expected no error, got %v", err)
+	}
+	if len(page.Contacts) != 1 || len(page.Contacts[0].Addresses) != 1 {
+		t.Fatalf("expected the contact with its address, got %+v", page.Contacts)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetContact(t *testing.T) {
+	repo := contacts.NewRepository(mockDB)
+
+	mock.ExpectQuery(`SELECT c\.id, c\.first_name, c\.last_name, c\.note, c\.created_at, .+ FROM contacts c WHERE c\.id = \$1`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(contactColumns).AddRow(1, "John", "Doe", "", time.Now(), 1,
+			`[{"address": "john@example.com", "label": "work"}]`,
+			`[{"label": "home", "street": "1 Main St", "city": "Tehran", "region": "", "postal_code": "", "country": "Iran"}]`,
+			"[]", "[]"))
+	mock.ExpectQuery(`SELECT number, e164, label, is_primary, extension FROM phone_numbers WHERE contact_id = \$1 ORDER BY id`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(phoneColumns).
+			AddRow("1234567890", "+981234567890", "mobile", true, "").
+			AddRow("021 8888 1234", "+982188881234", "work", false, "12"))
+
+	contact, err := repo.GetContact(1)
+	if err != nil {
+		t.Fatalf("GetContact failed, expected no error, got %v", err)
+	}
+
+	if contact.FirstName != "John" || len(contact.Phones) != 2 || contact.Phones[1].Label != "work" {
+		t.Fatalf("unexpected contact returned, got %+v", contact)
+	}
+	if len(contact.Emails) != 1 || contact.Emails[0].Address != "john@example.com" ||
+		len(contact.Addresses) != 1 || contact.Addresses[0].City != "Tehran" || contact.Websites == nil {
+		t.Fatalf("unexpected contact details, got %+v", contact)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetContactNotFound(t *testing.T) {
+	repo := contacts.NewRepository(mockDB)
+
+	mock.ExpectQuery(`SELECT c\.id, c\.first_name, c\.last_name, c\.note, c\.created_at, .+ FROM contacts c WHERE c\.id = \$1`).
+		WithArgs(42).
+		WillReturnRows(sqlmock.NewRows(contactColumns))
+
+	_, err := repo.GetContact(42)
+	if !errors.Is(err, contacts.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDeleteContact(t *testing.T) {
+	repo := contacts.NewRepository(mockDB)
+
+	mock.ExpectBegin()
+	expectLock(1, false)
+	mock.ExpectQuery(`UPDATE contacts SET deleted_at = now\(\) WHERE id = \$1 RETURNING deleted_at`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"deleted_at"}).AddRow(time.Now()))
+	expectRevision(1, contacts.ActionDelete)
+	mock.ExpectCommit()
+
+	if err := repo.DeleteContact(1); err != nil {
+		t.Fatalf("DeleteContact failed, expected no error, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDeleteContactNotFound(t *testing.T) {
+	repo := contacts.NewRepository(mockDB)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id FROM contacts WHERE id = ANY\(\$1::int\[\]\) AND deleted_at IS NULL ORDER BY id FOR UPDATE`).
+		WithArgs("{42}").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+
+	err := repo.DeleteContact(42)
+	if !errors.Is(err, contacts.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestUpdateContactNotFound(t *testing.T) {
+	repo := contacts.NewRepository(mockDB)
+
+	contact := &contacts.Contact{ID: 42, FirstName: "Jane", LastName: "Doe"}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id FROM contacts WHERE id = ANY\(\$1::int\[\]\) AND deleted_at IS NULL ORDER BY id FOR UPDATE`).
+		WithArgs("{42}").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+
+	err := repo.UpdateContact(contact)
+	if !errors.Is(err, contacts.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCreateContactDuplicatePhone(t *testing.T) {
+	repo := contacts.NewRepository(mockDB)
+
+	contact := &contacts.Contact{FirstName: "John", LastName: "Doe", Phones: []contacts.Phone{{Number: "1234567890"}}}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO contacts \(first_name, last_name, first_name_folded, last_name_folded, note\) VALUES \(\$1, \$2, \$3, \$4, \$5\) RETURNING id`).
+		WithArgs(contact.FirstName, contact.LastName, contact.FirstName, contact.LastName, contact.Note).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "version"}).AddRow(2, time.Now(), 1))
+	mock.ExpectExec(`INSERT INTO phone_numbers \(contact_id, number, e164, label, is_primary, extension\) VALUES \(\$1, \$2, \$3, \$4, \$5, \$6\)`).
+		WithArgs(2, "1234567890", "+981234567890", "mobile", true, "").
+		WillReturnError(&pgconn.PgError{Code: "23505"})
+	mock.ExpectQuery(`SELECT p\.contact_id, c\.deleted_at IS NOT NULL FROM phone_numbers p JOIN contacts c ON c\.id = p\.contact_id WHERE p\.e164 = \$1`).
+		WithArgs("+981234567890").
+		WillReturnRows(sqlmock.NewRows([]string{"contact_id", "trashed"}).AddRow(1, false))
+	mock.ExpectQuery(`SELECT c\.id, c\.first_name, c\.last_name, c\.note, c\.created_at, .+ FROM contacts c WHERE c\.id = \$1`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(contactColumns).AddRow(1, "Johnny", "Doe", "", time.Now(), 1, "[]", "[]", "[]", "[]"))
+	mock.ExpectQuery(`SELECT number, e164, label, is_primary, extension FROM phone_numbers WHERE contact_id = \$1 ORDER BY id`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(phoneColumns).AddRow("1234567890", "+981234567890", "mobile", true, ""))
+	mock.ExpectRollback()
+
+	_, err := repo.CreateContact(contact)
+	if !errors.Is(err, contacts.ErrDuplicatePhone) {
+		t.Fatalf("expected ErrDuplicatePhone, got %v", err)
+	}
+
+	var dupErr *contacts.DuplicatePhoneError
+	if !errors.As(err, &dupErr) || dupErr.Number != "1234567890" || dupErr.Owner == nil || dupErr.Owner.ID != 1 {
+		t.Fatalf("expected duplicate of 1234567890 owned by contact 1, got %+v", dupErr)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCreateContactPhoneOwnedByTrashedContact(t *testing.T) {
+	repo := contacts.NewRepository(mockDB)
+
+	contact := &contacts.Contact{FirstName: "John", LastName: "Doe", Phones: []contacts.Phone{{Number: "1234567890"}}}
+	deletedAt := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO contacts \(first_name, last_name, first_name_folded, last_name_folded, note\) VALUES \(\$1, \$2, \$3, \$4, \$5\) RETURNING id`).
+		WithArgs(contact.FirstName, contact.LastName, contact.FirstName, contact.LastName, contact.Note).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "version"}).AddRow(2, time.Now(), 1))
+	mock.ExpectExec(`INSERT INTO phone_numbers \(contact_id, number, e164, label, is_primary, extension\) VALUES \(\$1, \$2, \$3, \$4, \$5, \$6\)`).
+		WithArgs(2, "1234567890", "+981234567890", "mobile", true, "").
+		WillReturnError(&pgconn.PgError{Code: "23505"})
+	mock.ExpectQuery(`SELECT p\.contact_id, c\.deleted_at IS NOT NULL FROM phone_numbers p JOIN contacts c ON c\.id = p\.contact_id WHERE p\.e164 = \$1`).
+		WithArgs("+981234567890").
+		WillReturnRows(sqlmock.NewRows([]string{"contact_id", "trashed"}).AddRow(1, true))
+	mock.ExpectQuery(`WHERE c\.id = ANY\(\$1::int\[\]\) AND c\.deleted_at IS NOT NULL \) c WHERE TRUE ORDER BY c\.id ASC OFFSET 0`).
+		WillReturnRows(sqlmock.NewRows(listColumns).
+			AddRow(1, "Johnny", "Doe", "", time.Now(), deletedAt, 1, 0, "[]", "[]", "[]", "[]", "1234567890", "+981234567890", "mobile", true, ""))
+	mock.ExpectRollback()
+
+	_, err := repo.CreateContact(contact)
+	var dupErr *contacts.DuplicatePhoneError
+	if !errors.As(err, &dupErr) || !dupErr.Trashed || dupErr.Owner == nil || dupErr.Owner.ID != 1 || dupErr.Owner.DeletedAt == nil {
+		t.Fatalf("expected duplicate owned by trashed contact 1, got %+v", dupErr)
+	}
+	if want := "phone number 1234567890 belongs to contact 1 in the trash"; err.Error() != want {
+		t.Errorf("expected %q, got %q", want, err.Error())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCreateContactsDuplicateWithinBatch(t *testing.T) {
+	repo := contacts.NewRepository(mockDB)
+
+	batch := []*contacts.Contact{
+		{FirstName: "John", Phones: []contacts.Phone{{Number: "09121234567"}}},
+		{FirstName: "Ali", Phones: []contacts.Phone{{Number: "02122223333"}}},
+		{FirstName: "Jane", Phones: []contacts.Phone{{Number: "+989121234567"}}},
+	}
+
+	mock.ExpectQuery(`SELECT e164, contact_id FROM phone_numbers WHERE e164 = ANY\(\$1::text\[\]\)`).
+		WithArgs(`{"+989121234567","+982122223333","+989121234567"}`).
+		WillReturnRows(sqlmock.NewRows([]string{"e164", "contact_id"}))
+
+	errs, err := repo.CreateContacts(batch, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if errs[0] != nil || errs[1] != nil {
+		t.Fatalf("expected the first two contacts to pass, got %v", errs)
+	}
+
+	var batchErr *contacts.BatchDuplicatePhoneError
+	if !errors.As(errs[2], &batchErr) || batchErr.First != 0 || batchErr.Index != 2 || !errors.Is(errs[2], contacts.ErrDuplicatePhone) {
+		t.Fatalf("expected contact 2 to repeat the number of contact 0, got %v", errs[2])
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
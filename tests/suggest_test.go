@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"phonebook/internal/contacts"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var suggestColumns = []string{"id", "first_name", "last_name", "number"}
+
+func TestSuggestContactsHandler(t *testing.T) {
+	mock.ExpectQuery(`SELECT c\.id, c\.first_name, c\.last_name, COALESCE\(p\.number, ''\) FROM contacts c LEFT JOIN LATERAL \(.+\) p ON TRUE WHERE c\.deleted_at IS NULL AND \(lower\(c\.first_name_folded\) LIKE \$1 OR lower\(c\.last_name_folded\) LIKE \$1 OR .+\) ORDER BY CASE WHEN .+ END, lower\(c\.first_name_folded\), lower\(c\.last_name_folded\), c\.id LIMIT \$2`).
+		WithArgs("jo%", contacts.DefaultSuggestLimit).
+		WillReturnRows(sqlmock.NewRows(suggestColumns).
+			AddRow(1, "John", "Doe", "0912 123 4567").
+			AddRow(2, "", "Jones", ""))
+
+	w := serveRequest(t, http.MethodGet, "/contacts/suggest?prefix=Jo", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Suggestions []contacts.Suggestion `json:"suggestions"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if len(resp.Suggestions) != 2 || resp.Suggestions[0].Name != "John Doe" || resp.Suggestions[0].Number != "0912 123 4567" || resp.Suggestions[1].Name != "Jones" {
+		t.Fatalf("unexpected suggestions, got %+v", resp.Suggestions)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSuggestContactsHandlerNumber(t *testing.T) {
+	// A number is matched by the start of its E.164 form, whatever the formatting
+	mock.ExpectQuery(`WHERE c\.deleted_at IS NULL AND \(EXISTS \(SELECT 1 FROM phone_numbers pn WHERE pn\.contact_id = c\.id AND pn\.e164 LIKE \$1\)\) ORDER BY lower\(c\.first_name_folded\)`).
+		WithArgs("+98912%", 5).
+		WillReturnRows(sqlmock.NewRows(suggestColumns).AddRow(1, "John", "Doe", "0912 123 4567"))
+
+	if w := serveRequest(t, http.MethodGet, "/contacts/suggest?prefix=0912&limit=5", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// LIKE wildcards typed by the user are matched literally
+	mock.ExpectQuery(`FROM contacts c`).
+		WithArgs(`50\%%`, contacts.DefaultSuggestLimit).
+		WillReturnRows(sqlmock.NewRows(suggestColumns))
+
+	if w := serveRequest(t, http.MethodGet, "/contacts/suggest?prefix=50%25", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSuggestContactsHandlerInvalid(t *testing.T) {
+	// Nothing is suggested for an empty prefix, without touching the database
+	w := serveRequest(t, http.MethodGet, "/contacts/suggest?prefix=%20", "")
+	if w.Code != http.StatusOK || w.Body.String() != `{"suggestions":[]}` {
+		t.Fatalf("expected no suggestions, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := serveRequest(t, http.MethodGet, "/contacts/suggest?prefix=Jo&limit=100", ""); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status 422 for a limit over the maximum, got %d", w.Code)
+	}
+	if w := serveRequest(t, http.MethodGet, "/contacts/suggest?prefix=Jo&limit=ten", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for an invalid limit, got %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,214 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"phonebook/internal/contacts"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var tagColumns = []string{"name", "contacts", "created_at"}
+
+func TestListTagsHandler(t *testing.T) {
+	now := time.Now()
+	mock.ExpectQuery(`SELECT t\.name, COUNT\(c\.id\), t\.created_at FROM tags t .+ WHERE TRUE GROUP BY t\.id ORDER BY lower\(t\.name\)`).
+		WillReturnRows(sqlmock.NewRows(tagColumns).
+			AddRow("Customers", 12, now).
+			AddRow("team", 0, now))
+
+	w := serveRequest(t, http.MethodGet, "/tags", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Tags []contacts.Tag `json:"tags"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if len(resp.Tags) != 2 || resp.Tags[0].Name != "Customers" || resp.Tags[0].Contacts != 12 {
+		t.Fatalf("unexpected tags, got %+v", resp.Tags)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCreateTagHandler(t *testing.T) {
+	mock.ExpectQuery(`INSERT INTO tags \(name\) VALUES \(\$1\) RETURNING created_at`).
+		WithArgs("Suppliers").
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
+
+	w := serveRequest(t, http.MethodPost, "/tags", `{"name": "  Suppliers "}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	mock.ExpectQuery(`INSERT INTO tags`).
+		WithArgs("suppliers").
+		WillReturnError(&pgconn.PgError{Code: "23505"})
+	if w := serveRequest(t, http.MethodPost, "/tags", `{"name": "suppliers"}`); w.Code != http.StatusConflict {
+		t.Fatalf("expected status 409 for a name in use, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := serveRequest(t, http.MethodPost, "/tags", `{"name": "a/b"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status 422 for an invalid name, got %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRenameAndDeleteTagHandlers(t *testing.T) {
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE tags SET name = \$2 WHERE lower\(name\) = lower\(\$1\)`).
+		WithArgs("team", "Team").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE contact_groups SET tag = \$2 WHERE lower\(tag\) = lower\(\$1\)`).
+		WithArgs("team", "Team").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(`FROM tags t .+ WHERE lower\(t\.name\) = lower\(\$1\) GROUP BY t\.id`).
+		WithArgs("Team").
+		WillReturnRows(sqlmock.NewRows(tagColumns).AddRow("Team", 3, time.Now()))
+
+	w := serveRequest(t, http.MethodPut, "/tags/team", `{"name": "Team"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	mock.ExpectExec(`DELETE FROM tags WHERE lower\(name\) = lower\(\$1\)`).
+		WithArgs("team").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	if w := serveRequest(t, http.MethodDelete, "/tags/team", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404 for a missing tag, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestTagContactsHandler(t *testing.T) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO tags \(name\) VALUES \(\$1\) ON CONFLICT \(\(lower\(name\)\)\) DO UPDATE SET name = tags\.name RETURNING id`).
+		WithArgs("Customers").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM \(SELECT id FROM contacts WHERE id = ANY\(\$1::int\[\]\) AND deleted_at IS NULL FOR SHARE\) c`).
+		WithArgs("{1,2,2}").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectExec(`INSERT INTO contact_tags \(contact_id, tag_id\) SELECT DISTINCT unnest\(\$1::int\[\]\), \$2 ON CONFLICT DO NOTHING`).
+		WithArgs("{1,2,2}", 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := serveRequest(t, http.MethodPost, "/tags/Customers/contacts", `{"contact_ids": [1, 2, 2]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Tagged int64 `json:"tagged"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp.Tagged != 1 {
+		t.Fatalf("expected one newly tagged contact, got %s", w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestTagContactsHandlerContactNotFound(t *testing.T) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO tags`).
+		WithArgs("Customers").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM \(SELECT id FROM contacts`).
+		WithArgs("{1,9}").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectRollback()
+
+	if w := serveRequest(t, http.MethodPost, "/tags/Customers/contacts", `{"contact_ids": [1, 9]}`); w.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := serveRequest(t, http.MethodPost, "/tags/Customers/contacts", `{"contact_ids": []}`); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status 422 without contacts, got %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestUntagContactsHandler(t *testing.T) {
+	mock.ExpectQuery(`SELECT id FROM tags WHERE lower\(name\) = lower\(\$1\)`).
+		WithArgs("customers").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	mock.ExpectExec(`DELETE FROM contact_tags WHERE tag_id = \$1 AND contact_id = ANY\(\$2::int\[\]\)`).
+		WithArgs(4, "{1,2}").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	w := serveRequest(t, http.MethodDelete, "/tags/customers/contacts", `{"contact_ids": [1, 2]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	mock.ExpectQuery(`SELECT id FROM tags WHERE lower\(name\) = lower\(\$1\)`).
+		WithArgs("vendors").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	if w := serveRequest(t, http.MethodDelete, "/tags/vendors/contacts", `{"contact_ids": [1]}`); w.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404 for a missing tag, got %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestListContactsHandlerTagFilter(t *testing.T) {
+	now := time.Now()
+	mock.ExpectQuery(`WHERE \(c\.first_name_folded ILIKE .+\) AND EXISTS \(SELECT 1 FROM contact_tags ct JOIN tags t ON t\.id = ct\.tag_id WHERE ct\.contact_id = c\.id AND lower\(t\.name\) = lower\(\$2\)\) AND c\.deleted_at IS NULL \) c WHERE TRUE ORDER BY c\.first_name ASC, c\.id ASC LIMIT \$3`).
+		WithArgs("John", "customers", contacts.DefaultPageSize+1).
+		WillReturnRows(sqlmock.NewRows(listColumns).
+			AddRow(1, "John", "Doe", "", now, nil, 1, 0, "[]", "[]", "[]", `["Customers"]`, "09121234567", "+989121234567", "mobile", true, ""))
+
+	w := serveRequest(t, http.MethodGet, "/contacts/search?q=John&tag=customers", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var page contacts.Page
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if len(page.Contacts) != 1 || len(page.Contacts[0].Tags) != 1 || page.Contacts[0].Tags[0] != "Customers" {
+		t.Fatalf("expected the tagged contact, got %+v", page.Contacts)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPatchContactHandlerRejectsTags(t *testing.T) {
+	mock.ExpectBegin()
+	expectLock(1, false)
+	mock.ExpectRollback()
+
+	w := serveRequestWithHeaders(t, http.MethodPatch, "/contacts/1", `{"tags": ["team"]}`,
+		map[string]string{"Content-Type": contacts.MergePatchType})
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status 422, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,27 @@
+package tests
+
+import (
+	"phonebook/internal/contacts"
+	"testing"
+)
+
+func TestFoldText(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"John Doe", "John Doe"},
+		{"۰۹۱۲۱۲۳۴۵۶۷", "09121234567"},
+		{"٠٩١٢١٢٣٤٥٦٧", "09121234567"},
+		{"علي كريمي", "علی کریمی"},
+		{"فاطمة", "فاطمه"},
+		{"محمد\u200cرضا", "محمدرضا"},
+		{"  مـحـمّد   رضا ", "محمد رضا"},
+	}
+
+	for _, tt := range tests {
+		if got := contacts.FoldText(tt.in); got != tt.want {
+			t.Errorf("FoldText(%q) = %q, expected %q", tt.in, got, tt.want)
+		}
+	}
+}
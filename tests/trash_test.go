@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"phonebook/internal/contacts"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestTrashContactsHandler(t *testing.T) {
+	now := time.Now()
+	mock.ExpectQuery(`FROM contacts c WHERE TRUE AND c\.deleted_at IS NOT NULL \) c WHERE TRUE ORDER BY c\.first_name ASC, c\.id ASC LIMIT \$1`).
+		WithArgs(contacts.DefaultPageSize + 1).
+		WillReturnRows(sqlmock.NewRows(listColumns).
+			AddRow(3, "Ali", "Zand", "", now, now, 1, 0, "[]", "[]", "[]", "[]", "09121234567", "+989121234567", "mobile", true, ""))
+
+	w := serveRequest(t, http.MethodGet, "/contacts/trash", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var page contacts.Page
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if len(page.Contacts) != 1 || page.Contacts[0].DeletedAt == nil {
+		t.Fatalf("expected 1 trashed contact with its deletion time, got %+v", page.Contacts)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRestoreContactHandler(t *testing.T) {
+	mock.ExpectBegin()
+	expectLock(3, true)
+	mock.ExpectExec(`UPDATE contacts SET deleted_at = NULL WHERE id = \$1`).
+		WithArgs(3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectRevisionBy(3, contacts.ActionRestore, testUser.Username)
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id FROM contacts WHERE id = ANY\(\$1::int\[\]\) AND deleted_at IS NOT NULL ORDER BY id FOR UPDATE`).
+		WithArgs("{4}").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+
+	if w := serveRequest(t, http.MethodPost, "/contacts/3/restore", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := serveRequest(t, http.MethodPost, "/contacts/4/restore", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404 for a contact not in the trash, got %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPurgeContactHandler(t *testing.T) {
+	mock.ExpectExec(`WITH purged AS \( DELETE FROM contacts WHERE deleted_at IS NOT NULL AND id = \$1 RETURNING id \) INSERT INTO contact_revisions`).
+		WithArgs(3, testUser.Username).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if w := serveRequest(t, http.MethodDelete, "/contacts/trash/3", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	repo := contacts.NewRepository(mockDB)
+
+	before := time.Now().Add(-30 * 24 * time.Hour)
+	mock.ExpectExec(`DELETE FROM contacts WHERE deleted_at IS NOT NULL AND deleted_at < \$1 RETURNING id`).
+		WithArgs(before, contacts.AnonymousActor).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	purged, err := repo.PurgeTrash(before)
+	if err != nil {
+		t.Fatalf("PurgeTrash failed, expected no error, got %v", err)
+	}
+	if purged != 2 {
+		t.Fatalf("expected 2 purged contacts, got %d", purged)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
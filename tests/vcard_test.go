@@ -0,0 +1,127 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"phonebook/internal/contacts"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestVCardRoundTrip(t *testing.T) {
+	contact := contacts.Contact{
+		FirstName: "John",
+		LastName:  "Doe",
+		Phones: []contacts.Phone{
+			{Number: "0912 123 4567", E164: "+989121234567", Label: "mobile", Primary: true},
+			{Number: "021 8888 1234", E164: "+982188881234", Label: "work", Extension: "12"},
+		},
+		Emails:    []contacts.Email{{Address: "john@example.com", Label: "work"}},
+		Addresses: []contacts.Address{{Label: "home", Street: "1 Main St", City: "Tehran", Country: "Iran"}},
+		Websites:  []contacts.Website{{URL: "https://example.com", Label: "other"}},
+		Note:      "Met at the conference, bring coffee",
+	}
+
+	for _, version := range []string{contacts.VCardVersion3, contacts.VCardVersion4} {
+		var buf bytes.Buffer
+		if err := contacts.WriteVCards(&buf, version, contact); err != nil {
+			t.Fatalf("WriteVCards %s failed, expected no error, got %v", version, err)
+		}
+
+		cards, err := contacts.ReadVCards(&buf)
+		if err != nil {
+			t.Fatalf("ReadVCards %s failed, expected no error, got %v", version, err)
+		}
+		if len(cards) != 1 {
+			t.Fatalf("expected 1 card, got %d", len(cards))
+		}
+
+		got := cards[0]
+		if got.FirstName != "John" || got.LastName != "Doe" || got.Note != contact.Note {
+			t.Fatalf("vCard %s: unexpected contact, got %+v", version, got)
+		}
+		if len(got.Phones) != 2 || got.Phones[0].Number != "+989121234567" || !got.Phones[0].Primary ||
+			got.Phones[1].Label != "work" || got.Phones[1].Extension != "12" || got.Phones[1].Primary {
+			t.Fatalf("vCard %s: unexpected phones, got %+v", version, got.Phones)
+		}
+		if len(got.Emails) != 1 || got.Emails[0] != contact.Emails[0] {
+			t.Fatalf("vCard %s: unexpected emails, got %+v", version, got.Emails)
+		}
+		if len(got.Addresses) != 1 || got.Addresses[0] != contact.Addresses[0] {
+			t.Fatalf("vCard %s: unexpected addresses, got %+v", version, got.Addresses)
+		}
+		if len(got.Websites) != 1 || got.Websites[0].URL != "https://example.com" {
+			t.Fatalf("vCard %s: unexpected websites, got %+v", version, got.Websites)
+		}
+	}
+}
+
+func TestReadVCardsFromPhoneExport(t *testing.T) {
+	data := "BEGIN:VCARD\r\nVERSION:3.0\r\nFN:Jane Smith\r\nTEL;TYPE=HOME,VOICE:021 2222 3333\r\nTEL;TYPE=CELL,PREF:0912 555 6666\r\nEMAIL;TYPE=INTERNET:jane@example.com\r\nEND:VCARD\r\n" +
+		"BEGIN:VCARD\r\nVERSION:4.0\r\nN:Karimi;Ali;;;\r\nTEL;VALUE=uri:tel:+982188881234;ext=7\r\nEND:VCARD\r\n"
+
+	cards, err := contacts.ReadVCards(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadVCards failed, expected no error, got %v", err)
+	}
+	if len(cards) != 2 {
+		t.Fatalf("expected 2 cards, got %d", len(cards))
+	}
+
+	jane := cards[0]
+	if jane.FirstName != "Jane" || jane.LastName != "Smith" {
+		t.Fatalf("expected the formatted name to be split, got %q %q", jane.FirstName, jane.LastName)
+	}
+	if len(jane.Phones) != 2 || jane.Phones[0].Label != "home" || jane.Phones[0].Primary ||
+		jane.Phones[1].Label != "mobile" || !jane.Phones[1].Primary {
+		t.Fatalf("unexpected phones, got %+v", jane.Phones)
+	}
+	if len(jane.Emails) != 1 || jane.Emails[0].Label != "" {
+		t.Fatalf("unexpected emails, got %+v", jane.Emails)
+	}
+
+	ali := cards[1]
+	if ali.FirstName != "Ali" || ali.LastName != "Karimi" || len(ali.Phones) != 1 ||
+		ali.Phones[0].Number != "+982188881234" || ali.Phones[0].Extension != "7" {
+		t.Fatalf("unexpected contact, got %+v", ali)
+	}
+}
+
+func TestImportVCardHandler(t *testing.T) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO contacts`).
+		WithArgs("John", "Doe", "John", "Doe", "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "version"}).AddRow(7, time.Now(), 1))
+	mock.ExpectExec(`INSERT INTO phone_numbers`).
+		WithArgs(7, "+989121234567", "+989121234567", "mobile", true, "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectRevisionBy(7, contacts.ActionCreate, testUser.Username)
+	mock.ExpectCommit()
+
+	data := "BEGIN:VCARD\r\nVERSION:3.0\r\nN:Doe;John;;;\r\nTEL;TYPE=CELL:+989121234567\r\nEND:VCARD\r\n" +
+		"BEGIN:VCARD\r\nVERSION:3.0\r\nTEL:0912 000 0000\r\nEND:VCARD\r\n"
+
+	w := serveRequest(t, http.MethodPost, "/contacts/import", data)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	var report contacts.ImportReport
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatalf("could not decode import report: %v", err)
+	}
+	if report.Created != 1 || report.Failed != 1 || len(report.Results) != 2 {
+		t.Fatalf("unexpected import report, got %+v", report)
+	}
+	if report.Results[0].ID != 7 || report.Results[1].Status != contacts.ImportFailed || report.Results[1].Reason == "" {
+		t.Fatalf("unexpected import results, got %+v", report.Results)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
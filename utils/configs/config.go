@@ -2,6 +2,7 @@ package configs
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
@@ -10,7 +11,10 @@ import (
 // Config holds the application wide configurations.
 // The values are read by viper from the config file or environment variables.
 type Config struct {
-	PSQL PSQLConfig `mapstructure:"postgres"`
+	PSQL  PSQLConfig  `mapstructure:"postgres"`
+	Phone PhoneConfig `mapstructure:"phone"`
+	Trash TrashConfig `mapstructure:"trash"`
+	Auth  AuthConfig  `mapstructure:"auth"`
 }
 
 // PSQLConfig holds PostgreSQL connection configuration.
@@ -23,6 +27,30 @@ type PSQLConfig struct {
 	SSLMode  string `mapstructure:"ssl_mode"`
 }
 
+// PhoneConfig holds phone number parsing configuration.
+type PhoneConfig struct {
+	// DefaultRegion is the ISO 3166 region assumed for numbers without a country code.
+	DefaultRegion string `mapstructure:"default_region"`
+}
+
+// TrashConfig holds the retention of deleted contacts.
+type TrashConfig struct {
+	// Retention is how long deleted contacts stay in the trash before they are purged; 0 keeps them forever.
+	Retention time.Duration `mapstructure:"retention"`
+	// PurgeInterval is how often the trash is checked for contacts past their retention.
+	PurgeInterval time.Duration `mapstructure:"purge_interval"`
+}
+
+// AuthConfig holds the signing of access and refresh tokens.
+type AuthConfig struct {
+	// JWTSecret signs tokens; it can also be set with the PHONEBOOK_JWT_SECRET environment variable.
+	JWTSecret string `mapstructure:"jwt_secret"`
+	// AccessTTL is how long an access token is valid for.
+	AccessTTL time.Duration `mapstructure:"access_ttl"`
+	// RefreshTTL is how long a refresh token can be exchanged for new tokens, and so how long a login lasts.
+	RefreshTTL time.Duration `mapstructure:"refresh_ttl"`
+}
+
 var c *Config
 
 // C returns the loaded configuration globally.
@@ -42,6 +70,9 @@ func LoadConfig(path string) (*Config, error) {
 
 	// Read from environment variables
 	v.AutomaticEnv()
+	if err := v.BindEnv("auth.jwt_secret", "PHONEBOOK_JWT_SECRET"); err != nil {
+		return nil, err
+	}
 
 	// Try to read from config file, but continue if not found
 	v.AddConfigPath(path)
@@ -84,6 +115,11 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("postgres.password", "secret")
 	v.SetDefault("postgres.database", "psql_db")
 	v.SetDefault("postgres.ssl_mode", "disable")
+	v.SetDefault("phone.default_region", "IR")
+	v.SetDefault("trash.retention", "720h")
+	v.SetDefault("trash.purge_interval", "1h")
+	v.SetDefault("auth.access_ttl", "15m")
+	v.SetDefault("auth.refresh_ttl", "720h")
 }
 
 // validatePSQLConfig ensures that essential PSQL config values are present.